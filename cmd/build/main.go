@@ -25,6 +25,7 @@ var (
 	flagApp         string
 	flagAuth        string
 	flagDevelopment bool
+	flagHashEnv     bool
 	flagId          string
 	flagManifest    string
 	flagPrefix      string
@@ -50,6 +51,7 @@ func main() {
 	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
 	fs.StringVar(&flagApp, "app", "", "app name")
 	fs.BoolVar(&flagDevelopment, "development", false, "development build")
+	fs.BoolVar(&flagHashEnv, "hash-env", false, "include build args from the environment in the build hash")
 	fs.StringVar(&flagId, "id", "", "build id")
 	fs.StringVar(&flagManifest, "manifest", "convox.yml", "path to manifest")
 	fs.StringVar(&flagPrefix, "prefix", "", "image prefix")
@@ -68,6 +70,10 @@ func main() {
 		flagDevelopment = (v == "true")
 	}
 
+	if v := os.Getenv("BUILD_HASH_ENV"); v != "" {
+		flagHashEnv = (v == "true")
+	}
+
 	if v := os.Getenv("BUILD_ID"); v != "" {
 		flagId = v
 	}
@@ -206,6 +212,7 @@ func build() error {
 		// Cache:  cache,
 		Development: flagDevelopment,
 		Env:         manifest.Environment(env),
+		HashEnv:     flagHashEnv,
 		Push:        flagPush,
 		Root:        tmp,
 		Stdout:      w,
@@ -0,0 +1,9 @@
+package main
+
+var IsCertificateError = isCertificateError
+
+var TestServices = testServices
+
+type TestResult = testResult
+
+func SetProfile(name string) { profileName = name }
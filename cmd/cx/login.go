@@ -3,16 +3,21 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/net/idna"
 
 	"github.com/convox/praxis/stdcli"
 	cli "gopkg.in/urfave/cli.v1"
@@ -23,6 +28,32 @@ func init() {
 		Name:        "login",
 		Description: "log in to Convox",
 		Action:      runLogin,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "api-key",
+				EnvVar: "CONVOX_API_KEY",
+				Usage:  "log in with an existing api key instead of email and password",
+			},
+			cli.StringFlag{
+				Name:   "email",
+				EnvVar: "CONVOX_EMAIL",
+				Usage:  "email to log in with",
+			},
+			cli.StringFlag{
+				Name:   "password",
+				EnvVar: "CONVOX_PASSWORD",
+				Usage:  "password to log in with, read from stdin when it is not a terminal",
+			},
+			cli.BoolFlag{
+				Name:  "insecure",
+				Usage: "skip verification of the console certificate",
+			},
+			cli.DurationFlag{
+				Name:  "timeout",
+				Value: 10 * time.Second,
+				Usage: "timeout for requests to the console",
+			},
+		},
 	})
 }
 
@@ -47,12 +78,28 @@ func runLogin(c *cli.Context) error {
 		console = c.Args()[0]
 	}
 
-	fmt.Printf("Email: ")
+	client := loginClient(c)
+
+	if key := c.String("api-key"); key != "" {
+		return runLoginApiKey(client, console, key)
+	}
 
 	reader := bufio.NewReader(os.Stdin)
-	email, err := reader.ReadString('\n')
-	if err != nil {
-		return stdcli.Error(err)
+	tty := terminal.IsTerminal(int(os.Stdin.Fd()))
+
+	email := c.String("email")
+
+	if email == "" {
+		if tty {
+			fmt.Printf("Email: ")
+		}
+
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return stdcli.Error(err)
+		}
+
+		email = line
 	}
 
 	email = strings.TrimSpace(email)
@@ -60,25 +107,32 @@ func runLogin(c *cli.Context) error {
 		return stdcli.Errorf("Please provide a valid email")
 	}
 
-	fmt.Printf("Password: ")
+	pass := c.String("password")
 
-	pass, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-	if err != nil {
-		return stdcli.Error(err)
-	}
+	switch {
+	case pass != "":
+	case tty:
+		fmt.Printf("Password: ")
 
-	fmt.Println()
-	stdcli.Startf("Authenticating with <name>%s</name>", console)
+		data, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		if err != nil {
+			return stdcli.Error(err)
+		}
 
-	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
+		pass = string(data)
+
+		fmt.Println()
+	default:
+		line, err := reader.ReadString('\n')
+		if err != nil && line == "" {
+			return stdcli.Error(err)
+		}
 
-	var client = &http.Client{
-		Timeout:   time.Second * 10,
-		Transport: transport,
+		pass = strings.TrimRight(line, "\r\n")
 	}
 
+	stdcli.Startf("Authenticating with <name>%s</name>", console)
+
 	u := &url.URL{
 		Host:   console,
 		Path:   "/auth/api_key",
@@ -90,9 +144,12 @@ func runLogin(c *cli.Context) error {
 		return stdcli.Error(err)
 	}
 
-	req.SetBasicAuth(email, string(pass))
+	req.SetBasicAuth(email, pass)
 
 	response, err := client.Do(req)
+	if isCertificateError(err) {
+		return stdcli.Errorf("could not verify the certificate of %s, use --insecure to skip verification: %s", console, err)
+	}
 	if err != nil {
 		return stdcli.Error(err)
 	}
@@ -117,18 +174,193 @@ func runLogin(c *cli.Context) error {
 		return stdcli.Error(err)
 	}
 
-	u, err = url.Parse(p.Host)
+	proxy, err := ConsoleProxyURL(p.Host, p.ApiKey)
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	if err := setConsoleProxy(proxy); err != nil {
+		return stdcli.Error(err)
+	}
+
+	stdcli.OK()
+	return nil
+}
+
+// ConsoleProxyURL builds the proxy url stored after login from the host returned by the console,
+// keeping any port and path and always using https
+func ConsoleProxyURL(host, key string) (string, error) {
+	host = strings.TrimSpace(host)
+
+	if host == "" {
+		return "", fmt.Errorf("console did not return a proxy host")
+	}
+
+	if !strings.Contains(host, "://") {
+		host = "https://" + host
+	}
+
+	u, err := url.Parse(host)
+	if err != nil || u.Hostname() == "" {
+		return "", fmt.Errorf("console returned an invalid proxy host: %q", host)
+	}
+
+	name, err := idna.ToASCII(strings.ToLower(u.Hostname()))
+	if err != nil || !validProxyHostname(name) {
+		return "", fmt.Errorf("console returned an invalid proxy host: %q", host)
+	}
+
+	if port := u.Port(); port != "" {
+		if pi, err := strconv.Atoi(port); err != nil || pi < 1 || pi > 65535 {
+			return "", fmt.Errorf("console returned an invalid proxy port: %q", port)
+		}
+
+		name = net.JoinHostPort(name, port)
+	} else if strings.Contains(name, ":") {
+		name = "[" + name + "]"
+	}
+
+	pu := &url.URL{
+		Scheme: "https",
+		Host:   name,
+		Path:   strings.TrimSuffix(u.Path, "/"),
+		User:   url.UserPassword(key, ""),
+	}
+
+	return pu.String(), nil
+}
+
+func validProxyHostname(host string) bool {
+	if net.ParseIP(host) != nil {
+		return true
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func loginClient(c *cli.Context) *http.Client {
+	return &http.Client{
+		Timeout: c.Duration("timeout"),
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: c.Bool("insecure")},
+		},
+	}
+}
+
+// runLoginApiKey checks an existing api key against the console proxy and stores it without asking for a password
+func runLoginApiKey(client *http.Client, console, key string) error {
+	stdcli.Startf("Verifying api key with <name>%s</name>", console)
+
+	proxy, err := consoleProxyURLForKey(client, console, key)
+	if err == nil {
+		err = VerifyConsoleProxy(client, proxy)
+	}
+	if isCertificateError(err) {
+		return stdcli.Errorf("could not verify the certificate of %s, use --insecure to skip verification: %s", console, err)
+	}
 	if err != nil {
 		return stdcli.Error(err)
 	}
 
-	u.Scheme = "https"
-	u.User = url.UserPassword(p.ApiKey, "")
+	if err := setConsoleHost(console); err != nil {
+		return stdcli.Error(err)
+	}
 
-	if err := setConsoleProxy(u.String()); err != nil {
+	if err := setConsoleProxy(proxy); err != nil {
 		return stdcli.Error(err)
 	}
 
 	stdcli.OK()
 	return nil
 }
+
+// consoleProxyURLForKey asks the console where its rack proxy is for key, the console answers
+// /auth/proxy with the same host as /auth/api_key does for a password login
+func consoleProxyURLForKey(client *http.Client, console, key string) (string, error) {
+	u := &url.URL{Scheme: "https", Host: console, Path: "/auth/proxy"}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	req.SetBasicAuth(key, "")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return "", fmt.Errorf("invalid api key for %s", console)
+	}
+
+	var l Login
+
+	if err := json.NewDecoder(res.Body).Decode(&l); err != nil {
+		return "", fmt.Errorf("console responded with status %d", res.StatusCode)
+	}
+
+	if l.Error != "" {
+		return "", fmt.Errorf("%s", l.Error)
+	}
+
+	return ConsoleProxyURL(l.Host, key)
+}
+
+// VerifyConsoleProxy lists the racks through a proxy url from ConsoleProxyURL to check its api key
+func VerifyConsoleProxy(client *http.Client, proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return err
+	}
+
+	key := u.User.Username()
+
+	u.User = nil
+	u.Path += "/racks"
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	req.SetBasicAuth(key, "")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("invalid api key for %s", u.Host)
+	case res.StatusCode/100 != 2:
+		return fmt.Errorf("console responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+func isCertificateError(err error) bool {
+	var ua x509.UnknownAuthorityError
+	var ci x509.CertificateInvalidError
+	var he x509.HostnameError
+	var ve *tls.CertificateVerificationError
+
+	return errors.As(err, &ua) || errors.As(err, &ci) || errors.As(err, &he) || errors.As(err, &ve)
+}
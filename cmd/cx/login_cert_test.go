@@ -0,0 +1,40 @@
+package main_test
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsCertificateError(t *testing.T) {
+	console := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer console.Close()
+
+	// the test server certificate is self signed so verification fails
+	_, err := http.Get(console.URL)
+	if assert.Error(t, err) {
+		assert.True(t, cx.IsCertificateError(err), err.Error())
+	}
+
+	insecure := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+
+	res, err := insecure.Get(console.URL)
+	if assert.NoError(t, err) {
+		res.Body.Close()
+	}
+
+	console.Close()
+
+	_, err = insecure.Get(console.URL)
+	if assert.Error(t, err) {
+		assert.False(t, cx.IsCertificateError(err), err.Error())
+	}
+
+	assert.False(t, cx.IsCertificateError(nil))
+	assert.False(t, cx.IsCertificateError(errors.New("timeout")))
+}
@@ -0,0 +1,128 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+// stdin runs fn with input available on stdin
+func stdin(t *testing.T, input string, fn func()) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Write([]byte(input))
+	w.Close()
+
+	in := os.Stdin
+	os.Stdin = r
+
+	fn()
+
+	os.Stdin = in
+	r.Close()
+}
+
+func TestLoginCredentials(t *testing.T) {
+	var logins []string
+
+	console := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, pass, _ := r.BasicAuth()
+		logins = append(logins, email+":"+pass)
+
+		json.NewEncoder(w).Encode(map[string]string{"api_key": "key", "host": "proxy.example.org"})
+	}))
+	defer console.Close()
+
+	host := strings.TrimPrefix(console.URL, "https://")
+
+	dir, cleanup := consoleHome(t, host)
+	defer cleanup()
+
+	// piped stdin provides the email and password on separate lines
+	stdin(t, "piped@example.org\npiped secret\n", func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "login", "--insecure", host}))
+	})
+
+	// flags do not need stdin
+	stdin(t, "", func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "login", "--insecure", "--email", "flag@example.org", "--password", "flag", host}))
+	})
+
+	// the environment provides defaults for the flags
+	os.Setenv("CONVOX_EMAIL", "env@example.org")
+	os.Setenv("CONVOX_PASSWORD", "env")
+	defer os.Unsetenv("CONVOX_EMAIL")
+	defer os.Unsetenv("CONVOX_PASSWORD")
+
+	stdin(t, "", func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "login", "--insecure", host}))
+	})
+
+	assert.Equal(t, []string{"piped@example.org:piped secret", "flag@example.org:flag", "env@example.org:env"}, logins)
+
+	assert.Equal(t, "https://key:@proxy.example.org", storedProfiles(t, dir).Profiles["default"].Proxy)
+
+	os.Unsetenv("CONVOX_EMAIL")
+
+	stdin(t, "", func() {
+		assert.Error(t, stdcli.New().Run([]string{"cx", "login", "--insecure", host}))
+	})
+
+	assert.Len(t, logins, 3)
+}
+
+func TestLoginApiKey(t *testing.T) {
+	proxy := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, _, _ := r.BasicAuth(); key != "valid" || r.URL.Path != "/racks" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`[]`))
+	}))
+	defer proxy.Close()
+
+	// the console says where its proxy is, like it does for password logins
+	console := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, _, _ := r.BasicAuth(); key != "valid" || r.URL.Path != "/auth/proxy" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(cx.Login{Host: proxy.URL})
+	}))
+	defer console.Close()
+
+	host := strings.TrimPrefix(console.URL, "https://")
+
+	dir, cleanup := consoleHome(t, "other.example.org")
+	defer cleanup()
+
+	os.Remove(filepath.Join(dir, ".convox", "console", "proxy"))
+
+	// an invalid key is rejected without storing anything
+	err := stdcli.New().Run([]string{"cx", "login", "--insecure", "--api-key", "wrong", host})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "invalid api key for "+host), err.Error())
+	}
+
+	assert.False(t, exists(filepath.Join(dir, ".convox", "profiles.json")))
+
+	// a valid key is stored without asking for a password
+	stdin(t, "", func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "login", "--insecure", "--api-key", "valid", host}))
+	})
+
+	assert.Equal(t, cx.Profile{Host: host, Proxy: "https://valid:@" + strings.TrimPrefix(proxy.URL, "https://")}, storedProfiles(t, dir).Profiles["default"])
+}
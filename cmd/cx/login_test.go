@@ -0,0 +1,99 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsoleProxyURL(t *testing.T) {
+	tests := []struct {
+		host  string
+		proxy string
+		err   string
+	}{
+		{"console.convox.com", "https://key:@console.convox.com", ""},
+		{"console.convox.com:8443", "https://key:@console.convox.com:8443", ""},
+		{"console.convox.com/proxy", "https://key:@console.convox.com/proxy", ""},
+		{"console.convox.com:8443/proxy/", "https://key:@console.convox.com:8443/proxy", ""},
+		{"http://console.convox.com:8080/proxy", "https://key:@console.convox.com:8080/proxy", ""},
+		{"Console.Convox.com", "https://key:@console.convox.com", ""},
+		{"bücher.example.com", "https://key:@xn--bcher-kva.example.com", ""},
+		{"bücher.example.com:444/p", "https://key:@xn--bcher-kva.example.com:444/p", ""},
+		{"10.0.0.1:3000", "https://key:@10.0.0.1:3000", ""},
+		{"", "", "console did not return a proxy host"},
+		{"   ", "", "console did not return a proxy host"},
+		{"https://", "", `console returned an invalid proxy host: "https://"`},
+		{"console.convox.com:99999", "", `console returned an invalid proxy port: "99999"`},
+		{"console..convox.com", "", `console returned an invalid proxy host: "https://console..convox.com"`},
+		{"con_sole.convox.com", "", `console returned an invalid proxy host: "https://con_sole.convox.com"`},
+	}
+
+	for _, tt := range tests {
+		proxy, err := cx.ConsoleProxyURL(tt.host, "key")
+
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.host)
+			continue
+		}
+
+		if !assert.NoError(t, err, tt.host) {
+			continue
+		}
+
+		assert.Equal(t, tt.proxy, proxy, tt.host)
+
+		u, err := url.Parse(proxy)
+		if assert.NoError(t, err, tt.host) {
+			assert.Equal(t, proxy, u.String(), tt.host)
+			assert.Equal(t, "key", u.User.Username(), tt.host)
+		}
+	}
+}
+
+func TestVerifyConsoleProxy(t *testing.T) {
+	console := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _, _ := r.BasicAuth()
+
+		switch {
+		case r.URL.Path != "/proxy/racks":
+			w.WriteHeader(http.StatusNotFound)
+		case key != "valid":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			fmt.Fprint(w, "[]")
+		}
+	}))
+	defer console.Close()
+
+	host := strings.TrimPrefix(console.URL, "https://") + "/proxy"
+
+	tests := []struct {
+		key string
+		err string
+	}{
+		{"valid", ""},
+		{"invalid", "invalid api key for " + strings.TrimPrefix(console.URL, "https://")},
+	}
+
+	for _, tt := range tests {
+		proxy, err := cx.ConsoleProxyURL(host, tt.key)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		err = cx.VerifyConsoleProxy(console.Client(), proxy)
+
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.key)
+		} else {
+			assert.NoError(t, err, tt.key)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/convox/praxis/stdcli"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+func init() {
+	stdcli.RegisterCommand(cli.Command{
+		Name:        "logout",
+		Description: "log out of Convox",
+		Usage:       "[CONSOLE]",
+		Action:      runLogout,
+	})
+}
+
+func runLogout(c *cli.Context) error {
+	p, err := activeProfile()
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	// already logged out
+	if p == (Profile{}) {
+		return nil
+	}
+
+	console := p.Host
+
+	if console == "" {
+		console = defaultConsoleHost
+	}
+
+	if len(c.Args()) > 0 && c.Args()[0] != console {
+		return stdcli.Errorf("not logged in to %s, the current console is %s", c.Args()[0], console)
+	}
+
+	stdcli.Startf("Logging out of <name>%s</name>", console)
+
+	// the host is removed even when there is no proxy left so a half finished login is cleared too
+	if err := removeConsoleProxy(); err != nil {
+		return stdcli.Error(err)
+	}
+
+	if err := removeConsoleHost(); err != nil {
+		return stdcli.Error(err)
+	}
+
+	stdcli.OK()
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/convox/praxis/stdcli"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/stretchr/testify/assert"
+)
+
+// consoleHome points the home directory at a temp dir holding stored console credentials
+func consoleHome(t *testing.T, host string) (string, func()) {
+	dir, err := ioutil.TempDir("", "cx-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	home := os.Getenv("HOME")
+
+	os.Setenv("HOME", dir)
+	homedir.DisableCache = true
+
+	os.MkdirAll(filepath.Join(dir, ".convox", "console"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ".convox", "console", "host"), []byte(host), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ".convox", "console", "proxy"), []byte("rack."+host), 0644)
+
+	return dir, func() {
+		os.Setenv("HOME", home)
+		homedir.DisableCache = false
+		os.RemoveAll(dir)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestLogout(t *testing.T) {
+	dir, cleanup := consoleHome(t, "console.example.org")
+	defer cleanup()
+
+	// a different console is an error and leaves the credentials in place
+	err := stdcli.New().Run([]string{"cx", "logout", "other.example.org"})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "not logged in to other.example.org, the current console is console.example.org"), err.Error())
+	}
+
+	assert.Equal(t, cx.Profile{Host: "console.example.org", Proxy: "rack.console.example.org"}, storedProfiles(t, dir).Profiles["default"])
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "logout", "console.example.org"}))
+	assert.Empty(t, storedProfiles(t, dir).Profiles)
+
+	// logging out again is a no-op
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "logout"}))
+
+	// a login that stored the host but no proxy is cleared too
+	ioutil.WriteFile(filepath.Join(dir, ".convox", "profiles.json"), []byte(`{"current":"default","profiles":{"default":{"host":"console.example.org"}}}`), 0600)
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "logout"}))
+	assert.Empty(t, storedProfiles(t, dir).Profiles)
+}
@@ -39,6 +39,7 @@ var (
 var globalFlags = []cli.Flag{
 	appFlag,
 	rackFlag,
+	stdcli.NoHeadersFlag,
 }
 
 func init() {
@@ -75,7 +76,12 @@ func run() error {
 	app.Name = "cx"
 	app.Version = Version
 	app.Usage = "convox management tool"
-	app.Flags = globalFlags
+	app.Flags = append(globalFlags, profileFlag)
+
+	app.Before = func(c *cli.Context) error {
+		profileName = c.GlobalString("profile")
+		return nil
+	}
 
 	stdcli.VersionPrinter(func(c *cli.Context) {
 		runVersion(c)
@@ -238,52 +244,6 @@ func cliID() (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
-func consoleHost() (string, error) {
-	fn, err := homedir.Expand("~/.convox/console/host")
-	if err != nil {
-		return "", err
-	}
-
-	if _, err := os.Stat(fn); os.IsNotExist(err) {
-		return "ui.convox.com", nil
-	}
-
-	data, err := ioutil.ReadFile(fn)
-	if err != nil {
-		return "", err
-	}
-
-	return strings.TrimSpace(string(data)), nil
-}
-
-func consoleProxy() (*url.URL, error) {
-	fn, err := homedir.Expand("~/.convox/console/proxy")
-	if err != nil {
-		return nil, err
-	}
-
-	_, err = os.Stat(fn)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil
-		}
-		return nil, err
-	}
-
-	data, err := ioutil.ReadFile(fn)
-	if err != nil {
-		return nil, err
-	}
-
-	u, err := url.Parse(strings.TrimSpace(string(data)))
-	if err != nil {
-		return nil, err
-	}
-
-	u.Scheme = "https"
-	return u, nil
-}
-
 func currentRack(c *cli.Context) (string, error) {
 	// RACK_URL always wins so use it if set
 	if os.Getenv("RACK_URL") != "" {
@@ -334,44 +294,6 @@ func shellRack() (string, error) {
 	return strings.TrimSpace(string(data)), nil
 }
 
-func setConsoleHost(host string) error {
-	fn, err := homedir.Expand("~/.convox/console/host")
-	if err != nil {
-		return err
-	}
-
-	if _, err := os.Stat(fn); os.IsNotExist(err) {
-		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
-			return err
-		}
-	}
-
-	if err := ioutil.WriteFile(fn, []byte(host), 0644); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func setConsoleProxy(proxy string) error {
-	fn, err := homedir.Expand("~/.convox/console/proxy")
-	if err != nil {
-		return err
-	}
-
-	if _, err := os.Stat(fn); os.IsNotExist(err) {
-		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
-			return err
-		}
-	}
-
-	if err := ioutil.WriteFile(fn, []byte(proxy), 0644); err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func setShellRack(rack string) error {
 	shpid := os.Getppid()
 
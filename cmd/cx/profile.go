@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/convox/praxis/stdcli"
+	homedir "github.com/mitchellh/go-homedir"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+const (
+	defaultConsoleHost = "ui.convox.com"
+	defaultProfile     = "default"
+)
+
+var profileFlag = cli.StringFlag{
+	Name:   "profile",
+	EnvVar: "CONVOX_PROFILE",
+	Usage:  "console profile to use instead of the current one",
+}
+
+// profileName is the profile selected with --profile, empty uses the current profile
+var profileName string
+
+func init() {
+	stdcli.RegisterCommand(cli.Command{
+		Name:        "profile",
+		Description: "manage console profiles",
+		Action:      runProfileList,
+		Flags:       []cli.Flag{stdcli.NoHeadersFlag, stdcli.OutputFlag},
+		Subcommands: cli.Commands{
+			cli.Command{
+				Name:        "list",
+				Description: "list console profiles",
+				Action:      runProfileList,
+				Flags:       []cli.Flag{stdcli.NoHeadersFlag, stdcli.OutputFlag},
+			},
+			cli.Command{
+				Name:        "use",
+				Description: "switch the current console profile",
+				Usage:       "<name>",
+				Action:      runProfileUse,
+			},
+		},
+	})
+}
+
+// Profile is a console login
+type Profile struct {
+	Host  string `json:"host"`
+	Proxy string `json:"proxy"`
+}
+
+// Profiles is the set of console logins and the one in use
+type Profiles struct {
+	Current  string             `json:"current"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+func runProfileList(c *cli.Context) error {
+	ps, err := LoadProfiles()
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	active := ps.active()
+
+	names := []string{}
+
+	for name := range ps.Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	// api keys in the proxy urls are left out of the output
+	out := []map[string]interface{}{}
+
+	for _, name := range names {
+		out = append(out, map[string]interface{}{"name": name, "console": ps.Profiles[name].Host, "current": name == active})
+	}
+
+	err = stdcli.Output(c, out, func() {
+		if len(names) == 0 {
+			stdcli.Writef("No profiles found, try cx login\n")
+			return
+		}
+
+		t := stdcli.NewTable("", "NAME", "CONSOLE")
+
+		for _, name := range names {
+			current := ""
+
+			if name == active {
+				current = "*"
+			}
+
+			t.AddRow(current, name, ps.Profiles[name].Host)
+		}
+
+		t.Print()
+	})
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	return nil
+}
+
+func runProfileUse(c *cli.Context) error {
+	if len(c.Args()) < 1 {
+		return stdcli.Usage(c)
+	}
+
+	name := c.Args()[0]
+
+	ps, err := LoadProfiles()
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	if _, ok := ps.Profiles[name]; !ok {
+		return stdcli.Errorf("no such profile: %s", name)
+	}
+
+	ps.Current = name
+
+	if err := ps.Save(); err != nil {
+		return stdcli.Error(err)
+	}
+
+	stdcli.Writef("Switched to profile <name>%s</name>\n", name)
+
+	return nil
+}
+
+// active returns the name of the profile selected with --profile or the current one
+func (ps *Profiles) active() string {
+	if profileName != "" {
+		return profileName
+	}
+
+	if ps.Current != "" {
+		return ps.Current
+	}
+
+	return defaultProfile
+}
+
+func profilesFile() (string, error) {
+	return homedir.Expand("~/.convox/profiles.json")
+}
+
+// LoadProfiles reads the profiles, moving a login stored by an older version into the default profile
+func LoadProfiles() (*Profiles, error) {
+	fn, err := profilesFile()
+	if err != nil {
+		return nil, err
+	}
+
+	ps := &Profiles{Profiles: map[string]Profile{}}
+
+	data, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return migrateProfiles(ps)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, ps); err != nil {
+		return nil, fmt.Errorf("could not read %s: %s", fn, err)
+	}
+
+	if ps.Profiles == nil {
+		ps.Profiles = map[string]Profile{}
+	}
+
+	return ps, nil
+}
+
+func migrateProfiles(ps *Profiles) (*Profiles, error) {
+	p := Profile{}
+
+	legacy := []struct {
+		path  string
+		value *string
+	}{
+		{"~/.convox/console/host", &p.Host},
+		{"~/.convox/console/proxy", &p.Proxy},
+	}
+
+	files := []string{}
+
+	for _, l := range legacy {
+		fn, err := homedir.Expand(l.path)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := ioutil.ReadFile(fn)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		*l.value = strings.TrimSpace(string(data))
+		files = append(files, fn)
+	}
+
+	if len(files) == 0 {
+		return ps, nil
+	}
+
+	ps.Current = defaultProfile
+	ps.Profiles[defaultProfile] = p
+
+	if err := ps.Save(); err != nil {
+		return nil, err
+	}
+
+	for _, fn := range files {
+		os.Remove(fn)
+	}
+
+	return ps, nil
+}
+
+// Save writes the profiles readable by the current user only
+func (ps *Profiles) Save() error {
+	fn, err := profilesFile()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(ps, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// profiles hold api keys, the mode of an existing file is not changed by WriteFile
+	if err := ioutil.WriteFile(fn, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Chmod(fn, 0600)
+}
+
+// updateProfile changes the active profile with fn, making it current when there is none
+func updateProfile(fn func(p *Profile)) error {
+	ps, err := LoadProfiles()
+	if err != nil {
+		return err
+	}
+
+	name := ps.active()
+
+	p := ps.Profiles[name]
+
+	fn(&p)
+
+	if p == (Profile{}) {
+		delete(ps.Profiles, name)
+	} else {
+		ps.Profiles[name] = p
+	}
+
+	if ps.Current == "" {
+		ps.Current = name
+	}
+
+	return ps.Save()
+}
+
+// Lookup returns the profile selected with name, or the current one when name is empty. Selecting a
+// profile that does not exist is an error, not having logged in with the current one yet is not
+func (ps *Profiles) Lookup(name string) (Profile, error) {
+	if name == "" {
+		name = ps.Current
+
+		if name == "" {
+			name = defaultProfile
+		}
+
+		return ps.Profiles[name], nil
+	}
+
+	p, ok := ps.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("no such profile: %s", name)
+	}
+
+	return p, nil
+}
+
+func activeProfile() (Profile, error) {
+	ps, err := LoadProfiles()
+	if err != nil {
+		return Profile{}, err
+	}
+
+	return ps.Lookup(profileName)
+}
+
+func consoleHost() (string, error) {
+	p, err := activeProfile()
+	if err != nil {
+		return "", err
+	}
+
+	if p.Host == "" {
+		return defaultConsoleHost, nil
+	}
+
+	return p.Host, nil
+}
+
+func consoleProxy() (*url.URL, error) {
+	p, err := activeProfile()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Proxy == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(p.Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Scheme = "https"
+	return u, nil
+}
+
+// consoleProxyFor returns the proxy url for a console that is not necessarily the current one,
+// asking the console with client where its proxy is for key when given and otherwise using a
+// profile logged in to that console
+func consoleProxyFor(client *http.Client, console, key string) (*url.URL, error) {
+	if key != "" {
+		proxy, err := consoleProxyURLForKey(client, console, key)
+		if err != nil {
+			return nil, err
+		}
+
+		return url.Parse(proxy)
+	}
+
+	ps, err := LoadProfiles()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+
+	for name, p := range ps.Profiles {
+		if p.Host == console && p.Proxy != "" {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no credentials for console %s, use --api-key or cx login %s", console, console)
+	}
+
+	// prefer the active profile, then the first by name
+	sort.Strings(names)
+
+	name := names[0]
+
+	for _, n := range names {
+		if n == ps.active() {
+			name = n
+		}
+	}
+
+	u, err := url.Parse(ps.Profiles[name].Proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Scheme = "https"
+	return u, nil
+}
+
+func removeConsoleHost() error {
+	return updateProfile(func(p *Profile) { p.Host = "" })
+}
+
+func removeConsoleProxy() error {
+	return updateProfile(func(p *Profile) { p.Proxy = "" })
+}
+
+func setConsoleHost(host string) error {
+	return updateProfile(func(p *Profile) { p.Host = host })
+}
+
+func setConsoleProxy(proxy string) error {
+	return updateProfile(func(p *Profile) { p.Proxy = proxy })
+}
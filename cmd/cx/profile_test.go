@@ -0,0 +1,140 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/stretchr/testify/assert"
+)
+
+// testHome points the home directory at a temporary one until the returned function is called
+func testHome(t *testing.T) (string, func()) {
+	home, err := ioutil.TempDir("", "cx-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := homedir.DisableCache
+	old := os.Getenv("HOME")
+
+	homedir.DisableCache = true
+	os.Setenv("HOME", home)
+
+	return home, func() {
+		homedir.DisableCache = cache
+		os.Setenv("HOME", old)
+		os.RemoveAll(home)
+	}
+}
+
+func TestMigrateProfiles(t *testing.T) {
+	home, cleanup := testHome(t)
+	defer cleanup()
+
+	legacy := filepath.Join(home, ".convox", "console")
+
+	if !assert.NoError(t, os.MkdirAll(legacy, 0755)) {
+		return
+	}
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(legacy, "host"), []byte("console.example.com\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(legacy, "proxy"), []byte("https://key:@console.example.com\n"), 0644))
+
+	expected := &cx.Profiles{
+		Current: "default",
+		Profiles: map[string]cx.Profile{
+			"default": {Host: "console.example.com", Proxy: "https://key:@console.example.com"},
+		},
+	}
+
+	ps, err := cx.LoadProfiles()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, expected, ps)
+
+	for _, name := range []string{"host", "proxy"} {
+		_, err := os.Stat(filepath.Join(legacy, name))
+		assert.True(t, os.IsNotExist(err), "legacy %s file not removed", name)
+	}
+
+	fi, err := os.Stat(filepath.Join(home, ".convox", "profiles.json"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	ps, err = cx.LoadProfiles()
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, ps)
+	}
+}
+
+func TestMigrateProfilesNone(t *testing.T) {
+	home, cleanup := testHome(t)
+	defer cleanup()
+
+	ps, err := cx.LoadProfiles()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, &cx.Profiles{Profiles: map[string]cx.Profile{}}, ps)
+
+	_, err = os.Stat(filepath.Join(home, ".convox", "profiles.json"))
+	assert.True(t, os.IsNotExist(err), "profiles written without a login to migrate")
+}
+
+func TestProfilesSave(t *testing.T) {
+	home, cleanup := testHome(t)
+	defer cleanup()
+
+	fn := filepath.Join(home, ".convox", "profiles.json")
+
+	if !assert.NoError(t, os.MkdirAll(filepath.Dir(fn), 0755)) {
+		return
+	}
+
+	// an existing file readable by others is tightened
+	assert.NoError(t, ioutil.WriteFile(fn, []byte("{}"), 0644))
+
+	ps := &cx.Profiles{Current: "work", Profiles: map[string]cx.Profile{"work": {Host: "console.example.com", Proxy: "https://key:@console.example.com"}}}
+
+	if !assert.NoError(t, ps.Save()) {
+		return
+	}
+
+	fi, err := os.Stat(fn)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	}
+
+	loaded, err := cx.LoadProfiles()
+	if assert.NoError(t, err) {
+		assert.Equal(t, ps, loaded)
+	}
+}
+
+func TestProfilesLookup(t *testing.T) {
+	ps := &cx.Profiles{Current: "work", Profiles: map[string]cx.Profile{"work": {Host: "console.example.com"}}}
+
+	p, err := ps.Lookup("")
+	assert.NoError(t, err)
+	assert.Equal(t, cx.Profile{Host: "console.example.com"}, p)
+
+	p, err = ps.Lookup("work")
+	assert.NoError(t, err)
+	assert.Equal(t, cx.Profile{Host: "console.example.com"}, p)
+
+	_, err = ps.Lookup("missing")
+	assert.EqualError(t, err, "no such profile: missing")
+
+	// not having logged in yet is not an error
+	p, err = (&cx.Profiles{Profiles: map[string]cx.Profile{}}).Lookup("")
+	assert.NoError(t, err)
+	assert.Equal(t, cx.Profile{}, p)
+}
@@ -0,0 +1,117 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+// storedProfiles reads the profiles saved under the home directory dir
+func storedProfiles(t *testing.T, dir string) cx.Profiles {
+	var ps cx.Profiles
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, ".convox", "profiles.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(data, &ps); err != nil {
+		t.Fatal(err)
+	}
+
+	return ps
+}
+
+func TestProfileMigration(t *testing.T) {
+	dir, cleanup := consoleHome(t, "console.example.org")
+	defer cleanup()
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "profile", "list"}))
+
+	ps := storedProfiles(t, dir)
+
+	assert.Equal(t, "default", ps.Current)
+	assert.Equal(t, map[string]cx.Profile{"default": {Host: "console.example.org", Proxy: "rack.console.example.org"}}, ps.Profiles)
+
+	// the legacy files are gone once migrated
+	assert.False(t, exists(filepath.Join(dir, ".convox", "console", "host")))
+	assert.False(t, exists(filepath.Join(dir, ".convox", "console", "proxy")))
+
+	info, err := os.Stat(filepath.Join(dir, ".convox", "profiles.json"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestProfileUse(t *testing.T) {
+	dir, cleanup := consoleHome(t, "console.example.org")
+	defer cleanup()
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "logout"}))
+
+	ps := cx.Profiles{
+		Current: "production",
+		Profiles: map[string]cx.Profile{
+			"production": {Host: "console.example.org", Proxy: "https://key:@rack.example.org"},
+			"staging":    {Host: "staging.example.org"},
+		},
+	}
+
+	data, _ := json.Marshal(ps)
+	ioutil.WriteFile(filepath.Join(dir, ".convox", "profiles.json"), data, 0600)
+
+	// api keys are left out of the list
+	out := stdout(t, func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "profile", "list", "--output", "json"}))
+	})
+
+	var list []map[string]interface{}
+
+	if assert.NoError(t, json.Unmarshal([]byte(out), &list)) {
+		assert.Equal(t, []map[string]interface{}{
+			{"name": "production", "console": "console.example.org", "current": true},
+			{"name": "staging", "console": "staging.example.org", "current": false},
+		}, list)
+	}
+
+	err := stdcli.New().Run([]string{"cx", "profile", "use", "nope"})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "no such profile: nope"), err.Error())
+	}
+
+	assert.Equal(t, "production", storedProfiles(t, dir).Current)
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "profile", "use", "staging"}))
+	assert.Equal(t, "staging", storedProfiles(t, dir).Current)
+
+	var w cx.Whoami
+
+	out = stdout(t, func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "whoami", "--rack", "production", "--json"}))
+	})
+
+	if assert.NoError(t, json.Unmarshal([]byte(out), &w)) {
+		assert.Equal(t, cx.Whoami{Console: "staging.example.org", Proxy: false, Rack: "production"}, w)
+	}
+
+	// --profile overrides the current profile for a single command
+	cx.SetProfile("production")
+	defer cx.SetProfile("")
+
+	out = stdout(t, func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "whoami", "--rack", "production", "--json"}))
+	})
+
+	if assert.NoError(t, json.Unmarshal([]byte(out), &w)) {
+		assert.Equal(t, cx.Whoami{Console: "console.example.org", Proxy: true, Rack: "production"}, w)
+	}
+
+	assert.Equal(t, "staging", storedProfiles(t, dir).Current)
+}
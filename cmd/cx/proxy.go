@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/convox/praxis/router"
+	"github.com/convox/praxis/sdk/rack"
+	"github.com/convox/praxis/stdcli"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+var routerFlag = cli.StringFlag{
+	Name:  "router",
+	Usage: "local router",
+	Value: "10.42.0.0",
+}
+
+func init() {
+	stdcli.RegisterCommand(cli.Command{
+		Name:        "proxy",
+		Description: "inspect the local router",
+		Subcommands: cli.Commands{
+			cli.Command{
+				Name:        "status",
+				Description: "list the proxies registered on the local router",
+				Action:      runProxyStatus,
+				Flags:       []cli.Flag{stdcli.NoHeadersFlag, stdcli.OutputFlag, routerFlag},
+			},
+			cli.Command{
+				Name:        "connections",
+				Description: "list the active connections of a proxy",
+				Usage:       "<host> <port>",
+				Action:      runProxyConnections,
+				Flags:       []cli.Flag{stdcli.NoHeadersFlag, stdcli.OutputFlag, routerFlag},
+			},
+			cli.Command{
+				Name:        "close",
+				Description: "close an active connection of a proxy",
+				Usage:       "<host> <port> <id>",
+				Action:      runProxyClose,
+				Flags:       []cli.Flag{routerFlag},
+			},
+		},
+	})
+}
+
+func runProxyStatus(c *cli.Context) error {
+	proxies, err := routerProxies(c.String("router"))
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	err = stdcli.Output(c, proxies, func() {
+		t := stdcli.NewTable("HOST", "LISTEN", "SCHEME", "TARGET", "CONNECTIONS")
+
+		for _, p := range proxies {
+			t.AddRow(p.Host, p.Listen, p.Scheme, p.Target, strconv.FormatInt(p.Metrics.ActiveConnections, 10))
+		}
+
+		t.Print()
+	})
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	return nil
+}
+
+func runProxyConnections(c *cli.Context) error {
+	if len(c.Args()) < 2 {
+		return stdcli.Usage(c)
+	}
+
+	conns := []router.Connection{}
+
+	path := fmt.Sprintf("/endpoints/%s/proxies/%s/connections", url.PathEscape(c.Args()[0]), url.PathEscape(c.Args()[1]))
+
+	if err := routerClient(c.String("router")).Get(path, rack.RequestOptions{}, &conns); err != nil {
+		return stdcli.Error(err)
+	}
+
+	err := stdcli.Output(c, conns, func() {
+		t := stdcli.NewTable("ID", "REMOTE", "TARGET", "STARTED", "IN", "OUT")
+
+		for _, cn := range conns {
+			t.AddRow(cn.Id, cn.Remote, cn.Target, cn.Started.Format(time.RFC3339), strconv.FormatInt(cn.BytesIn, 10), strconv.FormatInt(cn.BytesOut, 10))
+		}
+
+		t.Print()
+	})
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	return nil
+}
+
+func runProxyClose(c *cli.Context) error {
+	if len(c.Args()) < 3 {
+		return stdcli.Usage(c)
+	}
+
+	id := c.Args()[2]
+
+	stdcli.Startf("Closing connection <id>%s</id>", id)
+
+	path := fmt.Sprintf("/endpoints/%s/proxies/%s/connections/%s", url.PathEscape(c.Args()[0]), url.PathEscape(c.Args()[1]), url.PathEscape(id))
+
+	if err := routerClient(c.String("router")).Delete(path, rack.RequestOptions{}, nil); err != nil {
+		return stdcli.Error(err)
+	}
+
+	stdcli.OK()
+
+	return nil
+}
+
+func routerClient(host string) *rack.Client {
+	return &rack.Client{
+		Debug:    os.Getenv("CONVOX_DEBUG") == "true",
+		Endpoint: &url.URL{Scheme: "https", Host: host},
+		Version:  "dev",
+	}
+}
+
+// routerProxies fetches the proxies and their metrics from the router at host
+func routerProxies(host string) ([]router.ProxyMetrics, error) {
+	proxies := []router.ProxyMetrics{}
+
+	if err := routerClient(host).Get("/proxies", rack.RequestOptions{}, &proxies); err != nil {
+		return nil, fmt.Errorf("could not reach router at %s: %s", host, err)
+	}
+
+	return proxies, nil
+}
@@ -0,0 +1,48 @@
+package main_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/router"
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyStatus(t *testing.T) {
+	proxies := []router.ProxyMetrics{
+		{Host: "web.app.convox", Listen: "https://10.42.0.1:443", Scheme: "https", Target: "http://rack/app/service/web:3000", Metrics: router.Metrics{ActiveConnections: 2}},
+	}
+
+	rt := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/proxies" {
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(proxies)
+	}))
+	defer rt.Close()
+
+	host := strings.TrimPrefix(rt.URL, "https://")
+
+	out := stdout(t, func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "proxy", "status", "--router", host, "--output", "json"}))
+	})
+
+	var listed []router.ProxyMetrics
+
+	if assert.NoError(t, json.Unmarshal([]byte(out), &listed)) {
+		assert.Equal(t, proxies, listed)
+	}
+
+	rt.Close()
+
+	err := stdcli.New().Run([]string{"cx", "proxy", "status", "--router", host})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "could not reach router at "+host), err.Error())
+	}
+}
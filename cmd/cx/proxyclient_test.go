@@ -0,0 +1,110 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/stretchr/testify/assert"
+)
+
+// consoleProxyClient returns a console proxy client for a fake console serving fn
+func consoleProxyClient(t *testing.T, fn http.HandlerFunc) (*cx.ProxyClient, func()) {
+	console := httptest.NewTLSServer(fn)
+
+	dir, cleanup := consoleHome(t, "console.example.org")
+
+	ioutil.WriteFile(filepath.Join(dir, ".convox", "console", "proxy"), []byte(console.URL), 0644)
+
+	p := cx.ConsoleProxy()
+	p.Backoff = time.Millisecond
+
+	return p, func() {
+		console.Close()
+		cleanup()
+	}
+}
+
+func TestProxyClientRetry(t *testing.T) {
+	var hits int32
+
+	p, cleanup := consoleProxyClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte(`["production"]`))
+	})
+	defer cleanup()
+
+	racks, err := p.Racks()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"production"}, racks)
+	}
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&hits))
+}
+
+func TestProxyClientRetryLimit(t *testing.T) {
+	var hits int32
+
+	p, cleanup := consoleProxyClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer cleanup()
+
+	_, err := p.Racks()
+	assert.EqualError(t, err, "console responded with status 502: Bad Gateway")
+	assert.Equal(t, int32(p.Retries+1), atomic.LoadInt32(&hits))
+}
+
+func TestProxyClientClientError(t *testing.T) {
+	var hits int32
+
+	p, cleanup := consoleProxyClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		http.Error(w, "no such path", http.StatusNotFound)
+	})
+	defer cleanup()
+
+	// client errors are reported with their body and not retried
+	_, err := p.Racks()
+	assert.EqualError(t, err, "console responded with status 404: no such path")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&hits))
+}
+
+func TestProxyClientServices(t *testing.T) {
+	var paths []string
+
+	p, cleanup := consoleProxyClient(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+
+		switch r.URL.Path {
+		case "/racks/production/services":
+			w.Write([]byte(`["web","worker"]`))
+		default:
+			w.Write([]byte(`null`))
+		}
+	})
+	defer cleanup()
+
+	services, err := p.Services("production")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"web", "worker"}, services)
+	}
+
+	// rack names are escaped and an empty list is never nil
+	services, err = p.Services("org/empty")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{}, services)
+	}
+
+	assert.Equal(t, []string{"/racks/production/services", "/racks/org%2Fempty/services"}, paths)
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/convox/praxis/manifest"
 	"github.com/convox/praxis/sdk/rack"
 	"github.com/convox/praxis/stdcli"
 	cli "gopkg.in/urfave/cli.v1"
+	yaml "gopkg.in/yaml.v2"
 )
 
 func init() {
@@ -14,29 +25,344 @@ func init() {
 		Name:        "racks",
 		Description: "list of racks available",
 		Action:      runRacks,
+		Flags: []cli.Flag{
+			stdcli.NoHeadersFlag,
+			stdcli.OutputFlag,
+			cli.StringFlag{
+				Name:  "console",
+				Usage: "list the racks of this console instead of the current one",
+			},
+			cli.StringFlag{
+				Name:   "api-key",
+				EnvVar: "CONVOX_API_KEY",
+				Usage:  "api key for --console, the stored login for it is used if not set",
+			},
+			cli.BoolFlag{
+				Name:  "insecure",
+				Usage: "skip verification of the --console certificate when looking up its proxy",
+			},
+			cli.DurationFlag{
+				Name:  "timeout",
+				Value: 10 * time.Second,
+				Usage: "timeout for looking up the proxy of --console",
+			},
+			cli.BoolFlag{
+				Name:  "watch",
+				Usage: "poll for rack changes until interrupted",
+			},
+			cli.DurationFlag{
+				Name:  "interval",
+				Value: 5 * time.Second,
+				Usage: "how often --watch polls",
+			},
+		},
+		Subcommands: cli.Commands{
+			cli.Command{
+				Name:        "services",
+				Description: "list services running on a rack",
+				Usage:       "<rack>",
+				Action:      runRacksServices,
+				Flags:       []cli.Flag{stdcli.NoHeadersFlag, stdcli.OutputFlag},
+			},
+			cli.Command{
+				Name:        "manifest",
+				Description: "generate a manifest skeleton from the services running on a rack",
+				Usage:       "<rack>",
+				Action:      runRacksManifest,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "output, o",
+						Usage: "write the manifest to this file instead of stdout",
+					},
+				},
+			},
+		},
 	})
 }
 
 func runRacks(c *cli.Context) error {
-	racks, err := ConsoleProxy().Racks()
+	var proxy *ProxyClient
+
+	if console := c.String("console"); console != "" {
+		u, err := consoleProxyFor(loginClient(c), console, c.String("api-key"))
+		if err != nil {
+			return stdcli.Error(err)
+		}
+
+		proxy = newProxyClient(u)
+	} else {
+		proxy = ConsoleProxy()
+	}
+
+	list := func() ([]string, error) {
+		racks, err := proxy.Racks()
+		if err != nil {
+			return nil, err
+		}
+
+		// the local rack belongs to this machine, not to another console
+		if c.String("console") == "" {
+			racks = append(racks, "local")
+		}
+
+		return racks, nil
+	}
+
+	if c.Bool("watch") {
+		if err := watchRacks(c, list); err != nil {
+			return stdcli.Error(err)
+		}
+
+		return nil
+	}
+
+	racks, err := list()
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	err = stdcli.Output(c, racks, func() {
+		t := stdcli.NewTable("RACKS")
+
+		for _, r := range racks {
+			t.AddRow(r)
+		}
+
+		t.Print()
+	})
 	if err != nil {
 		return stdcli.Error(err)
 	}
 
-	racks = append(racks, "local")
+	return nil
+}
+
+// RacksChange is written for every change seen by cx racks --watch, the first one lists the initial racks
+type RacksChange struct {
+	Racks   []string `json:"racks"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+}
+
+// RacksWatch tracks the racks seen by cx racks --watch between polls
+type RacksWatch struct {
+	last []string
+	seen bool
+}
+
+// Update returns the change to report for the racks listed by a successful poll, the first one
+// reports the initial racks and later ones only report when a rack was added or removed
+func (w *RacksWatch) Update(racks []string) (RacksChange, bool) {
+	if !w.seen {
+		w.last = racks
+		w.seen = true
+
+		return RacksChange{Racks: racks, Added: []string{}, Removed: []string{}}, true
+	}
+
+	added, removed := DiffRacks(w.last, racks)
+
+	w.last = racks
+
+	if len(added) == 0 && len(removed) == 0 {
+		return RacksChange{}, false
+	}
+
+	return RacksChange{Racks: racks, Added: added, Removed: removed}, true
+}
+
+// watchRacks polls list and redraws the racks whenever they change until interrupted
+func watchRacks(c *cli.Context, list func() ([]string, error)) error {
+	interval := c.Duration("interval")
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	w := &RacksWatch{}
+
+	for {
+		racks, err := list()
+
+		if err != nil {
+			// keep watching through transient console errors
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		} else if rc, ok := w.Update(racks); ok {
+			if err := printRacksChange(c, rc); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-sig:
+			return nil
+		case <-tick.C:
+		}
+	}
+}
+
+// printRacksChange redraws the rack table, marking the racks that came and went since the last poll
+func printRacksChange(c *cli.Context, rc RacksChange) error {
+	return stdcli.Output(c, rc, func() {
+		if stdcli.IsTerminal(os.Stdout) {
+			stdcli.Write([]byte("\033[H\033[2J"))
+		}
+
+		t := stdcli.NewTable("RACKS", "CHANGE")
+
+		added := map[string]bool{}
+
+		for _, r := range rc.Added {
+			added[r] = true
+		}
+
+		for _, r := range rc.Racks {
+			if added[r] {
+				t.AddRow(r, stdcli.Sprintf("<ok>added</ok>"))
+			} else {
+				t.AddRow(r, "")
+			}
+		}
+
+		for _, r := range rc.Removed {
+			t.AddRow(r, stdcli.Sprintf("<fail>removed</fail>"))
+		}
+
+		t.Print()
+	})
+}
+
+// DiffRacks returns the racks in current but not in previous and those in previous but not in current
+func DiffRacks(previous, current []string) ([]string, []string) {
+	prev := map[string]bool{}
+	cur := map[string]bool{}
+
+	for _, r := range previous {
+		prev[r] = true
+	}
+
+	for _, r := range current {
+		cur[r] = true
+	}
+
+	added := []string{}
+	removed := []string{}
 
-	t := stdcli.NewTable("RACKS")
+	for _, r := range current {
+		if !prev[r] {
+			added = append(added, r)
+		}
+	}
 
-	for _, r := range racks {
-		t.AddRow(r)
+	for _, r := range previous {
+		if !cur[r] {
+			removed = append(removed, r)
+		}
 	}
 
-	t.Print()
+	return added, removed
+}
+
+const (
+	defaultProxyRetries = 3
+	defaultProxyBackoff = 500 * time.Millisecond
+)
+
+func runRacksServices(c *cli.Context) error {
+	if len(c.Args()) < 1 {
+		return stdcli.Usage(c)
+	}
+
+	name := c.Args()[0]
+
+	services, err := ConsoleProxy().Services(name)
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	err = stdcli.Output(c, services, func() {
+		if len(services) == 0 {
+			stdcli.Writef("No services found on <name>%s</name>\n", name)
+			return
+		}
+
+		t := stdcli.NewTable("SERVICES")
+
+		for _, s := range services {
+			t.AddRow(s)
+		}
+
+		t.Print()
+	})
+	if err != nil {
+		return stdcli.Error(err)
+	}
 
 	return nil
 }
 
+func runRacksManifest(c *cli.Context) error {
+	if len(c.Args()) < 1 {
+		return stdcli.Usage(c)
+	}
+
+	name := c.Args()[0]
+
+	services, err := ConsoleProxy().Services(name)
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	data, err := ManifestSkeleton(name, services)
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	if file := c.String("output"); file != "" {
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			return stdcli.Error(err)
+		}
+
+		stdcli.Writef("<ok>%s</ok> written\n", file)
+		return nil
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// ManifestSkeleton builds a manifest with an entry for each service on the rack. The console
+// only reports service names, so the build, image and port of each service are left to fill in
+func ManifestSkeleton(name string, services []string) ([]byte, error) {
+	ss := manifest.Services{}
+
+	for _, service := range services {
+		ss = append(ss, manifest.Service{Name: service})
+	}
+
+	data, err := yaml.Marshal(manifest.Manifest{Services: ss})
+	if err != nil {
+		return nil, err
+	}
+
+	header := fmt.Sprintf("# generated from the services running on %s\n# add the build or image, port and environment of each service\n", name)
+
+	return append([]byte(header), data...), nil
+}
+
 type ProxyClient struct {
+	// Retries is how many times a failed GET is retried
+	Retries int
+
+	// Backoff is the delay before the first retry, doubled after each attempt
+	Backoff time.Duration
+
 	c *rack.Client
 }
 
@@ -52,12 +378,81 @@ func ConsoleProxy() *ProxyClient {
 		os.Exit(1)
 	}
 
+	return newProxyClient(proxy)
+}
+
+func newProxyClient(endpoint *url.URL) *ProxyClient {
 	return &ProxyClient{
-		c: &rack.Client{Debug: os.Getenv("CONVOX_DEBUG") == "true", Endpoint: proxy, Version: "dev"},
+		Retries: defaultProxyRetries,
+		Backoff: defaultProxyBackoff,
+		c:       &rack.Client{Debug: os.Getenv("CONVOX_DEBUG") == "true", Endpoint: endpoint, Version: "dev"},
 	}
 }
 
 func (p *ProxyClient) Racks() (racks []string, err error) {
-	err = p.c.Get("/racks", rack.RequestOptions{}, &racks)
+	err = p.get("/racks", rack.RequestOptions{}, &racks)
 	return
 }
+
+func (p *ProxyClient) Services(name string) ([]string, error) {
+	services := []string{}
+
+	if err := p.get(fmt.Sprintf("/racks/%s/services", url.PathEscape(name)), rack.RequestOptions{}, &services); err != nil {
+		return nil, err
+	}
+
+	if services == nil {
+		services = []string{}
+	}
+
+	return services, nil
+}
+
+// get retries connection errors and server errors with exponential backoff
+func (p *ProxyClient) get(path string, opts rack.RequestOptions, out interface{}) error {
+	backoff := p.Backoff
+
+	for i := 0; ; i++ {
+		retry, err := p.getOnce(path, opts, out)
+		if err == nil || !retry || i >= p.Retries {
+			return err
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func (p *ProxyClient) getOnce(path string, opts rack.RequestOptions, out interface{}) (bool, error) {
+	req, err := p.c.Request("GET", path, opts)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := p.c.Client().Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+
+		msg := strings.TrimSpace(string(data))
+
+		if msg == "" {
+			msg = http.StatusText(res.StatusCode)
+		}
+
+		retry := res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+
+		return retry, fmt.Errorf("console responded with status %d: %s", res.StatusCode, msg)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+		return false, fmt.Errorf("could not decode console response: %s", err)
+	}
+
+	return false, nil
+}
@@ -0,0 +1,72 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRacksConsole(t *testing.T) {
+	keys := []string{}
+
+	var host string
+
+	// the console serves its rack proxy under /proxy
+	other := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _, _ := r.BasicAuth()
+
+		switch r.URL.Path {
+		case "/auth/proxy":
+			json.NewEncoder(w).Encode(cx.Login{Host: host + "/proxy"})
+		case "/proxy/racks":
+			keys = append(keys, key)
+			json.NewEncoder(w).Encode([]string{"acme-production"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer other.Close()
+
+	host = strings.TrimPrefix(other.URL, "https://")
+
+	dir, cleanup := consoleHome(t, "console.example.org")
+	defer cleanup()
+
+	// another console without a key or a login for it is an error
+	err := stdcli.New().Run([]string{"cx", "racks", "--console", host})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "no credentials for console "+host), err.Error())
+	}
+
+	// its racks are listed without the local rack
+	out := stdout(t, func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "racks", "--console", host, "--api-key", "other-key", "--insecure", "--output", "json"}))
+	})
+
+	var racks []string
+
+	if assert.NoError(t, json.Unmarshal([]byte(out), &racks)) {
+		assert.Equal(t, []string{"acme-production"}, racks)
+	}
+
+	// a profile logged in to the console is used when no key is given
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "profile", "list"}))
+
+	ps := storedProfiles(t, dir)
+	ps.Profiles["acme"] = cx.Profile{Host: host, Proxy: "https://stored-key:@" + host + "/proxy"}
+
+	data, _ := json.Marshal(ps)
+	ioutil.WriteFile(filepath.Join(dir, ".convox", "profiles.json"), data, 0600)
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "racks", "--console", host}))
+
+	assert.Equal(t, []string{"other-key", "stored-key"}, keys)
+}
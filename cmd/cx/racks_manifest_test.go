@@ -0,0 +1,54 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/manifest"
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRacksManifest(t *testing.T) {
+	_, cleanup := consoleProxyClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/racks/production/services" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Write([]byte(`["web","worker"]`))
+	})
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "cx-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "convox.yml")
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "racks", "manifest", "production", "--output", file}))
+
+	data, err := ioutil.ReadFile(file)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, strings.HasPrefix(string(data), "# generated from the services running on production\n"))
+
+	// the skeleton is a manifest with an entry for every service
+	m, err := manifest.Load(data, manifest.Environment{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if assert.Len(t, m.Services, 2) {
+		assert.Equal(t, "web", m.Services[0].Name)
+		assert.Equal(t, "worker", m.Services[1].Name)
+	}
+}
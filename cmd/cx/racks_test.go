@@ -0,0 +1,105 @@
+package main_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/convox/praxis/manifest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestSkeleton(t *testing.T) {
+	data, err := cx.ManifestSkeleton("production", []string{"web", "worker"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expected := `# generated from the services running on production
+# add the build or image, port and environment of each service
+services:
+  web: {}
+  worker: {}
+`
+
+	assert.Equal(t, expected, string(data))
+
+	m, err := manifest.Load(data, manifest.Environment{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if assert.Len(t, m.Services, 2) {
+		assert.Equal(t, "web", m.Services[0].Name)
+		assert.Equal(t, "worker", m.Services[1].Name)
+	}
+}
+
+func TestDiffRacks(t *testing.T) {
+	tests := []struct {
+		previous []string
+		current  []string
+		added    []string
+		removed  []string
+	}{
+		{[]string{}, []string{}, []string{}, []string{}},
+		{[]string{"a"}, []string{"a"}, []string{}, []string{}},
+		{[]string{"a"}, []string{"a", "b"}, []string{"b"}, []string{}},
+		{[]string{"a", "b"}, []string{"b"}, []string{}, []string{"a"}},
+		{[]string{"a", "b"}, []string{"b", "c", "d"}, []string{"c", "d"}, []string{"a"}},
+		{nil, []string{"a"}, []string{"a"}, []string{}},
+	}
+
+	for _, tt := range tests {
+		added, removed := cx.DiffRacks(tt.previous, tt.current)
+
+		assert.Equal(t, tt.added, added, "%v -> %v", tt.previous, tt.current)
+		assert.Equal(t, tt.removed, removed, "%v -> %v", tt.previous, tt.current)
+	}
+}
+
+func TestRacksWatch(t *testing.T) {
+	polls := []struct {
+		racks  []string
+		change string
+	}{
+		{[]string{"a", "b"}, `{"racks":["a","b"],"added":[],"removed":[]}`},
+		{[]string{"b", "a"}, ""},
+		{[]string{"a", "c"}, `{"racks":["a","c"],"added":["c"],"removed":["b"]}`},
+		{[]string{}, `{"racks":[],"added":[],"removed":["a","c"]}`},
+		{[]string{}, ""},
+	}
+
+	w := &cx.RacksWatch{}
+
+	for i, p := range polls {
+		rc, ok := w.Update(p.racks)
+
+		if p.change == "" {
+			assert.False(t, ok, "poll %d", i)
+			continue
+		}
+
+		if !assert.True(t, ok, "poll %d", i) {
+			continue
+		}
+
+		data, err := json.Marshal(rc)
+		if assert.NoError(t, err) {
+			assert.Equal(t, p.change, string(data), "poll %d", i)
+		}
+	}
+}
+
+func TestRacksWatchEmptyFirstPoll(t *testing.T) {
+	w := &cx.RacksWatch{}
+
+	// an empty rack list is still the initial state
+	rc, ok := w.Update([]string{})
+	assert.True(t, ok)
+	assert.Equal(t, cx.RacksChange{Racks: []string{}, Added: []string{}, Removed: []string{}}, rc)
+
+	rc, ok = w.Update([]string{"a"})
+	assert.True(t, ok)
+	assert.Equal(t, cx.RacksChange{Racks: []string{"a"}, Added: []string{"a"}, Removed: []string{}}, rc)
+}
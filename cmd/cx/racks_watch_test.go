@@ -0,0 +1,80 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRacksWatchCommand(t *testing.T) {
+	polls := [][]string{
+		{"production"},
+		{"production"},
+		{"production", "staging"},
+		{"staging"},
+	}
+
+	var lock sync.Mutex
+	var n int
+
+	console := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if n >= len(polls) {
+			// stop watching once every poll has been served
+			syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+			json.NewEncoder(w).Encode(polls[len(polls)-1])
+			return
+		}
+
+		json.NewEncoder(w).Encode(polls[n])
+		n++
+	}))
+	defer console.Close()
+
+	dir, cleanup := consoleHome(t, "console.example.org")
+	defer cleanup()
+
+	ioutil.WriteFile(filepath.Join(dir, ".convox", "console", "proxy"), []byte(console.URL), 0644)
+
+	out := stdout(t, func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "racks", "--watch", "--interval", "10ms", "--output", "json"}))
+	})
+
+	type change struct {
+		Racks   []string `json:"racks"`
+		Added   []string `json:"added"`
+		Removed []string `json:"removed"`
+	}
+
+	changes := []change{}
+
+	d := json.NewDecoder(strings.NewReader(out))
+
+	for d.More() {
+		var c change
+
+		if !assert.NoError(t, d.Decode(&c)) {
+			return
+		}
+
+		changes = append(changes, c)
+	}
+
+	// unchanged polls are not written
+	assert.Equal(t, []change{
+		{Racks: []string{"production", "local"}, Added: []string{}, Removed: []string{}},
+		{Racks: []string{"production", "staging", "local"}, Added: []string{"staging"}, Removed: []string{}},
+		{Racks: []string{"staging", "local"}, Added: []string{}, Removed: []string{"production"}},
+	}, changes)
+}
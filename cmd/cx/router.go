@@ -30,6 +30,10 @@ func init() {
 				Usage: "subnet",
 				Value: "10.42.0.0/16",
 			},
+			cli.StringFlag{
+				Name:  "error-pages",
+				Usage: "directory of the error page templates proxies can name",
+			},
 		},
 	})
 }
@@ -49,6 +53,8 @@ func runRouter(c *cli.Context) error {
 		return err
 	}
 
+	r.ErrorPagesDir = c.String("error-pages")
+
 	if err := r.Serve(); err != nil {
 		return err
 	}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"strings"
+
+	"github.com/convox/praxis/helpers"
 	"github.com/convox/praxis/stdcli"
 	cli "gopkg.in/urfave/cli.v1"
 )
@@ -27,26 +30,25 @@ func runSwitch(c *cli.Context) error {
 
 	sr := c.Args()[0]
 
-	if sr == "local" {
-		return setShellRack("local")
-	}
+	// local is always available, even when not logged in to a console
+	if sr != "local" {
+		racks, err := ConsoleProxy().Racks()
+		if err != nil {
+			return stdcli.Error(err)
+		}
 
-	racks, err := ConsoleProxy().Racks()
-	if err != nil {
-		return stdcli.Error(err)
-	}
+		racks = append(racks, "local")
 
-	var found bool
-	for _, r := range racks {
-		if r == sr {
-			found = true
-			break
+		if !helpers.In(sr, racks) {
+			return stdcli.Errorf("Rack %q was not found, available racks: %s", sr, strings.Join(racks, ", "))
 		}
 	}
 
-	if !found {
-		return stdcli.Errorf("Rack %q was not found", sr)
+	if err := setShellRack(sr); err != nil {
+		return stdcli.Error(err)
 	}
 
-	return setShellRack(sr)
+	stdcli.Writef("Switched to <name>%s</name>\n", sr)
+
+	return nil
 }
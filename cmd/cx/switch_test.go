@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwitch(t *testing.T) {
+	console := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/racks" {
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode([]string{"production", "staging"})
+	}))
+	defer console.Close()
+
+	dir, cleanup := consoleHome(t, "console.example.org")
+	defer cleanup()
+
+	ioutil.WriteFile(filepath.Join(dir, ".convox", "console", "proxy"), []byte(console.URL), 0644)
+
+	shell := filepath.Join(dir, ".convox", "shell", fmt.Sprintf("%d", os.Getppid()), "rack")
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "switch", "staging"}))
+
+	data, err := ioutil.ReadFile(shell)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "staging", string(data))
+	}
+
+	// unknown racks are rejected and the selection is unchanged
+	err = stdcli.New().Run([]string{"cx", "switch", "nope"})
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), `Rack "nope" was not found, available racks: production, staging, local`), err.Error())
+	}
+
+	data, err = ioutil.ReadFile(shell)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "staging", string(data))
+	}
+
+	// local does not need the console
+	console.Close()
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "switch", "local"}))
+
+	data, err = ioutil.ReadFile(shell)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "local", string(data))
+	}
+}
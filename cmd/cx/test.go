@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -14,13 +15,64 @@ import (
 )
 
 func init() {
+	flags := []cli.Flag{
+		cli.StringFlag{
+			Name:  "service, s",
+			Usage: "only run the tests of this service",
+		},
+	}
 	stdcli.RegisterCommand(cli.Command{
 		Name:        "test",
 		Description: "run tests",
 		Action:      errorExit(runTest, SysExitCode),
+		Flags:       append(flags, globalFlags...),
 	})
 }
 
+// testResult is the outcome of the tests of one service
+type testResult struct {
+	Service string
+	Code    int
+	Err     error
+}
+
+func (r testResult) Status() string {
+	switch {
+	case r.Err != nil:
+		return fmt.Sprintf("<fail>error</fail>: %s", r.Err)
+	case r.Code > 0:
+		return fmt.Sprintf("<fail>fail</fail> (exit %d)", r.Code)
+	default:
+		return "<ok>pass</ok>"
+	}
+}
+
+// testServices returns the services with tests in manifest order, or only the named one
+func testServices(m *manifest.Manifest, service string) (manifest.Services, error) {
+	if service != "" {
+		s, ok := m.Services.Find(service)
+		if !ok {
+			return nil, fmt.Errorf("no such service: %s", service)
+		}
+
+		if s.Test == "" {
+			return nil, fmt.Errorf("service %s has no tests", service)
+		}
+
+		return manifest.Services{*s}, nil
+	}
+
+	ss := manifest.Services{}
+
+	for _, s := range m.Services {
+		if s.Test != "" {
+			ss = append(ss, s)
+		}
+	}
+
+	return ss, nil
+}
+
 func runTest(c *cli.Context) error {
 	env := manifest.Environment{
 		"TEST": "true",
@@ -44,6 +96,11 @@ func runTest(c *cli.Context) error {
 		return err
 	}
 
+	services, err := testServices(m, c.String("service"))
+	if err != nil {
+		return err
+	}
+
 	system := m.Writer("convox", os.Stdout)
 
 	stdcli.DefaultWriter.Stdout = system
@@ -111,36 +168,57 @@ func runTest(c *cli.Context) error {
 		return fmt.Errorf("promote failed")
 	}
 
-	for _, s := range m.Services {
-		if s.Test == "" {
-			continue
-		}
+	results := []testResult{}
 
+	// services are run in manifest order, dependencies between them are not modeled
+	for _, s := range services {
 		w := m.Writer(s.Name, os.Stdout)
 
 		if err := w.Writef("running: %s\n", s.Test); err != nil {
 			return err
 		}
 
-		senv, err := m.ServiceEnvironment(s.Name)
-		if err != nil {
-			return err
-		}
+		results = append(results, runServiceTest(c, m, app.Name, build.Release, s, w))
+	}
 
-		code, err := Rack(c).ProcessRun(app.Name, types.ProcessRunOptions{
-			Command:     s.Test,
-			Environment: senv,
-			Release:     build.Release,
-			Service:     s.Name,
-			Output:      w,
-		})
-		if err != nil {
-			return err
+	t := stdcli.NewTable("SERVICE", "RESULT")
+
+	code := 0
+
+	for _, r := range results {
+		t.AddRow(r.Service, r.Status())
+
+		if code == 0 && r.Err != nil {
+			code = 1
 		}
-		if code > 0 {
-			return cli.NewExitError(fmt.Sprintf("exit %d", code), code)
+
+		if code == 0 && r.Code > 0 {
+			code = r.Code
 		}
 	}
 
+	t.Print()
+
+	if code > 0 {
+		return cli.NewExitError("tests failed", code)
+	}
+
 	return nil
 }
+
+func runServiceTest(c *cli.Context, m *manifest.Manifest, app, release string, s manifest.Service, w io.Writer) testResult {
+	senv, err := m.ServiceEnvironment(s.Name)
+	if err != nil {
+		return testResult{Service: s.Name, Err: err}
+	}
+
+	code, err := Rack(c).ProcessRun(app, types.ProcessRunOptions{
+		Command:     s.Test,
+		Environment: senv,
+		Release:     release,
+		Service:     s.Name,
+		Output:      w,
+	})
+
+	return testResult{Service: s.Name, Code: code, Err: err}
+}
@@ -1,6 +1,59 @@
 package main_test
 
-import "testing"
+import (
+	"errors"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/convox/praxis/manifest"
+	"github.com/stretchr/testify/assert"
+)
 
 func TestTest(t *testing.T) {
 }
+
+func TestTestServices(t *testing.T) {
+	m, err := manifest.Load([]byte(`
+services:
+  web:
+    test: make test
+  worker:
+    image: worker
+  api:
+    test: bin/test
+`), manifest.Environment{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	names := func(ss manifest.Services) []string {
+		ns := []string{}
+		for _, s := range ss {
+			ns = append(ns, s.Name)
+		}
+		return ns
+	}
+
+	// services with tests in manifest order
+	ss, err := cx.TestServices(m, "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"web", "api"}, names(ss))
+	}
+
+	ss, err = cx.TestServices(m, "api")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"api"}, names(ss))
+	}
+
+	_, err = cx.TestServices(m, "worker")
+	assert.EqualError(t, err, "service worker has no tests")
+
+	_, err = cx.TestServices(m, "nope")
+	assert.EqualError(t, err, "no such service: nope")
+}
+
+func TestTestResultStatus(t *testing.T) {
+	assert.Equal(t, "<ok>pass</ok>", cx.TestResult{Service: "web"}.Status())
+	assert.Equal(t, "<fail>fail</fail> (exit 2)", cx.TestResult{Service: "web", Code: 2}.Status())
+	assert.Equal(t, "<fail>error</fail>: no release", cx.TestResult{Service: "web", Err: errors.New("no release")}.Status())
+}
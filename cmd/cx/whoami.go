@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/convox/praxis/stdcli"
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+func init() {
+	stdcli.RegisterCommand(cli.Command{
+		Name:        "whoami",
+		Description: "show the current console and rack",
+		Action:      runWhoami,
+		Flags: append(globalFlags, cli.BoolFlag{
+			Name:  "json",
+			Usage: "output as json",
+		}),
+	})
+}
+
+type Whoami struct {
+	Console string `json:"console"`
+	Proxy   bool   `json:"proxy"`
+	Rack    string `json:"rack"`
+}
+
+func runWhoami(c *cli.Context) error {
+	console, err := consoleHost()
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	proxy, err := consoleProxy()
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	rack, err := rackFromContext(c)
+	if err != nil {
+		return stdcli.Error(err)
+	}
+
+	w := Whoami{
+		Console: console,
+		Proxy:   proxy != nil,
+		Rack:    rack,
+	}
+
+	if c.Bool("json") {
+		data, err := json.MarshalIndent(w, "", "  ")
+		if err != nil {
+			return stdcli.Error(err)
+		}
+
+		fmt.Println(string(data))
+		return nil
+	}
+
+	info := stdcli.NewInfo()
+
+	info.Add("Console", w.Console)
+	info.Add("Rack", w.Rack)
+
+	if w.Proxy {
+		info.Add("Proxy", "configured")
+	} else {
+		info.Add("Proxy", "not configured, try cx login")
+	}
+
+	info.Print()
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	cx "github.com/convox/praxis/cmd/cx"
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+// stdout runs fn and returns what it printed
+func stdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := os.Stdout
+	os.Stdout = w
+	stdcli.DefaultWriter.Stdout = w
+
+	fn()
+
+	os.Stdout = out
+	stdcli.DefaultWriter.Stdout = out
+	w.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestWhoami(t *testing.T) {
+	_, cleanup := consoleHome(t, "console.example.org")
+	defer cleanup()
+
+	out := stdout(t, func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "whoami", "--rack", "production", "--json"}))
+	})
+
+	var w cx.Whoami
+
+	if assert.NoError(t, json.Unmarshal([]byte(out), &w)) {
+		assert.Equal(t, cx.Whoami{Console: "console.example.org", Proxy: true, Rack: "production"}, w)
+	}
+
+	assert.NoError(t, stdcli.New().Run([]string{"cx", "logout"}))
+
+	out = stdout(t, func() {
+		assert.NoError(t, stdcli.New().Run([]string{"cx", "whoami", "--rack", "production", "--json"}))
+	})
+
+	// logged out falls back to the default console without a proxy
+	if assert.NoError(t, json.Unmarshal([]byte(out), &w)) {
+		assert.Equal(t, cx.Whoami{Console: "ui.convox.com", Proxy: false, Rack: "production"}, w)
+	}
+}
@@ -1,7 +1,12 @@
 package helpers
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"net"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -45,3 +50,41 @@ func TestIn(t *testing.T) {
 	assert.Equal(t, In("traveler", words), true)
 	assert.Equal(t, In("kashmir", words), false)
 }
+
+func TestPipeContext(t *testing.T) {
+	a, _ := net.Pipe()
+	b, _ := net.Pipe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ch := make(chan error, 1)
+
+	go func() {
+		ch <- PipeContext(ctx, a, b)
+	}()
+
+	cancel()
+
+	select {
+	case err := <-ch:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("pipe did not return after cancel")
+	}
+}
+
+func TestStreamContext(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := StreamContext(context.Background(), &buf, strings.NewReader("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", buf.String())
+
+	r, _ := io.Pipe()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = StreamContext(ctx, &buf, r)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"context"
 	"io"
 	"net/http"
 )
@@ -22,6 +23,11 @@ func Pipe(a, b io.ReadWriter) error {
 	return nil
 }
 
+// PipeContext is Pipe that closes both sides and returns when ctx is cancelled
+func PipeContext(ctx context.Context, a, b io.ReadWriter) error {
+	return withContext(ctx, func() error { return Pipe(a, b) }, a, b)
+}
+
 func Stream(w io.Writer, r io.Reader) error {
 	buf := make([]byte, 1024)
 
@@ -58,3 +64,28 @@ func StreamAsync(w io.Writer, r io.Reader, ch chan error) {
 		ch <- err
 	}
 }
+
+// StreamContext is Stream that closes w and r and returns when ctx is cancelled
+func StreamContext(ctx context.Context, w io.Writer, r io.Reader) error {
+	return withContext(ctx, func() error { return Stream(w, r) }, w, r)
+}
+
+func withContext(ctx context.Context, fn func() error, streams ...interface{}) error {
+	ch := make(chan error, 1)
+
+	go func() {
+		ch <- fn()
+	}()
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		for _, s := range streams {
+			if c, ok := s.(io.Closer); ok {
+				c.Close()
+			}
+		}
+		return ctx.Err()
+	}
+}
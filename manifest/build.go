@@ -18,13 +18,15 @@ import (
 )
 
 type BuildOptions struct {
-	Cache       string
-	Development bool
-	Env         Environment
-	Push        string
-	Root        string
-	Stdout      io.Writer
-	Stderr      io.Writer
+	Cache         string
+	Development   bool
+	Env           Environment
+	HashAlgorithm HashAlgorithm
+	HashEnv       bool
+	Push          string
+	Root          string
+	Stdout        io.Writer
+	Stderr        io.Writer
 }
 
 type BuildSource struct {
@@ -39,7 +41,17 @@ func (m *Manifest) Build(root, prefix string, tag string, opts BuildOptions) err
 	tags := map[string][]string{}
 
 	for _, s := range m.Services {
-		hash := s.BuildHash()
+		hash := opts.HashAlgorithm.BuildHash(s)
+
+		if opts.HashEnv && s.Image == "" {
+			env, err := s.BuildEnv(opts.Root, opts.Env)
+			if err != nil {
+				return err
+			}
+
+			hash = opts.HashAlgorithm.BuildHashWithEnv(s, env)
+		}
+
 		to := fmt.Sprintf("%s/%s:%s", prefix, s.Name, tag)
 
 		if s.Image != "" {
@@ -353,6 +365,10 @@ func build(b ServiceBuild, tag string, opts BuildOptions) error {
 		}
 	}
 
+	for _, a := range b.Args {
+		args = append(args, "--build-arg", a)
+	}
+
 	ba, err := buildArgs(df, opts)
 	if err != nil {
 		return err
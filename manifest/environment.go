@@ -1,11 +1,14 @@
 package manifest
 
 import (
+	"fmt"
 	"regexp"
+	"strings"
 )
 
 var (
 	regexpInterpolation = regexp.MustCompile(`\$\{([^}]*?)\}`)
+	regexpVariable      = regexp.MustCompile(`\$\$|\$\{[^}]*\}|\$[A-Za-z_][A-Za-z0-9_]*`)
 )
 
 type Environment map[string]string
@@ -17,3 +20,52 @@ func interpolate(data []byte, env Environment) ([]byte, error) {
 
 	return p, nil
 }
+
+// expandVariables replaces $VAR, ${VAR} and ${VAR:-default} in s using lookup, $$ is a literal $
+func expandVariables(s string, lookup func(string) (string, bool)) (string, error) {
+	var err error
+
+	out := regexpVariable.ReplaceAllStringFunc(s, func(m string) string {
+		if m == "$$" {
+			return "$"
+		}
+
+		name := strings.TrimPrefix(m, "$")
+		def := ""
+		hasDefault := false
+
+		if strings.HasPrefix(name, "{") {
+			name = name[1 : len(name)-1]
+
+			if parts := strings.SplitN(name, ":-", 2); len(parts) == 2 {
+				name, def, hasDefault = parts[0], parts[1], true
+			}
+		}
+
+		if name == "" {
+			err = fmt.Errorf("invalid variable reference: %s", m)
+			return m
+		}
+
+		v, ok := lookup(name)
+
+		switch {
+		case ok && (v != "" || !hasDefault):
+			return v
+		case hasDefault:
+			return def
+		}
+
+		if err == nil {
+			err = fmt.Errorf("undefined variable: %s", name)
+		}
+
+		return m
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return out, nil
+}
@@ -47,10 +47,8 @@ func Load(data []byte, env Environment) (*Manifest, error) {
 }
 
 func (m *Manifest) Service(name string) (*Service, error) {
-	for _, s := range m.Services {
-		if s.Name == name {
-			return &s, nil
-		}
+	if s, ok := m.Services.Find(name); ok {
+		return s, nil
 	}
 
 	return nil, fmt.Errorf("no such service: %s", name)
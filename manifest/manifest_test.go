@@ -73,6 +73,10 @@ func TestManifestLoad(t *testing.T) {
 					"SECRET",
 				},
 				Port: manifest.ServicePort{Port: 2000, Scheme: "https"},
+				Ports: []manifest.ServicePort{
+					{Port: 2001, Scheme: "http"},
+					{Port: 2002, Scheme: "tcp"},
+				},
 				Scale: manifest.ServiceScale{
 					Count:  &manifest.ServiceScaleCount{Min: 1, Max: 1},
 					Memory: 512,
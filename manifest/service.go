@@ -2,7 +2,12 @@ package manifest
 
 import (
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 )
 
 type Service struct {
@@ -15,6 +20,7 @@ type Service struct {
 	Health      ServiceHealth      `yaml:"health,omitempty"`
 	Image       string             `yaml:"image,omitempty"`
 	Port        ServicePort        `yaml:"port,omitempty"`
+	Ports       []ServicePort      `yaml:"ports,omitempty"`
 	Resources   []string           `yaml:"resources,omitempty"`
 	Scale       ServiceScale       `yaml:"scale,omitempty"`
 	Test        string             `yaml:"test,omitempty"`
@@ -23,6 +29,9 @@ type Service struct {
 
 type Services []Service
 
+// ServiceBuild describes how to build the image of a service.
+// Args may be given as a list of KEY=VALUE or as a map in the manifest, both are stored as KEY=VALUE entries.
+// Args are passed to docker before those taken from the build environment, so the environment takes precedence.
 type ServiceBuild struct {
 	Args []string `yaml:"args,omitempty"`
 	Path string   `yaml:"path,omitempty"`
@@ -57,8 +66,192 @@ type ServiceScaleCount struct {
 	Max int
 }
 
+// HashAlgorithm selects how build hashes are computed, hashes of different algorithms never collide.
+// The zero value is HashV1
+type HashAlgorithm string
+
+const (
+	// HashV1 is the original sha1 hash, it is not prefixed so existing build caches stay valid
+	HashV1 HashAlgorithm = "v1"
+
+	// HashV2 is a sha256 hash prefixed with "v2:"
+	HashV2 HashAlgorithm = "v2"
+)
+
+// BuildHash is the build hash of the service with the original HashV1 algorithm
 func (s Service) BuildHash() string {
-	return fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("build[path=%q, args=%v] image=%q", s.Build.Path, s.Build.Args, s.Image))))
+	return HashV1.BuildHash(s)
+}
+
+// BuildHashWithEnv is BuildHash that also covers env, the build args passed to docker for the
+// service as returned by BuildEnv, so changes to their values produce a new hash
+func (s Service) BuildHashWithEnv(env Environment) string {
+	return HashV1.BuildHashWithEnv(s, env)
+}
+
+// BuildEnv is the part of env passed to docker as build args for the service, the variables
+// declared with ARG in its Dockerfile under root
+func (s Service) BuildEnv(root string, env Environment) (Environment, error) {
+	args, err := buildArgs(filepath.Join(root, s.Build.Path, "Dockerfile"), BuildOptions{Env: env})
+	if err != nil {
+		return nil, err
+	}
+
+	be := Environment{}
+
+	for i := 0; i+1 < len(args); i += 2 {
+		if args[i] == "--build-arg" {
+			parts := strings.SplitN(args[i+1], "=", 2)
+			be[parts[0]] = parts[1]
+		}
+	}
+
+	return be, nil
+}
+
+// BuildHash is the build hash of s computed with this algorithm
+func (a HashAlgorithm) BuildHash(s Service) string {
+	return a.hash(fmt.Sprintf("build[path=%q, args=%v] image=%q", s.Build.Path, s.Build.Args, s.Image))
+}
+
+// BuildHashWithEnv is the build hash of s with the build args in env, computed with this algorithm
+func (a HashAlgorithm) BuildHashWithEnv(s Service, env Environment) string {
+	if len(env) == 0 {
+		return a.BuildHash(s)
+	}
+
+	vars := []string{}
+
+	for k, v := range env {
+		vars = append(vars, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	sort.Strings(vars)
+
+	return a.hash(fmt.Sprintf("build[path=%q, args=%v] image=%q env=%q", s.Build.Path, s.Build.Args, s.Image, vars))
+}
+
+func (a HashAlgorithm) hash(data string) string {
+	switch a {
+	case HashV2:
+		return fmt.Sprintf("%s:%x", a, sha256.Sum256([]byte(data)))
+	default:
+		return fmt.Sprintf("%x", sha1.Sum([]byte(data)))
+	}
+}
+
+// ResolveEnvironment expands variable references in the values of the service environment using lookup,
+// entries without a value are left for the manifest environment to fill in
+func (s Service) ResolveEnvironment(lookup func(string) (string, bool)) ([]string, error) {
+	env := make([]string, len(s.Environment))
+
+	for i, e := range s.Environment {
+		parts := strings.SplitN(e, "=", 2)
+
+		if len(parts) == 1 {
+			env[i] = e
+			continue
+		}
+
+		v, err := expandVariables(parts[1], lookup)
+		if err != nil {
+			return nil, fmt.Errorf("environment %s: %s", parts[0], err)
+		}
+
+		env[i] = fmt.Sprintf("%s=%s", parts[0], v)
+	}
+
+	return env, nil
+}
+
+// HasPort returns true if the service exposes port as its main port or one of its additional ports
+func (s Service) HasPort(port int) bool {
+	if s.Port.Port == port {
+		return port != 0
+	}
+
+	for _, p := range s.Ports {
+		if p.Port == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+var (
+	regexpServiceName    = regexp.MustCompile(`^[a-z]([a-z0-9-]{0,61}[a-z0-9])?$`)
+	regexpEnvironmentKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// Validate returns an error listing every problem with the service definition
+func (s Service) Validate() error {
+	errs := []string{}
+
+	if s.Name == "" {
+		errs = append(errs, "name is required")
+	} else if !regexpServiceName.MatchString(s.Name) {
+		errs = append(errs, fmt.Sprintf("name %q must be lowercase letters, numbers and dashes", s.Name))
+	}
+
+	switch {
+	case s.Build.Path != "" && s.Image != "":
+		errs = append(errs, "only one of build or image may be specified")
+	case s.Build.Path == "" && s.Image == "":
+		errs = append(errs, "one of build or image must be specified")
+	}
+
+	for _, e := range s.Environment {
+		if !regexpEnvironmentKey.MatchString(strings.SplitN(e, "=", 2)[0]) {
+			errs = append(errs, fmt.Sprintf("environment %q must be KEY or KEY=VALUE", e))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// Validate returns an error listing the problems of every service, prefixed by service name
+func (ss Services) Validate() error {
+	errs := []string{}
+
+	for _, s := range ss {
+		if err := s.Validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("service %s: %s", s.Name, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+
+	return nil
+}
+
+// Find returns the service with the given name, the pointer refers to the element
+// of the slice so changes through it are seen by the manifest
+func (ss Services) Find(name string) (*Service, bool) {
+	for i := range ss {
+		if ss[i].Name == name {
+			return &ss[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Names returns the service names in manifest order
+func (ss Services) Names() []string {
+	names := make([]string, len(ss))
+
+	for i, s := range ss {
+		names[i] = s.Name
+	}
+
+	return names
 }
 
 func (s Service) GetName() string {
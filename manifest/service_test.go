@@ -0,0 +1,228 @@
+package manifest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/manifest"
+	"github.com/stretchr/testify/assert"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestServiceHasPort(t *testing.T) {
+	m, err := testdataManifest("full", manifest.Environment{"FOO": "bar", "SECRET": "shh"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s, err := m.Service("proxy")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, s.HasPort(2000))
+	assert.True(t, s.HasPort(2001))
+	assert.True(t, s.HasPort(2002))
+	assert.False(t, s.HasPort(3000))
+
+	assert.False(t, manifest.Service{}.HasPort(0))
+}
+
+func TestServiceMarshalYAML(t *testing.T) {
+	ss := manifest.Services{
+		{Name: "web", Image: "nginx", Port: manifest.ServicePort{Port: 80, Scheme: "http"}, Environment: manifest.ServiceEnvironment{}},
+		{Name: "worker"},
+	}
+
+	data, err := yaml.Marshal(manifest.Manifest{Services: ss})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expected := `services:
+  web:
+    image: nginx
+    port:
+      port: 80
+      scheme: http
+  worker: {}
+`
+
+	assert.Equal(t, expected, string(data))
+
+	m, err := manifest.Load(data, manifest.Environment{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if assert.Len(t, m.Services, 2) {
+		assert.Equal(t, "nginx", m.Services[0].Image)
+		assert.Equal(t, manifest.ServicePort{Port: 80, Scheme: "http"}, m.Services[0].Port)
+		assert.Equal(t, "worker", m.Services[1].Name)
+	}
+}
+
+func TestServiceBuildHashWithEnv(t *testing.T) {
+	s := manifest.Service{
+		Build:       manifest.ServiceBuild{Path: "."},
+		Environment: manifest.ServiceEnvironment{"FOO", "BAR=baz"},
+	}
+
+	assert.Equal(t, s.BuildHash(), s.BuildHashWithEnv(manifest.Environment{}))
+
+	h1 := s.BuildHashWithEnv(manifest.Environment{"FOO": "1"})
+	h2 := s.BuildHashWithEnv(manifest.Environment{"FOO": "2"})
+	h3 := s.BuildHashWithEnv(manifest.Environment{"FOO": "1", "BAR": "baz"})
+
+	assert.NotEqual(t, s.BuildHash(), h1)
+	assert.NotEqual(t, h1, h2)
+	assert.NotEqual(t, h1, h3)
+	assert.Equal(t, h1, s.BuildHashWithEnv(manifest.Environment{"FOO": "1"}))
+
+	// only the build args count, not the environment of the service
+	s.Environment = manifest.ServiceEnvironment{}
+
+	assert.Equal(t, h1, s.BuildHashWithEnv(manifest.Environment{"FOO": "1"}))
+}
+
+func TestServiceBuildEnv(t *testing.T) {
+	s := manifest.Service{Build: manifest.ServiceBuild{Path: "build-env"}}
+
+	env, err := s.BuildEnv("testdata", manifest.Environment{"FOO": "1", "BAR": "2", "OTHER": "x"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, manifest.Environment{"FOO": "1", "BAR": "2"}, env)
+	}
+
+	env, err = s.BuildEnv("testdata", manifest.Environment{"OTHER": "x"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, manifest.Environment{}, env)
+	}
+
+	_, err = manifest.Service{Build: manifest.ServiceBuild{Path: "missing"}}.BuildEnv("testdata", manifest.Environment{})
+	assert.Error(t, err)
+}
+
+func TestServiceValidate(t *testing.T) {
+	m, err := testdataManifest("full", manifest.Environment{"FOO": "bar", "SECRET": "shh"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NoError(t, m.Services.Validate())
+
+	s := manifest.Service{Name: "web", Image: "nginx"}
+	assert.NoError(t, s.Validate())
+
+	s = manifest.Service{}
+	assert.EqualError(t, s.Validate(), "name is required, one of build or image must be specified")
+
+	s = manifest.Service{
+		Name:        "Web_1",
+		Build:       manifest.ServiceBuild{Path: "."},
+		Image:       "nginx",
+		Environment: manifest.ServiceEnvironment{"FOO", "BAR=baz", "=bad", "BAD KEY=1"},
+	}
+	assert.EqualError(t, s.Validate(), `name "Web_1" must be lowercase letters, numbers and dashes, only one of build or image may be specified, environment "=bad" must be KEY or KEY=VALUE, environment "BAD KEY=1" must be KEY or KEY=VALUE`)
+
+	ss := manifest.Services{
+		{Name: "web", Image: "nginx"},
+		{Name: "api"},
+		{Name: "-worker", Image: "worker"},
+	}
+	assert.EqualError(t, ss.Validate(), "service api: one of build or image must be specified\nservice -worker: name \"-worker\" must be lowercase letters, numbers and dashes")
+}
+
+func TestServiceBuildArgs(t *testing.T) {
+	m, err := testdataManifest("build-args", manifest.Environment{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s, err := m.Service("list")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"FOO=bar", "BAZ"}, s.Build.Args)
+	}
+
+	s, err = m.Service("map")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"BAZ", "FOO=bar", "ZED=1"}, s.Build.Args)
+	}
+}
+
+func TestServicesFind(t *testing.T) {
+	ss := manifest.Services{{Name: "web"}, {Name: "worker"}}
+
+	s, ok := ss.Find("worker")
+	if !assert.True(t, ok) {
+		return
+	}
+
+	s.Image = "ubuntu"
+
+	assert.Equal(t, "ubuntu", ss[1].Image)
+
+	_, ok = ss.Find("missing")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"web", "worker"}, ss.Names())
+}
+
+func TestServiceResolveEnvironment(t *testing.T) {
+	env := map[string]string{"DATABASE_URL": "postgres://db", "EMPTY": ""}
+
+	lookup := func(k string) (string, bool) {
+		v, ok := env[k]
+		return v, ok
+	}
+
+	s := manifest.Service{
+		Environment: manifest.ServiceEnvironment{
+			"DB_URL=${DATABASE_URL}",
+			"SHORT=$DATABASE_URL/app",
+			"DEFAULT=${MISSING:-fallback}",
+			"EMPTY=${EMPTY:-fallback}",
+			"LITERAL=plain",
+			"DOLLAR=$$HOME",
+			"SECRET",
+		},
+	}
+
+	resolved, err := s.ResolveEnvironment(lookup)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{
+			"DB_URL=postgres://db",
+			"SHORT=postgres://db/app",
+			"DEFAULT=fallback",
+			"EMPTY=fallback",
+			"LITERAL=plain",
+			"DOLLAR=$HOME",
+			"SECRET",
+		}, resolved)
+	}
+
+	s.Environment = manifest.ServiceEnvironment{"URL=${MISSING}"}
+
+	_, err = s.ResolveEnvironment(lookup)
+	assert.EqualError(t, err, "environment URL: undefined variable: MISSING")
+}
+
+func TestServiceBuildHashAlgorithm(t *testing.T) {
+	s := manifest.Service{Build: manifest.ServiceBuild{Path: "."}}
+
+	v1 := s.BuildHash()
+
+	assert.Len(t, v1, 40)
+	assert.Equal(t, v1, manifest.HashV1.BuildHash(s))
+	assert.Equal(t, v1, manifest.HashAlgorithm("").BuildHash(s))
+
+	v2 := manifest.HashV2.BuildHash(s)
+
+	assert.True(t, strings.HasPrefix(v2, "v2:"))
+	assert.Len(t, v2, 67)
+	assert.Equal(t, v2, manifest.HashV2.BuildHashWithEnv(s, manifest.Environment{}))
+
+	s.Environment = manifest.ServiceEnvironment{"FOO"}
+
+	assert.True(t, strings.HasPrefix(manifest.HashV2.BuildHashWithEnv(s, manifest.Environment{"FOO": "1"}), "v2:"))
+	assert.Equal(t, s.BuildHashWithEnv(manifest.Environment{"FOO": "1"}), manifest.HashV1.BuildHashWithEnv(s, manifest.Environment{"FOO": "1"}))
+}
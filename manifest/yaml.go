@@ -137,6 +137,26 @@ func (v *Service) SetName(name string) error {
 	return nil
 }
 
+// MarshalYAML leaves out every setting that is not set, yaml only omits empty values that are not structs
+func (v Service) MarshalYAML() (interface{}, error) {
+	ms := yaml.MapSlice{}
+
+	rv := reflect.ValueOf(v)
+
+	for i := 0; i < rv.NumField(); i++ {
+		name := strings.Split(rv.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		f := rv.Field(i)
+
+		if name == "-" || f.IsZero() || (f.Kind() == reflect.Slice && f.Len() == 0) {
+			continue
+		}
+
+		ms = append(ms, yaml.MapItem{Key: name, Value: f.Interface()})
+	}
+
+	return ms, nil
+}
+
 func (v *ServiceBuild) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var w interface{}
 
@@ -146,12 +166,18 @@ func (v *ServiceBuild) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	switch t := w.(type) {
 	case map[interface{}]interface{}:
-		type serviceBuild ServiceBuild
-		var r serviceBuild
+		var r struct {
+			Args interface{} `yaml:"args"`
+			Path string      `yaml:"path"`
+		}
 		if err := remarshal(w, &r); err != nil {
 			return err
 		}
-		v.Args = r.Args
+		args, err := unmarshalBuildArgs(r.Args)
+		if err != nil {
+			return err
+		}
+		v.Args = args
 		v.Path = r.Path
 	case string:
 		v.Path = t
@@ -162,6 +188,36 @@ func (v *ServiceBuild) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
+// unmarshalBuildArgs accepts build args as a list of KEY=VALUE or as a map,
+// a map is normalized to KEY=VALUE entries sorted by key so the build hash is stable
+func unmarshalBuildArgs(w interface{}) ([]string, error) {
+	switch t := w.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		args := []string{}
+		for _, a := range t {
+			args = append(args, fmt.Sprintf("%v", a))
+		}
+		return args, nil
+	case map[interface{}]interface{}:
+		args := []string{}
+		for k, a := range t {
+			if a == nil {
+				args = append(args, fmt.Sprintf("%v", k))
+			} else {
+				args = append(args, fmt.Sprintf("%v=%v", k, a))
+			}
+		}
+		sort.Slice(args, func(i, j int) bool {
+			return strings.SplitN(args[i], "=", 2)[0] < strings.SplitN(args[j], "=", 2)[0]
+		})
+		return args, nil
+	default:
+		return nil, fmt.Errorf("unknown type for service build args: %T", t)
+	}
+}
+
 func (v *ServiceCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var w interface{}
 
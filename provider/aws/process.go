@@ -141,6 +141,10 @@ func (p *Provider) ProcessList(app string, opts types.ProcessListOptions) (types
 			continue
 		}
 
+		if opts.Release != "" && ps.Release != opts.Release {
+			continue
+		}
+
 		pss = append(pss, *ps)
 	}
 
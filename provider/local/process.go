@@ -97,6 +97,10 @@ func (p *Provider) ProcessList(app string, opts types.ProcessListOptions) (types
 		filters = append(filters, fmt.Sprintf("label=convox.service=%s", opts.Service))
 	}
 
+	if opts.Release != "" {
+		filters = append(filters, fmt.Sprintf("label=convox.release=%s", opts.Release))
+	}
+
 	pss, err := processList(filters, false)
 	if err != nil {
 		return nil, errors.WithStack(log.Error(err))
@@ -0,0 +1,107 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// allowed reports whether a client ip may use this proxy, deny rules win over allow rules
+// and an empty allow list admits everyone
+func (p *Proxy) allowed(ip net.IP) bool {
+	if ip == nil {
+		return len(p.AllowCIDRs) == 0 && len(p.DenyCIDRs) == 0
+	}
+
+	for _, n := range p.DenyCIDRs {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(p.AllowCIDRs) == 0 {
+		return true
+	}
+
+	for _, n := range p.AllowCIDRs {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Proxy) hasAccessRules() bool {
+	return len(p.AllowCIDRs) > 0 || len(p.DenyCIDRs) > 0
+}
+
+// allowedAddr checks the client behind a connection address
+func (p *Proxy) allowedAddr(addr net.Addr) bool {
+	if !p.hasAccessRules() {
+		return true
+	}
+
+	return p.allowed(addrIP(addr.String()))
+}
+
+// accessControl rejects http clients outside the allowed networks with a 403
+func (p *Proxy) accessControl(h http.Handler) http.Handler {
+	if !p.hasAccessRules() {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(p.clientIP(r))
+
+		if !p.allowed(ip) {
+			logger.Log("access", Fields{"remote": r.RemoteAddr, "method": r.Method, "path": r.URL.Path, "status": http.StatusForbidden})
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// addrIP extracts the ip from a host:port address
+func addrIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return net.ParseIP(addr)
+}
+
+// optionCIDRs parses comma separated or repeated networks, a bare ip is a single host network
+func optionCIDRs(nets *[]*net.IPNet, values []string) error {
+	for _, v := range values {
+		for _, c := range strings.Split(v, ",") {
+			c = strings.TrimSpace(c)
+
+			if !strings.Contains(c, "/") {
+				ip := net.ParseIP(c)
+				if ip == nil {
+					return fmt.Errorf("invalid cidr: %s", c)
+				}
+
+				bits := 128
+				if ip.To4() != nil {
+					bits = 32
+				}
+
+				c = fmt.Sprintf("%s/%d", c, bits)
+			}
+
+			_, n, err := net.ParseCIDR(c)
+			if err != nil {
+				return err
+			}
+
+			*nets = append(*nets, n)
+		}
+	}
+
+	return nil
+}
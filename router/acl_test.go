@@ -0,0 +1,204 @@
+package router
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyAllowed(t *testing.T) {
+	tests := []struct {
+		allow   []string
+		deny    []string
+		ip      string
+		allowed bool
+	}{
+		{nil, nil, "10.0.0.1", true},
+		{[]string{"10.0.0.0/8"}, nil, "10.1.2.3", true},
+		{[]string{"10.0.0.0/8"}, nil, "192.168.0.1", false},
+		{[]string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.1.2.3", false},
+		{[]string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.2.0.1", true},
+		{nil, []string{"192.168.1.1"}, "192.168.1.1", false},
+		{nil, []string{"192.168.1.1"}, "192.168.1.2", true},
+		{[]string{"fd00::/8"}, nil, "fd00::1", true},
+		{[]string{"fd00::/8"}, nil, "fe80::1", false},
+		{[]string{"::/0"}, []string{"2001:db8::/32"}, "2001:db8::5", false},
+		{[]string{"10.0.0.0/8"}, nil, "::ffff:10.0.0.1", true},
+		{[]string{"10.0.0.0/8", "fd00::/8"}, nil, "fd12::1", true},
+	}
+
+	for _, tt := range tests {
+		p := &Proxy{}
+
+		assert.NoError(t, optionCIDRs(&p.AllowCIDRs, tt.allow))
+		assert.NoError(t, optionCIDRs(&p.DenyCIDRs, tt.deny))
+
+		assert.Equal(t, tt.allowed, p.allowed(net.ParseIP(tt.ip)), "allow=%v deny=%v ip=%s", tt.allow, tt.deny, tt.ip)
+	}
+}
+
+func TestOptionCIDRsInvalid(t *testing.T) {
+	var nets []*net.IPNet
+
+	assert.Error(t, optionCIDRs(&nets, []string{"10.0.0.0/33"}))
+	assert.Error(t, optionCIDRs(&nets, []string{"example.org"}))
+}
+
+func TestProxyAccessControl(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?allow=10.0.0.0/8,fd00::/8&deny=10.9.0.0/16")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		remote string
+		status int
+	}{
+		{"10.1.1.1:5000", http.StatusOK},
+		{"10.9.1.1:5000", http.StatusForbidden},
+		{"172.16.0.1:5000", http.StatusForbidden},
+		{"[fd00::1]:5000", http.StatusOK},
+		{"[2001:db8::1]:5000", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tt.status, w.Code, tt.remote)
+	}
+}
+
+func TestProxyTCPAccessControlProxyProtocol(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer backend.Close()
+
+	go func() {
+		for {
+			cn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+
+			cn.Write([]byte("ok"))
+			cn.Close()
+		}
+	}()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://0.0.0.0:5432")
+	target, _ := url.Parse(fmt.Sprintf("tcp://%s?allow=10.0.0.0/8&proxy_protocol=receive", backend.Addr()))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	go p.proxyTCP(l, p.Target)
+
+	// the load balancer at 127.0.0.1 is outside the allowed network, the clients it announces decide
+	tests := []struct {
+		client string
+		data   string
+	}{
+		{"10.1.1.1", "ok"},
+		{"172.16.0.1", ""},
+	}
+
+	for _, tt := range tests {
+		cn, err := net.Dial("tcp", l.Addr().String())
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		fmt.Fprintf(cn, "PROXY TCP4 %s 10.0.0.2 5000 5432\r\n", tt.client)
+
+		cn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+		data, err := ioutil.ReadAll(cn)
+		assert.NoError(t, err, tt.client)
+		assert.Equal(t, tt.data, string(data), tt.client)
+
+		cn.Close()
+	}
+}
+
+func TestProxyRateLimitForwardedFor(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	tests := []struct {
+		opts     string
+		requests []string
+		statuses []int
+	}{
+		// spoofed forwarded addresses do not get fresh buckets
+		{"", []string{"1.1.1.1", "2.2.2.2", ""}, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests}},
+		// a trusted load balancer appends the real client, which is what gets limited
+		{"&trust_forwarded_for=true", []string{"1.1.1.1, 10.0.0.5", "2.2.2.2, 10.0.0.5", "10.0.0.6"}, []int{http.StatusOK, http.StatusTooManyRequests, http.StatusOK}},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse(backend.URL + "?rate_limit=0.001&rate_limit_burst=1" + tt.opts)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		for i, xff := range tt.requests {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.168.0.1:5000"
+
+			if xff != "" {
+				r.Header.Set("X-Forwarded-For", xff)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.statuses[i], w.Code, "%s %s", tt.opts, xff)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package router
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultAuthRealm = "convox"
+
+// AuthOptions requires http clients to authenticate before requests are proxied
+type AuthOptions struct {
+	// Users maps basic auth user names to their passwords
+	Users map[string]string
+
+	// Token is accepted as an Authorization: Bearer credential
+	Token string
+
+	// Realm is sent in the WWW-Authenticate challenge, empty uses convox
+	Realm string
+
+	// Forward passes the Authorization header on to the backend, by default the proxy's
+	// credentials are removed once checked
+	Forward bool
+}
+
+func (o AuthOptions) enabled() bool {
+	return len(o.Users) > 0 || o.Token != ""
+}
+
+// authorized checks the credentials of r against every configured user so the time taken does
+// not reveal which user names exist
+func (o AuthOptions) authorized(r *http.Request) bool {
+	if o.Token != "" {
+		if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			return secureEqual(strings.TrimPrefix(auth, "Bearer "), o.Token)
+		}
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	match := false
+
+	for u, p := range o.Users {
+		if secureEqual(user, u) && secureEqual(pass, p) {
+			match = true
+		}
+	}
+
+	return match
+}
+
+// authenticate rejects http requests, websocket upgrades included, without valid credentials with a 401
+func (p *Proxy) authenticate(h http.Handler) http.Handler {
+	if !p.Auth.enabled() {
+		return h
+	}
+
+	realm := coalesceString(p.Auth.Realm, defaultAuthRealm)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.Auth.authorized(r) {
+			if len(p.Auth.Users) > 0 {
+				w.Header().Add("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+			}
+
+			if p.Auth.Token != "" {
+				w.Header().Add("WWW-Authenticate", fmt.Sprintf("Bearer realm=%q", realm))
+			}
+
+			logger.Log("access", Fields{"remote": r.RemoteAddr, "method": r.Method, "path": r.URL.Path, "status": http.StatusUnauthorized})
+			p.renderError(w, r, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		if !p.Auth.Forward {
+			r.Header.Del("Authorization")
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// secureEqual compares digests so neither the contents nor the length of a secret leak through timing
+func secureEqual(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// optionUsers parses repeated user:password values
+func optionUsers(users *map[string]string, values []string) error {
+	if *users == nil {
+		*users = map[string]string{}
+	}
+
+	for _, v := range values {
+		parts := strings.SplitN(v, ":", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("expected user:password")
+		}
+
+		(*users)[parts[0]] = parts[1]
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyAuthenticate(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?auth_user=admin:secret&auth_user=ops:hunter2&auth_token=tok3n")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		user, pass string
+		bearer     string
+		status     int
+	}{
+		{"", "", "", http.StatusUnauthorized},
+		{"admin", "secret", "", http.StatusOK},
+		{"ops", "hunter2", "", http.StatusOK},
+		{"admin", "hunter2", "", http.StatusUnauthorized},
+		{"nobody", "secret", "", http.StatusUnauthorized},
+		{"", "", "tok3n", http.StatusOK},
+		{"", "", "wrong", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+
+		if tt.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.bearer)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tt.status, w.Code, "%+v", tt)
+
+		if tt.status == http.StatusUnauthorized {
+			assert.Equal(t, []string{`Basic realm="convox"`, `Bearer realm="convox"`}, w.Header()["Www-Authenticate"])
+		}
+	}
+
+	// websocket upgrades are checked before any backend is dialed
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestProxyAuthenticateForward(t *testing.T) {
+	received := make(chan string, 1)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("Authorization")
+	}))
+	defer backend.Close()
+
+	for _, forward := range []bool{false, true} {
+		e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse(fmt.Sprintf("%s?auth_token=tok3n&auth_forward=%t", backend.URL, forward))
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer tok3n")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// the backend only sees the credentials when forwarding is asked for
+		if forward {
+			assert.Equal(t, "Bearer tok3n", <-received)
+		} else {
+			assert.Equal(t, "", <-received)
+		}
+	}
+}
+
+func TestOptionUsersInvalid(t *testing.T) {
+	var users map[string]string
+
+	assert.Error(t, optionUsers(&users, []string{"admin"}))
+	assert.Error(t, optionUsers(&users, []string{":secret"}))
+	assert.NoError(t, optionUsers(&users, []string{"admin:s:e:c"}))
+	assert.Equal(t, "s:e:c", users["admin"])
+}
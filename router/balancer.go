@@ -0,0 +1,69 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	mrand "math/rand"
+)
+
+// balancer rotates requests across a set of backends
+type balancer struct {
+	lock sync.Mutex
+	sets map[string]*balancerSet
+}
+
+type balancerSet struct {
+	counter uint64
+	ids     string
+}
+
+func newBalancer() *balancer {
+	return &balancer{sets: map[string]*balancerSet{}}
+}
+
+// Pick returns the next backend for key, backends are ordered by id so the
+// rotation is stable when the resolver returns them in a different order
+func (b *balancer) Pick(key string, bs []Backend) (*Backend, error) {
+	if len(bs) < 1 {
+		return nil, fmt.Errorf("no processes available")
+	}
+
+	sorted := make([]Backend, len(bs))
+	copy(sorted, bs)
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+
+	ids := make([]string, len(sorted))
+
+	for i, be := range sorted {
+		ids[i] = be.Id
+	}
+
+	s := b.set(key, strings.Join(ids, ","))
+
+	n := atomic.AddUint64(&s.counter, 1)
+
+	return &sorted[n%uint64(len(sorted))], nil
+}
+
+// set returns the rotation for key, starting at a random offset whenever the backend set changes
+func (b *balancer) set(key, ids string) *balancerSet {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	s, ok := b.sets[key]
+	if !ok || s.ids != ids {
+		s = &balancerSet{counter: uint64(mrand.Int63()), ids: ids}
+		b.sets[key] = s
+	}
+
+	return s
+}
+
+func balancerKey(app, service string, port int) string {
+	return fmt.Sprintf("%s/%s:%d", app, service, port)
+}
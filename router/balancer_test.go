@@ -0,0 +1,62 @@
+package router
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalancerRoundRobin(t *testing.T) {
+	b := newBalancer()
+
+	pss := []Backend{{Id: "web-3"}, {Id: "web-1"}, {Id: "web-2"}}
+
+	first, err := b.Pick("app/web:3000", pss)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	picks := []string{first.Id}
+
+	// the rotation holds when the rack reorders the same processes
+	reordered := []Backend{pss[1], pss[2], pss[0]}
+
+	for i := 0; i < 5; i++ {
+		ps, err := b.Pick("app/web:3000", reordered)
+		if !assert.NoError(t, err) {
+			return
+		}
+		picks = append(picks, ps.Id)
+	}
+
+	assert.Equal(t, picks[:3], picks[3:])
+
+	rotation := append([]string{}, picks[:3]...)
+	sort.Strings(rotation)
+
+	assert.Equal(t, []string{"web-1", "web-2", "web-3"}, rotation)
+
+	_, err = b.Pick("app/web:3000", []Backend{})
+	assert.EqualError(t, err, "no processes available")
+}
+
+func TestBalancerProcessSetChange(t *testing.T) {
+	b := newBalancer()
+
+	b.Pick("app/web:3000", []Backend{{Id: "web-1"}, {Id: "web-2"}})
+
+	seen := map[string]bool{}
+
+	for i := 0; i < 3; i++ {
+		ps, err := b.Pick("app/web:3000", []Backend{{Id: "web-1"}, {Id: "web-2"}, {Id: "web-3"}})
+		if !assert.NoError(t, err) {
+			return
+		}
+		seen[ps.Id] = true
+	}
+
+	assert.Len(t, seen, 3)
+
+	assert.Equal(t, "app/web:3000", balancerKey("app", "web", 3000))
+}
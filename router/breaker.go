@@ -0,0 +1,137 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultBreakerCooldown = 30 * time.Second
+
+// errCircuitOpen fails requests to a backend while its circuit is open
+var errCircuitOpen = errors.New("circuit open")
+
+// BreakerOptions opens a circuit to a rack service after consecutive failures, a zero threshold disables it
+type BreakerOptions struct {
+	// Threshold is the number of consecutive failures that opens the circuit
+	Threshold int
+
+	// Cooldown is how long an open circuit fails fast before a probe request is let through
+	Cooldown time.Duration
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type circuitBreaker struct {
+	options BreakerOptions
+
+	failures int
+	lock     sync.Mutex
+	opened   time.Time
+	state    breakerState
+}
+
+// breakers keeps a circuit breaker per backend key
+type breakers struct {
+	lock     sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func (b *breakers) get(key string, opts BreakerOptions) *circuitBreaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.breakers == nil {
+		b.breakers = map[string]*circuitBreaker{}
+	}
+
+	cb, ok := b.breakers[key]
+	if !ok {
+		cb = &circuitBreaker{options: opts}
+		b.breakers[key] = cb
+	}
+
+	return cb
+}
+
+// allow reports whether a request may be sent, letting a single probe through once the cooldown passed
+func (cb *circuitBreaker) allow() bool {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.opened) < coalesceDuration(cb.options.Cooldown, defaultBreakerCooldown) {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		return false
+	}
+
+	return true
+}
+
+// release reopens the circuit after a probe that ended without an answer so the next one can go through
+func (cb *circuitBreaker) release() {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if cb.state == breakerHalfOpen {
+		cb.state = breakerOpen
+	}
+}
+
+func (cb *circuitBreaker) record(success bool) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+
+	if success {
+		cb.failures = 0
+		cb.state = breakerClosed
+		return
+	}
+
+	cb.failures++
+
+	if cb.state == breakerHalfOpen || cb.failures >= cb.options.Threshold {
+		cb.state = breakerOpen
+		cb.opened = time.Now()
+	}
+}
+
+// breakerTransport fails fast with errCircuitOpen while the circuit of its backend is open
+type breakerTransport struct {
+	http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func (t breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.breaker.allow() {
+		return nil, errCircuitOpen
+	}
+
+	res, err := t.RoundTripper.RoundTrip(req)
+
+	switch {
+	case req.Context().Err() != nil:
+		// a client that went away says nothing about the backend, but a probe must not stay half open
+		t.breaker.release()
+	case err != nil:
+		t.breaker.record(false)
+	case res.StatusCode == http.StatusBadGateway, res.StatusCode == http.StatusServiceUnavailable, res.StatusCode == http.StatusGatewayTimeout:
+		t.breaker.record(false)
+	default:
+		t.breaker.record(true)
+	}
+
+	return res, err
+}
@@ -0,0 +1,192 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// statusTransport answers every request with its current status and counts the requests
+type statusTransport struct {
+	lock     sync.Mutex
+	requests int
+	status   int
+}
+
+func (t *statusTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.requests++
+
+	if t.status == 0 {
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	return &http.Response{StatusCode: t.status, Body: ioutil.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func (t *statusTransport) set(status int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.status = status
+}
+
+func (t *statusTransport) count() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return t.requests
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	cb := &circuitBreaker{options: BreakerOptions{Threshold: 3, Cooldown: 50 * time.Millisecond}}
+
+	// the circuit opens on the threshold of consecutive failures
+	cb.record(false)
+	cb.record(false)
+	cb.record(true)
+	cb.record(false)
+	cb.record(false)
+	assert.True(t, cb.allow())
+
+	cb.record(false)
+	assert.False(t, cb.allow())
+
+	time.Sleep(60 * time.Millisecond)
+
+	// a single probe is let through once the cooldown passed
+	assert.True(t, cb.allow())
+	assert.False(t, cb.allow())
+
+	// a failed probe opens the circuit again right away
+	cb.record(false)
+	assert.False(t, cb.allow())
+
+	time.Sleep(60 * time.Millisecond)
+
+	// a successful probe closes the circuit
+	assert.True(t, cb.allow())
+	cb.record(true)
+	assert.True(t, cb.allow())
+	assert.True(t, cb.allow())
+}
+
+func TestBreakerTransport(t *testing.T) {
+	backend := &statusTransport{status: http.StatusBadGateway}
+
+	rt := breakerTransport{RoundTripper: backend, breaker: &circuitBreaker{options: BreakerOptions{Threshold: 2, Cooldown: 2 * time.Second}}}
+
+	roundTrip := func() *http.Response {
+		res, _ := rt.RoundTrip(httptest.NewRequest("GET", "http://web.app/", nil))
+		return res
+	}
+
+	assert.Equal(t, http.StatusBadGateway, roundTrip().StatusCode)
+	assert.Equal(t, http.StatusBadGateway, roundTrip().StatusCode)
+	assert.Equal(t, 2, backend.count())
+
+	// an open circuit fails fast without reaching the backend
+	_, err := rt.RoundTrip(httptest.NewRequest("GET", "http://web.app/", nil))
+	assert.Equal(t, errCircuitOpen, err)
+	assert.Equal(t, 2, backend.count())
+
+	// the backend recovers and the probe after the cooldown closes the circuit
+	backend.set(http.StatusOK)
+	rt.breaker.opened = time.Now().Add(-3 * time.Second)
+
+	assert.Equal(t, http.StatusOK, roundTrip().StatusCode)
+	assert.Equal(t, http.StatusOK, roundTrip().StatusCode)
+	assert.Equal(t, 4, backend.count())
+
+	// connection errors count as failures
+	backend.set(0)
+
+	for i := 0; i < 2; i++ {
+		_, err := rt.RoundTrip(httptest.NewRequest("GET", "http://web.app/", nil))
+		assert.Error(t, err)
+	}
+
+	_, err = rt.RoundTrip(httptest.NewRequest("GET", "http://web.app/", nil))
+	assert.Equal(t, errCircuitOpen, err)
+	assert.Equal(t, 6, backend.count())
+}
+
+func TestBreakerTransportCanceled(t *testing.T) {
+	backend := &statusTransport{}
+
+	rt := breakerTransport{RoundTripper: backend, breaker: &circuitBreaker{options: BreakerOptions{Threshold: 1, Cooldown: time.Minute}}}
+
+	canceled := func() *http.Request {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		return httptest.NewRequest("GET", "http://web.app/", nil).WithContext(ctx)
+	}
+
+	// clients going away do not open the circuit
+	for i := 0; i < 3; i++ {
+		_, err := rt.RoundTrip(canceled())
+		assert.Error(t, err)
+	}
+
+	assert.Equal(t, 3, backend.count())
+	assert.True(t, rt.breaker.allow())
+
+	// nor do they leave a probe half open
+	rt.breaker.record(false)
+	rt.breaker.opened = time.Now().Add(-2 * time.Minute)
+
+	rt.RoundTrip(canceled())
+
+	backend.set(http.StatusOK)
+
+	res, err := rt.RoundTrip(httptest.NewRequest("GET", "http://web.app/", nil))
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestProxyBreakerOpenError(t *testing.T) {
+	p := &Proxy{Breaker: BreakerOptions{Threshold: 1, Cooldown: 2 * time.Second}}
+
+	r := httptest.NewRequest("GET", "http://web.app/", nil)
+	r.Header.Set("Accept", "application/json")
+
+	w := httptest.NewRecorder()
+	p.proxyError(w, r, errCircuitOpen)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), `"message":"service unavailable"`)
+}
+
+func TestProxyBreakerOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000?breaker_threshold=5&breaker_cooldown=10s")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, BreakerOptions{Threshold: 5, Cooldown: 10 * time.Second}, p.Breaker)
+
+	// each service gets its own breaker
+	a := p.breakers.get("app/web", p.Breaker)
+	assert.Equal(t, a, p.breakers.get("app/web", p.Breaker))
+	assert.NotEqual(t, fmt.Sprintf("%p", a), fmt.Sprintf("%p", p.breakers.get("app/worker", p.Breaker)))
+}
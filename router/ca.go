@@ -10,7 +10,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"net"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -82,7 +84,8 @@ func generateCACertificate() (tls.Certificate, error) {
 	return cert, nil
 }
 
-func (r *Router) generateCertificate(host string) (tls.Certificate, error) {
+// generateCertificate signs a certificate for the dns names, which may be wildcards, and ip addresses
+func (r *Router) generateCertificate(names []string, ips []net.IP) (tls.Certificate, error) {
 	rkey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
 		return tls.Certificate{}, err
@@ -101,7 +104,7 @@ func (r *Router) generateCertificate(host string) (tls.Certificate, error) {
 	template := x509.Certificate{
 		SerialNumber: serial,
 		Subject: pkix.Name{
-			CommonName:   host,
+			CommonName:   names[0],
 			Organization: []string{"convox"},
 		},
 		Issuer:                cpub.Subject,
@@ -110,7 +113,8 @@ func (r *Router) generateCertificate(host string) (tls.Certificate, error) {
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
-		DNSNames:              []string{host, fmt.Sprintf("*.%s", host)},
+		DNSNames:              names,
+		IPAddresses:           ips,
 	}
 
 	data, err := x509.CreateCertificate(rand.Reader, &template, cpub, &rkey.PublicKey, r.ca.PrivateKey)
@@ -123,3 +127,43 @@ func (r *Router) generateCertificate(host string) (tls.Certificate, error) {
 
 	return tls.X509KeyPair(pub, key)
 }
+
+// GetCertificate returns the certificate served for host and its parsed leaf, generating and
+// caching it on first use
+func (r *Router) GetCertificate(host string) (tls.Certificate, *x509.Certificate, error) {
+	host = strings.ToLower(host)
+
+	return r.certificate([]string{host, fmt.Sprintf("*.%s", host)}, nil)
+}
+
+// certificate returns a cached certificate covering exactly these names and ips
+func (r *Router) certificate(names []string, ips []net.IP) (tls.Certificate, *x509.Certificate, error) {
+	key := strings.Join(names, ",")
+
+	for _, ip := range ips {
+		key += "," + ip.String()
+	}
+
+	r.certLock.Lock()
+	defer r.certLock.Unlock()
+
+	if cert, ok := r.certs.get(key); ok && !renewalDue(cert.Leaf) {
+		return cert, cert.Leaf, nil
+	}
+
+	cert, err := r.generateCertificate(names, ips)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	cert.Leaf = leaf
+
+	r.certs.put(key, cert)
+
+	return cert, leaf, nil
+}
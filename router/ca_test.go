@@ -0,0 +1,166 @@
+package router
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterCertificateSANs(t *testing.T) {
+	r := testRouter(t)
+
+	_, leaf, err := r.certificate([]string{"web.convox", "*.web.convox", "*.example.org"}, []net.IP{net.ParseIP("10.42.0.5")})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "web.convox", leaf.Subject.CommonName)
+	assert.Equal(t, []string{"web.convox", "*.web.convox", "*.example.org"}, leaf.DNSNames)
+
+	if assert.Len(t, leaf.IPAddresses, 1) {
+		assert.True(t, leaf.IPAddresses[0].Equal(net.ParseIP("10.42.0.5")))
+	}
+
+	assert.NoError(t, leaf.VerifyHostname("web.convox"))
+	assert.NoError(t, leaf.VerifyHostname("api.web.convox"))
+	assert.NoError(t, leaf.VerifyHostname("www.example.org"))
+	assert.NoError(t, leaf.VerifyHostname("10.42.0.5"))
+	assert.Error(t, leaf.VerifyHostname("example.org"))
+	assert.Error(t, leaf.VerifyHostname("other.convox"))
+}
+
+func TestRouterCertificateCache(t *testing.T) {
+	r := testRouter(t)
+
+	c1, _, err := r.GetCertificate("Web.Convox")
+	assert.NoError(t, err)
+
+	c2, leaf, err := r.GetCertificate("web.convox")
+	assert.NoError(t, err)
+
+	assert.Equal(t, c1.Certificate, c2.Certificate)
+	assert.Equal(t, []string{"web.convox", "*.web.convox"}, leaf.DNSNames)
+}
+
+func TestProxyGetCertificate(t *testing.T) {
+	r := testRouter(t)
+
+	r.endpoints = map[string]Endpoint{"other.convox": {Host: "other.convox"}}
+
+	e := &Endpoint{Host: "web.convox", IP: net.ParseIP("10.42.0.5"), Aliases: []string{"*.example.org"}, router: r}
+	p := &Proxy{endpoint: e}
+
+	tests := []struct {
+		server string
+		name   string
+	}{
+		{"", "web.convox"},
+		{"web.convox", "web.convox"},
+		{"api.web.convox", "web.convox"},
+		{"www.example.org", "web.convox"},
+		{"other.convox", "other.convox"},
+		{"api.other.convox", "api.other.convox"},
+		{"random.convox", ""},
+		{"a.b.other.convox", ""},
+	}
+
+	for _, tt := range tests {
+		cert, err := p.getCertificate(&tls.ClientHelloInfo{ServerName: tt.server})
+
+		if tt.name == "" {
+			assert.EqualError(t, err, "unknown server name: "+tt.server)
+			continue
+		}
+
+		if assert.NoError(t, err, tt.server) {
+			assert.Equal(t, tt.name, cert.Leaf.Subject.CommonName, tt.server)
+		}
+	}
+
+	assert.Equal(t, 3, r.certs.lru.Len())
+}
+
+func TestCertificateCacheBounded(t *testing.T) {
+	var c certificateCache
+
+	for i := 0; i < maxCachedCertificates+10; i++ {
+		c.put(fmt.Sprintf("host-%d", i), tls.Certificate{})
+	}
+
+	assert.Equal(t, maxCachedCertificates, c.lru.Len())
+	assert.Len(t, c.entries, maxCachedCertificates)
+
+	_, ok := c.get("host-0")
+	assert.False(t, ok)
+
+	_, ok = c.get(fmt.Sprintf("host-%d", maxCachedCertificates+9))
+	assert.True(t, ok)
+}
+
+type countingSource struct {
+	calls   int
+	expires time.Duration
+}
+
+func (s *countingSource) GetCertificate(host string) (tls.Certificate, error) {
+	s.calls++
+	return tls.Certificate{Certificate: [][]byte{{}}, Leaf: &x509.Certificate{NotAfter: time.Now().Add(s.expires)}}, nil
+}
+
+func TestCachedCertificateSource(t *testing.T) {
+	fresh := &countingSource{expires: 90 * 24 * time.Hour}
+	cs := NewCachedCertificateSource(fresh)
+
+	for i := 0; i < 3; i++ {
+		_, err := cs.GetCertificate("Web.Example.org")
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 1, fresh.calls)
+
+	expiring := &countingSource{expires: 24 * time.Hour}
+	cs = NewCachedCertificateSource(expiring)
+
+	for i := 0; i < 3; i++ {
+		_, err := cs.GetCertificate("web.example.org")
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 3, expiring.calls)
+}
+
+func TestProxyCertificateSource(t *testing.T) {
+	r := testRouter(t)
+
+	hosts := []string{}
+
+	r.Certificates = CertificateFunc(func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		hosts = append(hosts, hello.ServerName)
+		cert, _, err := r.GetCertificate(hello.ServerName)
+		return &cert, err
+	})
+
+	e := &Endpoint{Host: "web.convox", Aliases: []string{"*.example.org"}, router: r}
+	p := &Proxy{endpoint: e}
+
+	// the certificates of the source are cached
+	for i := 0; i < 2; i++ {
+		for _, server := range []string{"", "web.convox", "other.example.org"} {
+			_, err := p.getCertificate(&tls.ClientHelloInfo{ServerName: server})
+			assert.NoError(t, err, server)
+		}
+	}
+
+	assert.Equal(t, []string{"web.convox", "other.example.org"}, hosts)
+
+	// a source that is already cached is not wrapped again
+	r = testRouter(t)
+	r.Certificates = NewCachedCertificateSource(&countingSource{expires: 90 * 24 * time.Hour})
+
+	assert.Equal(t, r.Certificates, r.certificateSource())
+}
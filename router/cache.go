@@ -0,0 +1,90 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultBackendCacheTTL = 2 * time.Second
+
+// backendCache remembers resolved backends for a short time and collapses
+// concurrent lookups of the same service into a single call
+type backendCache struct {
+	entries  map[string]*backendCacheEntry
+	inflight map[string]*backendCall
+	lock     sync.Mutex
+}
+
+type backendCacheEntry struct {
+	backends []Backend
+	expires  time.Time
+}
+
+type backendCall struct {
+	backends []Backend
+	done     chan struct{}
+	err      error
+}
+
+func newBackendCache() *backendCache {
+	return &backendCache{
+		entries:  map[string]*backendCacheEntry{},
+		inflight: map[string]*backendCall{},
+	}
+}
+
+func (c *backendCache) resolve(r BackendResolver, app, service string, ttl time.Duration) ([]Backend, error) {
+	key := fmt.Sprintf("%s/%s", app, service)
+
+	c.lock.Lock()
+
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expires) {
+		c.lock.Unlock()
+		return e.backends, nil
+	}
+
+	if call, ok := c.inflight[key]; ok {
+		c.lock.Unlock()
+		<-call.done
+		return call.backends, call.err
+	}
+
+	call := &backendCall{done: make(chan struct{})}
+	c.inflight[key] = call
+
+	c.lock.Unlock()
+
+	call.backends, call.err = r.Resolve(app, service)
+
+	c.lock.Lock()
+
+	delete(c.inflight, key)
+
+	if call.err == nil {
+		c.entries[key] = &backendCacheEntry{backends: call.backends, expires: time.Now().Add(ttl)}
+	}
+
+	c.lock.Unlock()
+
+	close(call.done)
+
+	return call.backends, call.err
+}
+
+// invalidate forgets the backends of a service so the next lookup asks the resolver again
+func (c *backendCache) invalidate(app, service string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.entries, fmt.Sprintf("%s/%s", app, service))
+}
+
+// resolve looks up the backends of a service through the cache
+func (p *Proxy) resolve(app, service string) ([]Backend, error) {
+	if p.BackendCacheTTL < 0 {
+		return p.resolver().Resolve(app, service)
+	}
+
+	return p.backendCache.resolve(p.resolver(), app, service, coalesceDuration(p.BackendCacheTTL, defaultBackendCacheTTL))
+}
@@ -0,0 +1,138 @@
+package router
+
+import (
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingResolver resolves every service to the same backends and counts the calls
+type countingResolver struct {
+	backends []Backend
+	calls    int
+	delay    time.Duration
+	lock     sync.Mutex
+}
+
+func (r *countingResolver) Resolve(app, service string) ([]Backend, error) {
+	time.Sleep(r.delay)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.calls++
+
+	return r.backends, nil
+}
+
+func (r *countingResolver) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.calls
+}
+
+func TestBackendCacheExpires(t *testing.T) {
+	c := newBackendCache()
+	r := &countingResolver{backends: []Backend{{Id: "web-1"}}}
+
+	for i := 0; i < 3; i++ {
+		bs, err := c.resolve(r, "app", "web", 50*time.Millisecond)
+		assert.NoError(t, err)
+		assert.Equal(t, []Backend{{Id: "web-1"}}, bs)
+	}
+
+	assert.Equal(t, 1, r.count())
+
+	time.Sleep(100 * time.Millisecond)
+
+	_, err := c.resolve(r, "app", "web", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.count())
+
+	_, err = c.resolve(r, "app", "api", 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, r.count())
+}
+
+func TestBackendCacheCollapsesConcurrentCalls(t *testing.T) {
+	c := newBackendCache()
+	r := &countingResolver{backends: []Backend{{Id: "web-1"}}, delay: 100 * time.Millisecond}
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			bs, err := c.resolve(r, "app", "web", time.Minute)
+			assert.NoError(t, err)
+			assert.Equal(t, []Backend{{Id: "web-1"}}, bs)
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 1, r.count())
+}
+
+func TestBackendCacheInvalidate(t *testing.T) {
+	c := newBackendCache()
+	r := &countingResolver{backends: []Backend{{Id: "web-1"}}}
+
+	c.resolve(r, "app", "web", time.Minute)
+	c.resolve(r, "app", "api", time.Minute)
+
+	c.invalidate("app", "web")
+
+	c.resolve(r, "app", "web", time.Minute)
+	c.resolve(r, "app", "api", time.Minute)
+
+	assert.Equal(t, 3, r.count())
+}
+
+func TestProxyResolveCacheOff(t *testing.T) {
+	r := &countingResolver{backends: []Backend{{Id: "web-1"}}}
+
+	p := &Proxy{Resolver: r, BackendCacheTTL: -1, backendCache: newBackendCache()}
+
+	for i := 0; i < 3; i++ {
+		bs, err := p.resolve("app", "web")
+		assert.NoError(t, err)
+		assert.Equal(t, []Backend{{Id: "web-1"}}, bs)
+	}
+
+	assert.Equal(t, 3, r.count())
+
+	p.BackendCacheTTL = 0
+
+	for i := 0; i < 3; i++ {
+		p.resolve("app", "web")
+	}
+
+	assert.Equal(t, 4, r.count())
+}
+
+func TestProxyBackendCacheOptions(t *testing.T) {
+	listen, _ := url.Parse("http://0.0.0.0:80")
+
+	for opt, ttl := range map[string]time.Duration{
+		"":                      0,
+		"backend_cache_ttl=10s": 10 * time.Second,
+		"backend_cache_ttl=off": -1,
+	} {
+		e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+		target, _ := url.Parse("http://rack/app/service/web:3000?" + opt)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if assert.NoError(t, err, opt) {
+			assert.Equal(t, ttl, p.BackendCacheTTL, opt)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package router
+
+import (
+	"container/list"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// certificateRenewBefore is how long before expiry a cached certificate is replaced
+const certificateRenewBefore = 30 * 24 * time.Hour
+
+// maxCachedCertificates bounds each certificate cache, the least recently used certificate is dropped first
+const maxCachedCertificates = 1000
+
+// CertificateSource provides the certificates served by tls listeners. The router has no acme
+// client of its own, acme is out of scope and left to a source such as an autocert manager
+// adapted with CertificateFunc. The router only caches certificates and asks for renewals
+type CertificateSource interface {
+	GetCertificate(host string) (tls.Certificate, error)
+}
+
+// selfSignedSource signs certificates with the router ca, the default for local development
+type selfSignedSource struct {
+	router *Router
+}
+
+func (s selfSignedSource) GetCertificate(host string) (tls.Certificate, error) {
+	cert, _, err := s.router.GetCertificate(host)
+	return cert, err
+}
+
+// CertificateFunc adapts a tls callback such as autocert.Manager.GetCertificate into a source so
+// public endpoints can use acme certificates. Only the server name is passed through, so the
+// manager must answer http-01 challenges rather than tls-alpn-01 ones
+type CertificateFunc func(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+
+func (fn CertificateFunc) GetCertificate(host string) (tls.Certificate, error) {
+	cert, err := fn(&tls.ClientHelloInfo{ServerName: host})
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	if cert == nil {
+		return tls.Certificate{}, fmt.Errorf("no certificate for %s", host)
+	}
+
+	return *cert, nil
+}
+
+// cachedSource remembers the certificates of another source until they are due for renewal
+type cachedSource struct {
+	source CertificateSource
+	certs  certificateCache
+	lock   sync.Mutex
+}
+
+// NewCachedCertificateSource caches certificates from source per host, fetching a new one
+// when the cached certificate nears expiry. Only the most recently used hosts are kept
+func NewCachedCertificateSource(source CertificateSource) CertificateSource {
+	return &cachedSource{source: source}
+}
+
+func (s *cachedSource) GetCertificate(host string) (tls.Certificate, error) {
+	host = strings.ToLower(host)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	cert, ok := s.certs.get(host)
+	if ok && !renewalDue(cert.Leaf) {
+		return cert, nil
+	}
+
+	fresh, err := s.source.GetCertificate(host)
+	if err != nil {
+		// keep serving a certificate that is due for renewal but still valid
+		if ok && time.Now().Before(cert.Leaf.NotAfter) {
+			logger.Log("certificate", Fields{"host": host, "renew": true, "error": err})
+			return cert, nil
+		}
+		return tls.Certificate{}, err
+	}
+
+	if len(fresh.Certificate) == 0 {
+		return tls.Certificate{}, fmt.Errorf("empty certificate for %s", host)
+	}
+
+	if fresh.Leaf == nil {
+		leaf, err := x509.ParseCertificate(fresh.Certificate[0])
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		fresh.Leaf = leaf
+	}
+
+	s.certs.put(host, fresh)
+
+	return fresh, nil
+}
+
+// certificateSource is the configured source of the router behind a cache, or its self-signed ca
+// which caches the certificates it signs itself
+func (r *Router) certificateSource() CertificateSource {
+	if r.Certificates == nil {
+		return selfSignedSource{router: r}
+	}
+
+	r.sourceOnce.Do(func() {
+		r.source = r.Certificates
+
+		if _, ok := r.source.(*cachedSource); !ok {
+			r.source = NewCachedCertificateSource(r.source)
+		}
+	})
+
+	return r.source
+}
+
+func renewalDue(leaf *x509.Certificate) bool {
+	return leaf == nil || time.Now().Add(certificateRenewBefore).After(leaf.NotAfter)
+}
+
+// certificateCache keeps the most recently used certificates, callers hold their own lock
+type certificateCache struct {
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cachedCertificate struct {
+	key  string
+	cert tls.Certificate
+}
+
+func (c *certificateCache) get(key string) (tls.Certificate, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return tls.Certificate{}, false
+	}
+
+	c.lru.MoveToFront(e)
+
+	return e.Value.(*cachedCertificate).cert, true
+}
+
+func (c *certificateCache) put(key string, cert tls.Certificate) {
+	if c.entries == nil {
+		c.entries = map[string]*list.Element{}
+		c.lru = list.New()
+	}
+
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cachedCertificate).cert = cert
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&cachedCertificate{key: key, cert: cert})
+
+	for c.lru.Len() > maxCachedCertificates {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedCertificate).key)
+	}
+}
+
+// servesHost reports whether host is a name of an endpoint on the router, so certificates are
+// not issued for arbitrary server names sent by clients
+func (r *Router) servesHost(host string) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, e := range r.endpoints {
+		if e.Host != "" && e.servesHost(host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// servesHost reports whether host is covered by the names of the endpoint certificate
+func (e *Endpoint) servesHost(host string) bool {
+	names, _ := e.certificateNames()
+
+	for _, name := range names {
+		if matchHostname(name, host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchHostname matches host against a certificate name, a wildcard covers a single label
+func matchHostname(name, host string) bool {
+	if !strings.HasPrefix(name, "*.") {
+		return name == host
+	}
+
+	suffix := name[1:]
+
+	return strings.HasSuffix(host, suffix) && !strings.Contains(strings.TrimSuffix(host, suffix), ".") && len(host) > len(suffix)
+}
@@ -0,0 +1,166 @@
+package router
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const defaultCompressionMinSize = 1024
+
+var defaultCompressionTypes = []string{
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+	"text/*",
+}
+
+// CompressionOptions gzips responses for clients that accept it
+type CompressionOptions struct {
+	Enabled bool
+
+	// MinSize skips responses smaller than this many bytes, zero uses 1024
+	MinSize int
+
+	// Types lists the content types to compress, a trailing /* matches a whole type, empty uses common text types
+	Types []string
+}
+
+// compressResponse gzips the response body when the client accepts it and the backend did not encode it already
+func (p *Proxy) compressResponse(res *http.Response) error {
+	if !p.Compression.Enabled || res.Request == nil || res.Request.Method == "HEAD" {
+		return nil
+	}
+
+	if res.StatusCode < 200 || res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+		return nil
+	}
+
+	// ranges are offsets into the identity body, compressing them would corrupt the response
+	if res.StatusCode == http.StatusPartialContent || res.Header.Get("Content-Range") != "" {
+		return nil
+	}
+
+	if res.Header.Get("Content-Encoding") != "" || !acceptsGzip(res.Request.Header.Get("Accept-Encoding")) {
+		return nil
+	}
+
+	if !p.compressibleType(res.Header.Get("Content-Type")) || streamingResponse(res) {
+		return nil
+	}
+
+	min := coalesceInt(p.Compression.MinSize, defaultCompressionMinSize)
+
+	if res.ContentLength >= 0 && res.ContentLength < int64(min) {
+		return nil
+	}
+
+	head := make([]byte, min)
+
+	n, err := io.ReadFull(res.Body, head)
+
+	switch err {
+	case nil:
+	case io.EOF, io.ErrUnexpectedEOF:
+		// the whole body is below the threshold
+		res.Body = readCloser{bytes.NewReader(head[0:n]), res.Body}
+		return nil
+	default:
+		return err
+	}
+
+	body := res.Body
+	pr, pw := io.Pipe()
+
+	go func() {
+		gz := gzip.NewWriter(pw)
+
+		_, err := io.Copy(flushWriter{gz}, io.MultiReader(bytes.NewReader(head), body))
+		if err == nil {
+			err = gz.Close()
+		}
+
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+
+	res.Body = pr
+	res.ContentLength = -1
+	res.Header.Del("Content-Length")
+	res.Header.Set("Content-Encoding", "gzip")
+	res.Header.Add("Vary", "Accept-Encoding")
+
+	// the compressed body is not byte for byte the one the strong etag was computed for
+	if etag := res.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		res.Header.Set("ETag", "W/"+etag)
+	}
+
+	return nil
+}
+
+// flushWriter flushes the gzip stream after every write so chunked responses are not held back
+type flushWriter struct {
+	*gzip.Writer
+}
+
+func (w flushWriter) Write(data []byte) (int, error) {
+	n, err := w.Writer.Write(data)
+	if err != nil {
+		return n, err
+	}
+
+	return n, w.Writer.Flush()
+}
+
+func (p *Proxy) compressibleType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+
+	types := p.Compression.Types
+
+	if len(types) == 0 {
+		types = defaultCompressionTypes
+	}
+
+	for _, t := range types {
+		if t == mt || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+
+		if coding := strings.TrimSpace(fields[0]); coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		q := ""
+
+		for _, f := range fields[1:] {
+			if f = strings.TrimSpace(f); strings.HasPrefix(f, "q=") {
+				q = strings.TrimPrefix(f, "q=")
+			}
+		}
+
+		return q == "" || strings.Trim(q, "0.") != ""
+	}
+
+	return false
+}
+
+// readCloser reads from a replacement reader and closes the original body
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,123 @@
+package router
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyCompression(t *testing.T) {
+	large := strings.Repeat("convox ", 1000)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		}
+
+		if r.URL.Path == "/small" {
+			w.Write([]byte("small"))
+			return
+		}
+
+		w.Write([]byte(large))
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?gzip=true")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://test.convox"+path, nil)
+
+		if accept != "" {
+			req.Header.Set("Accept-Encoding", accept)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		return w
+	}
+
+	w := get("/", "gzip, deflate")
+
+	if assert.Equal(t, "gzip", w.Header().Get("Content-Encoding")) {
+		assert.Equal(t, "", w.Header().Get("Content-Length"))
+		assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+		gz, err := gzip.NewReader(w.Body)
+		if assert.NoError(t, err) {
+			data, err := ioutil.ReadAll(gz)
+			assert.NoError(t, err)
+			assert.Equal(t, large, string(data))
+		}
+	}
+
+	for path, accept := range map[string]string{
+		"/":        "",
+		"/small":   "gzip",
+		"/image":   "gzip",
+		"/encoded": "gzip",
+		"/x":       "gzip;q=0",
+	} {
+		w := get(path, accept)
+		assert.NotEqual(t, "gzip", w.Header().Get("Content-Encoding"), path)
+
+		if path == "/small" {
+			assert.Equal(t, "small", w.Body.String())
+		}
+	}
+}
+
+func TestProxyCompressionOptions(t *testing.T) {
+	p := &Proxy{Compression: CompressionOptions{Enabled: true}}
+
+	assert.True(t, p.compressibleType("text/html; charset=utf-8"))
+	assert.True(t, p.compressibleType("application/json"))
+	assert.False(t, p.compressibleType("image/png"))
+	assert.False(t, p.compressibleType(""))
+
+	p.Compression.Types = []string{"image/*"}
+
+	assert.True(t, p.compressibleType("image/png"))
+	assert.False(t, p.compressibleType("text/html"))
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, accepts := range map[string]bool{
+		"":                     false,
+		"gzip":                 true,
+		"deflate, gzip":        true,
+		"gzip;q=0.5":           true,
+		"gzip;q=0":             false,
+		"gzip; q=0.000":        false,
+		"*":                    true,
+		"br, deflate":          false,
+		"identity, gzip;q=1.0": true,
+	} {
+		assert.Equal(t, accepts, acceptsGzip(header), header)
+	}
+}
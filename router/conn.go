@@ -1,12 +1,15 @@
 package router
 
 import (
+	"io"
 	"net"
+	"os"
+	"sync"
 	"time"
 )
 
-// nopDeadlineConn wraps a net.Conn with Deadline related methods performing a no-op
-// Useful with net.Pipe which doesn't support deadline
+// nopDeadlineConn wraps a net.Conn with Deadline related methods performing a no-op,
+// for streams that must never time out
 type nopDeadlineConn struct {
 	net.Conn
 }
@@ -25,3 +28,151 @@ func (c *nopDeadlineConn) SetReadDeadline(t time.Time) error {
 func (c *nopDeadlineConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
+
+// deadlineConn enforces deadlines on connections that do not support them. Deadlines are
+// passed to the connection when it accepts them, otherwise an expired deadline fails later
+// calls and closes the connection when a call is blocked, as it can not be interrupted otherwise
+type deadlineConn struct {
+	net.Conn
+
+	read  deadline
+	write deadline
+}
+
+func (c *deadlineConn) Read(data []byte) (int, error) {
+	if err := c.read.start(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Read(data)
+
+	return n, c.read.done(err)
+}
+
+func (c *deadlineConn) Write(data []byte) (int, error) {
+	if err := c.write.start(); err != nil {
+		return 0, err
+	}
+
+	n, err := c.Conn.Write(data)
+
+	return n, c.write.done(err)
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return c.SetWriteDeadline(t)
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	if c.Conn.SetReadDeadline(t) == nil {
+		return nil
+	}
+
+	c.read.set(t, c.Conn)
+
+	return nil
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	if c.Conn.SetWriteDeadline(t) == nil {
+		return nil
+	}
+
+	c.write.set(t, c.Conn)
+
+	return nil
+}
+
+// deadline emulates one direction of connection deadlines with a timer
+type deadline struct {
+	expired bool
+	lock    sync.Mutex
+	pending int
+	timer   *time.Timer
+}
+
+func (d *deadline) set(t time.Time, cn net.Conn) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+
+	d.expired = false
+
+	if t.IsZero() {
+		return
+	}
+
+	expire := func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+
+		d.expired = true
+
+		if d.pending > 0 {
+			cn.Close()
+		}
+	}
+
+	if wait := time.Until(t); wait > 0 {
+		d.timer = time.AfterFunc(wait, expire)
+	} else {
+		d.expired = true
+	}
+}
+
+func (d *deadline) start() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.expired {
+		return os.ErrDeadlineExceeded
+	}
+
+	d.pending++
+
+	return nil
+}
+
+func (d *deadline) done(err error) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.pending--
+
+	if err != nil && d.expired {
+		return os.ErrDeadlineExceeded
+	}
+
+	return err
+}
+
+// bufferedConn reads from a reader holding data already consumed from the
+// connection, and optionally reports a different remote address
+type bufferedConn struct {
+	net.Conn
+	io.Reader
+
+	remote net.Addr
+}
+
+// Read reads from the buffered reader
+func (c *bufferedConn) Read(data []byte) (int, error) {
+	return c.Reader.Read(data)
+}
+
+// RemoteAddr returns the overridden remote address if set
+func (c *bufferedConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
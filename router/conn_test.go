@@ -0,0 +1,158 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// noDeadlineConn is a connection that does not support deadlines
+type noDeadlineConn struct {
+	net.Conn
+}
+
+func (c noDeadlineConn) SetReadDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+func (c noDeadlineConn) SetWriteDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+func TestDeadlineConnInterruptsBlockedRead(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	c := &deadlineConn{Conn: noDeadlineConn{a}}
+
+	assert.NoError(t, c.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+
+	start := time.Now()
+
+	_, err := c.Read(make([]byte, 1))
+
+	assert.Equal(t, os.ErrDeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Second)
+
+	if ne, ok := err.(net.Error); assert.True(t, ok) {
+		assert.True(t, ne.Timeout())
+	}
+}
+
+func TestDeadlineConnExpiredBeforeCall(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	c := &deadlineConn{Conn: noDeadlineConn{a}}
+
+	assert.NoError(t, c.SetWriteDeadline(time.Now().Add(10*time.Millisecond)))
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, err := c.Write([]byte("x"))
+	assert.Equal(t, os.ErrDeadlineExceeded, err)
+
+	// clearing the deadline makes the connection usable again as nothing was blocked
+	assert.NoError(t, c.SetWriteDeadline(time.Time{}))
+
+	go b.Read(make([]byte, 1))
+
+	_, err = c.Write([]byte("x"))
+	assert.NoError(t, err)
+}
+
+func TestDeadlineConnUsesNativeDeadlines(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	c := &deadlineConn{Conn: a}
+
+	assert.NoError(t, c.SetReadDeadline(time.Now().Add(20*time.Millisecond)))
+
+	_, err := c.Read(make([]byte, 1))
+	assert.True(t, os.IsTimeout(err))
+
+	// a native timeout leaves the connection open
+	assert.NoError(t, c.SetReadDeadline(time.Time{}))
+
+	go b.Write([]byte("y"))
+
+	buf := make([]byte, 1)
+
+	_, err = c.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "y", string(buf))
+}
+
+func TestNopDeadlineConnIgnoresDeadlines(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	c := &nopDeadlineConn{a}
+
+	assert.NoError(t, c.SetReadDeadline(time.Now().Add(-time.Second)))
+
+	go b.Write([]byte("z"))
+
+	_, err := c.Read(make([]byte, 1))
+	assert.NoError(t, err)
+}
+
+func TestReadProxyHeaderV1Limit(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	// a header that never ends is rejected once it passes the longest allowed line
+	go func() {
+		b.Write([]byte("PROXY TCP4 "))
+
+		for {
+			if _, err := b.Write([]byte("1111111111")); err != nil {
+				return
+			}
+		}
+	}()
+
+	_, err := receiveProxyHeader(a)
+	assert.EqualError(t, err, "invalid proxy protocol v1 header")
+}
+
+func TestWriteProxyHeaderV1Families(t *testing.T) {
+	tests := []struct {
+		src    string
+		dst    string
+		header string
+	}{
+		{"10.0.0.1", "10.0.0.2", "PROXY TCP4 10.0.0.1 10.0.0.2 5000 80\r\n"},
+		{"fd00::1", "fd00::2", "PROXY TCP6 fd00::1 fd00::2 5000 80\r\n"},
+		{"10.0.0.1", "fd00::2", "PROXY TCP6 ::ffff:10.0.0.1 fd00::2 5000 80\r\n"},
+		{"fd00::1", "10.0.0.2", "PROXY TCP6 fd00::1 ::ffff:10.0.0.2 5000 80\r\n"},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+
+		src := &net.TCPAddr{IP: net.ParseIP(tt.src), Port: 5000}
+		dst := &net.TCPAddr{IP: net.ParseIP(tt.dst), Port: 80}
+
+		if !assert.NoError(t, writeProxyHeader(&buf, ProxyProtocolSend, src, dst)) {
+			continue
+		}
+
+		assert.Equal(t, tt.header, buf.String())
+
+		remote, err := readProxyHeaderV1(bufio.NewReader(&buf))
+		if assert.NoError(t, err, tt.header) {
+			assert.True(t, remote.(*net.TCPAddr).IP.Equal(src.IP), tt.header)
+		}
+	}
+}
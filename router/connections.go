@@ -0,0 +1,135 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Connection is an active client connection of a proxy
+type Connection struct {
+	Id       string    `json:"id"`
+	Remote   string    `json:"remote"`
+	Target   string    `json:"target"`
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+}
+
+// connRegistry tracks the client connections accepted by a proxy until they close
+type connRegistry struct {
+	conns map[string]*registeredConn
+	lock  sync.Mutex
+	next  uint64
+}
+
+func (r *connRegistry) add(cn net.Conn) *registeredConn {
+	rc := &registeredConn{
+		Conn:     cn,
+		id:       strconv.FormatUint(atomic.AddUint64(&r.next, 1), 10),
+		registry: r,
+		started:  time.Now(),
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.conns == nil {
+		r.conns = map[string]*registeredConn{}
+	}
+
+	r.conns[rc.id] = rc
+
+	return rc
+}
+
+func (r *connRegistry) remove(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.conns, id)
+}
+
+// registeredConn counts the bytes of a client connection and leaves the registry when closed
+type registeredConn struct {
+	net.Conn
+
+	id       string
+	in       int64
+	out      int64
+	once     sync.Once
+	registry *connRegistry
+	started  time.Time
+}
+
+func (c *registeredConn) Read(data []byte) (int, error) {
+	n, err := c.Conn.Read(data)
+	atomic.AddInt64(&c.in, int64(n))
+	return n, err
+}
+
+func (c *registeredConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+	atomic.AddInt64(&c.out, int64(n))
+	return n, err
+}
+
+func (c *registeredConn) Close() error {
+	c.once.Do(func() { c.registry.remove(c.id) })
+	return c.Conn.Close()
+}
+
+// registeredListener adds accepted connections to the registry of a proxy
+type registeredListener struct {
+	net.Listener
+	registry *connRegistry
+}
+
+func (l registeredListener) Accept() (net.Conn, error) {
+	cn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return l.registry.add(cn), nil
+}
+
+// ActiveConnections lists the open client connections of a tcp or http proxy, oldest first
+func (p *Proxy) ActiveConnections() []Connection {
+	p.registry.lock.Lock()
+	defer p.registry.lock.Unlock()
+
+	cs := []Connection{}
+
+	for _, rc := range p.registry.conns {
+		cs = append(cs, Connection{
+			Id:       rc.id,
+			Remote:   rc.RemoteAddr().String(),
+			Target:   p.Target.String(),
+			Started:  rc.started,
+			BytesIn:  atomic.LoadInt64(&rc.in),
+			BytesOut: atomic.LoadInt64(&rc.out),
+		})
+	}
+
+	sort.Slice(cs, func(i, j int) bool { return cs[i].Started.Before(cs[j].Started) })
+
+	return cs
+}
+
+// CloseConnection closes the client connection with the given id, which also ends its backend connection
+func (p *Proxy) CloseConnection(id string) error {
+	p.registry.lock.Lock()
+	rc, ok := p.registry.conns[id]
+	p.registry.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no such connection: %s", id)
+	}
+
+	return rc.Close()
+}
@@ -0,0 +1,188 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// drainer counts the connections open to each backend and notices when backends leave a service
+// so pooled connections to them are closed while active ones finish
+type drainer struct {
+	active   map[string]*int64
+	closers  map[string][]func()
+	lock     sync.Mutex
+	services map[string]map[string]Backend
+}
+
+func newDrainer() *drainer {
+	return &drainer{
+		active:   map[string]*int64{},
+		closers:  map[string][]func(){},
+		services: map[string]map[string]Backend{},
+	}
+}
+
+// onDrain registers a function closing idle connections to a service when one of its backends leaves
+func (d *drainer) onDrain(app, service string, fn func()) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	key := fmt.Sprintf("%s/%s", app, service)
+
+	d.closers[key] = append(d.closers[key], fn)
+}
+
+// observe records the current backends of a service, drains the ones that disappeared and
+// reports the change to onChange
+func (d *drainer) observe(app, service string, bs []Backend, onChange func(app, service string, added, removed []Backend)) {
+	key := fmt.Sprintf("%s/%s", app, service)
+
+	current := map[string]Backend{}
+
+	for _, be := range bs {
+		current[be.Id] = be
+	}
+
+	d.lock.Lock()
+
+	previous, seen := d.services[key]
+	d.services[key] = current
+
+	added, removed := []Backend{}, []Backend{}
+
+	if seen {
+		for id, be := range current {
+			if _, ok := previous[id]; !ok {
+				added = append(added, be)
+			}
+		}
+
+		for id, be := range previous {
+			if _, ok := current[id]; !ok {
+				removed = append(removed, be)
+			}
+		}
+	}
+
+	closers := d.closers[key]
+
+	d.lock.Unlock()
+
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+
+	if len(removed) > 0 {
+		for _, fn := range closers {
+			fn()
+		}
+
+		for _, be := range removed {
+			logger.Log("drain", Fields{"app": app, "service": service, "process": be.Id, "active": d.connections(be.Id)})
+		}
+	}
+
+	if onChange != nil {
+		go onChange(app, service, added, removed)
+	}
+}
+
+// connections is the number of open connections to a backend
+func (d *drainer) connections(id string) int64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if n, ok := d.active[id]; ok {
+		return atomic.LoadInt64(n)
+	}
+
+	return 0
+}
+
+// track counts cn against its backend until it is closed
+func (d *drainer) track(id string, cn net.Conn) net.Conn {
+	d.lock.Lock()
+
+	n, ok := d.active[id]
+	if !ok {
+		n = new(int64)
+		d.active[id] = n
+	}
+
+	d.lock.Unlock()
+
+	atomic.AddInt64(n, 1)
+
+	return &trackedConn{Conn: cn, done: func() {
+		if atomic.AddInt64(n, -1) > 0 {
+			return
+		}
+
+		d.lock.Lock()
+		defer d.lock.Unlock()
+
+		if atomic.LoadInt64(n) == 0 && d.active[id] == n {
+			delete(d.active, id)
+		}
+	}}
+}
+
+// drainTransport refreshes the backends of a service before each request so pooled connections
+// to processes that left are closed instead of reused. The backends are passed on to dialService
+// so a request that needs a new connection does not resolve them twice
+type drainTransport struct {
+	http.RoundTripper
+
+	app     string
+	proxy   *Proxy
+	service string
+}
+
+type resolvedBackendsKey struct{}
+
+func (t drainTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if bs, err := t.proxy.resolve(t.app, t.service); err == nil {
+		t.proxy.drain.observe(t.app, t.service, bs, t.proxy.OnBackendsChanged)
+		req = req.WithContext(context.WithValue(req.Context(), resolvedBackendsKey{}, bs))
+	}
+
+	return t.RoundTripper.RoundTrip(req)
+}
+
+// resolvedBackends are the backends drainTransport resolved for the request dialing in ctx
+func resolvedBackends(ctx context.Context) ([]Backend, bool) {
+	bs, ok := ctx.Value(resolvedBackendsKey{}).([]Backend)
+
+	return bs, ok && len(bs) > 0
+}
+
+// trackedConn runs done once when the connection is closed
+type trackedConn struct {
+	net.Conn
+
+	done func()
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.done)
+	return c.Conn.Close()
+}
+
+// BackendConnections is the number of open connections to each rack service process
+func (p *Proxy) BackendConnections() map[string]int64 {
+	p.drain.lock.Lock()
+	defer p.drain.lock.Unlock()
+
+	counts := map[string]int64{}
+
+	for id, n := range p.drain.active {
+		counts[id] = atomic.LoadInt64(n)
+	}
+
+	return counts
+}
@@ -0,0 +1,129 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// ErrorPages renders the responses of failed proxy requests for browsers and api clients,
+// nil templates use the defaults. Templates are passed an ErrorPage
+type ErrorPages struct {
+	HTML *htmltemplate.Template
+	JSON *template.Template
+}
+
+// ErrorPage describes a failed request
+type ErrorPage struct {
+	Status    int    `json:"status"`
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+var defaultErrorPageHTML = htmltemplate.Must(htmltemplate.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Status}} {{.Error}}</title></head>
+<body>
+<h1>{{.Status}} {{.Error}}</h1>
+<p>{{.Message}}</p>
+<p><small>request id: {{.RequestID}}</small></p>
+</body>
+</html>
+`))
+
+// renderError answers r with status in the format the client accepts
+func (p *Proxy) renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	page := ErrorPage{
+		Status:    status,
+		Error:     http.StatusText(status),
+		Message:   message,
+		RequestID: ensureRequestID(r.Header),
+	}
+
+	w.Header().Set(RequestIDHeader, page.RequestID)
+
+	accept := r.Header.Get("Accept")
+
+	switch {
+	case strings.Contains(accept, "application/json") || strings.Contains(accept, "+json"):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+
+		if p.ErrorPages.JSON != nil {
+			p.ErrorPages.JSON.Execute(w, page)
+		} else {
+			json.NewEncoder(w).Encode(page)
+		}
+	case strings.Contains(accept, "text/html"):
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+
+		if p.ErrorPages.HTML != nil {
+			p.ErrorPages.HTML.Execute(w, page)
+		} else {
+			defaultErrorPageHTML.Execute(w, page)
+		}
+	default:
+		http.Error(w, message, status)
+	}
+}
+
+// errorPagesDir is the directory of the router the proxy was created on, if any
+func (p *Proxy) errorPagesDir() string {
+	if p.endpoint == nil || p.endpoint.router == nil {
+		return ""
+	}
+
+	return p.endpoint.router.ErrorPagesDir
+}
+
+// readErrorPage reads the template name from dir, names can not leave dir
+func readErrorPage(dir, name string) ([]byte, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("no error pages directory configured")
+	}
+
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return nil, fmt.Errorf("not a file in the error pages directory")
+	}
+
+	return ioutil.ReadFile(filepath.Join(dir, name))
+}
+
+func optionHTMLTemplate(t **htmltemplate.Template, dir, name string) error {
+	data, err := readErrorPage(dir, name)
+	if err != nil {
+		return err
+	}
+
+	tt, err := htmltemplate.New("error").Parse(string(data))
+	if err != nil {
+		return err
+	}
+
+	*t = tt
+
+	return nil
+}
+
+func optionTextTemplate(t **template.Template, dir, name string) error {
+	data, err := readErrorPage(dir, name)
+	if err != nil {
+		return err
+	}
+
+	tt, err := template.New("error").Parse(string(data))
+	if err != nil {
+		return err
+	}
+
+	*t = tt
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// proxy lifecycle event types
+const (
+	EventStart  = "start"
+	EventStop   = "stop"
+	EventAccept = "accept"
+	EventClose  = "close"
+)
+
+const maxPendingEvents = 1000
+
+// Event describes a change in the lifecycle of a proxy or one of its connections
+type Event struct {
+	Type   string
+	Listen string
+	Target string
+	Remote string
+	Time   time.Time
+	Error  error
+}
+
+// EventHandler receives proxy events in order on a background goroutine
+type EventHandler func(Event)
+
+// eventQueue hands events to the handler without blocking the caller, dropping them when the
+// handler falls too far behind
+type eventQueue struct {
+	lock    sync.Mutex
+	pending []Event
+	running bool
+}
+
+func (q *eventQueue) push(h EventHandler, e Event) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.pending) >= maxPendingEvents {
+		return
+	}
+
+	q.pending = append(q.pending, e)
+
+	if !q.running {
+		q.running = true
+		go q.deliver(h)
+	}
+}
+
+func (q *eventQueue) deliver(h EventHandler) {
+	for {
+		q.lock.Lock()
+
+		if len(q.pending) == 0 {
+			q.running = false
+			q.lock.Unlock()
+			return
+		}
+
+		e := q.pending[0]
+		q.pending = q.pending[1:]
+
+		q.lock.Unlock()
+
+		h(e)
+	}
+}
+
+// emit sends an event to the EventHandler if one is set
+func (p *Proxy) emit(typ string, remote net.Addr, err error) {
+	if p.EventHandler == nil {
+		return
+	}
+
+	e := Event{
+		Type:   typ,
+		Listen: p.Listen.String(),
+		Target: p.Target.String(),
+		Time:   time.Now(),
+		Error:  err,
+	}
+
+	if remote != nil {
+		e.Remote = remote.String()
+	}
+
+	p.events.push(p.EventHandler, e)
+}
+
+// connState tracks http connections for metrics and events
+func (p *Proxy) connState(cn net.Conn, state http.ConnState) {
+	p.metrics.connState(cn, state)
+
+	switch state {
+	case http.StateNew:
+		p.emit(EventAccept, cn.RemoteAddr(), nil)
+	case http.StateClosed, http.StateHijacked:
+		p.emit(EventClose, cn.RemoteAddr(), nil)
+	}
+}
@@ -0,0 +1,156 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventQueueOrder(t *testing.T) {
+	var q eventQueue
+
+	received := make(chan string, 100)
+
+	h := func(e Event) {
+		received <- e.Remote
+	}
+
+	for i := 0; i < 100; i++ {
+		q.push(h, Event{Type: EventAccept, Remote: fmt.Sprintf("%d", i)})
+	}
+
+	for i := 0; i < 100; i++ {
+		select {
+		case r := <-received:
+			assert.Equal(t, fmt.Sprintf("%d", i), r)
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not delivered", i)
+		}
+	}
+}
+
+func TestEventQueueFull(t *testing.T) {
+	var q eventQueue
+
+	var lock sync.Mutex
+	delivered := 0
+
+	release := make(chan struct{})
+
+	h := func(e Event) {
+		<-release
+
+		lock.Lock()
+		delivered++
+		lock.Unlock()
+	}
+
+	for i := 0; i < maxPendingEvents+100; i++ {
+		q.push(h, Event{Type: EventAccept})
+	}
+
+	close(release)
+
+	// the handler may already hold the first event when the queue fills up
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		q.lock.Lock()
+		running := q.running
+		q.lock.Unlock()
+
+		if !running {
+			break
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.True(t, delivered >= maxPendingEvents && delivered <= maxPendingEvents+1, "delivered %d events", delivered)
+}
+
+func TestProxySlowEventHandler(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://localhost:3000")
+
+	p := &Proxy{Listen: listen, Target: target, metrics: &metrics{}}
+
+	p.EventHandler = func(e Event) {
+		<-release
+	}
+
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	s.Config.ConnState = p.connState
+	s.Start()
+	defer s.Close()
+
+	client := &http.Client{Timeout: time.Second, Transport: &http.Transport{DisableKeepAlives: true}}
+
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		res, err := client.Get(s.URL)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		res.Body.Close()
+	}
+
+	assert.True(t, time.Since(start) < time.Second, "requests took %s", time.Since(start))
+}
+
+func TestProxyEvents(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse(fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t)))
+	target, _ := url.Parse("tcp://" + backend.Listener.Addr().String())
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	events := make(chan Event, 10)
+
+	p.EventHandler = func(e Event) { events <- e }
+
+	go p.Serve()
+
+	waitListening(t, listen.Host)
+
+	next := func() string {
+		select {
+		case e := <-events:
+			assert.Equal(t, listen.String(), e.Listen)
+			assert.Equal(t, target.String(), e.Target)
+			return e.Type
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for event")
+		}
+		return ""
+	}
+
+	// the probe connection is accepted and closed
+	assert.Equal(t, EventStart, next())
+	assert.Equal(t, EventAccept, next())
+	assert.Equal(t, EventClose, next())
+
+	p.Shutdown(context.Background())
+
+	assert.Equal(t, EventStop, next())
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+)
+
+// X-Forwarded-* header modes
+const (
+	ForwardedAppend  = "append"
+	ForwardedReplace = "replace"
+	ForwardedOff     = "off"
+)
+
+// forwardHeaders adds the router's view of the client to h according to ForwardedHeaders
+func (p *Proxy) forwardHeaders(h http.Header, r *http.Request) {
+	values := map[string]string{
+		"X-Forwarded-For":   r.RemoteAddr,
+		"X-Forwarded-Port":  p.listenPort(),
+		"X-Forwarded-Proto": p.Listen.Scheme,
+	}
+
+	switch p.ForwardedHeaders {
+	case ForwardedOff:
+		return
+	case ForwardedReplace:
+		for k, v := range values {
+			h.Set(k, v)
+		}
+	default:
+		for k, v := range values {
+			h.Add(k, v)
+		}
+	}
+
+	h.Set("X-Forwarded-Host", r.Host)
+}
+
+// forwardedHeaderKeys are removed by the reverse proxy before Rewrite runs
+var forwardedHeaderKeys = []string{"Forwarded", "X-Forwarded-For", "X-Forwarded-Host", "X-Forwarded-Proto"}
+
+// rewriter runs director on the outgoing request with the forwarded headers of the client
+// restored, leaving X-Forwarded-For to forwardedDirector instead of the reverse proxy
+func (p *Proxy) rewriter(director func(r *http.Request)) func(pr *httputil.ProxyRequest) {
+	return func(pr *httputil.ProxyRequest) {
+		for _, k := range forwardedHeaderKeys {
+			if v, ok := pr.In.Header[k]; ok {
+				pr.Out.Header[k] = append([]string{}, v...)
+			}
+		}
+
+		director(pr.Out)
+	}
+}
+
+// forwardedDirector adds the client ip to X-Forwarded-For, replaces the header with it or leaves
+// the header of the client untouched according to ForwardedHeaders
+func (p *Proxy) forwardedDirector(r *http.Request) {
+	if p.ForwardedHeaders == ForwardedOff {
+		return
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return
+	}
+
+	if prior := r.Header["X-Forwarded-For"]; len(prior) > 0 && p.ForwardedHeaders != ForwardedReplace {
+		ip = strings.Join(prior, ", ") + ", " + ip
+	}
+
+	r.Header.Set("X-Forwarded-For", ip)
+}
+
+func validForwardedHeaders(mode string) error {
+	switch mode {
+	case "", ForwardedAppend, ForwardedReplace, ForwardedOff:
+		return nil
+	}
+
+	return fmt.Errorf("unknown forwarded headers mode: %s", mode)
+}
@@ -0,0 +1,128 @@
+package router
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2"
+)
+
+// the part of the http2 client preface left unread after net/http parses "PRI * HTTP/2.0"
+const h2cPrefaceTail = "SM\r\n\r\n"
+
+// h2cHandler serves HTTP/2 over cleartext to clients that open with the
+// client preface (prior knowledge) and hands everything else to h. The
+// HTTP/1.1 Upgrade: h2c handshake is not supported, those requests are
+// answered over HTTP/1.1 as RFC 7540 allows
+func h2cHandler(h http.Handler) http.Handler {
+	s := &http2.Server{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PRI" || r.URL.Path != "*" || r.Proto != "HTTP/2.0" {
+			if strings.EqualFold(r.Header.Get("Upgrade"), "h2c") {
+				r.Header.Del("Upgrade")
+				r.Header.Del("Http2-Settings")
+			}
+
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "h2c not supported", http.StatusInternalServerError)
+			return
+		}
+
+		cn, rw, err := hj.Hijack()
+		if err != nil {
+			logger.Log("proxy", Fields{"type": "h2c.hijack", "error": err})
+			return
+		}
+
+		buf := make([]byte, len(h2cPrefaceTail))
+
+		if _, err := io.ReadFull(rw, buf); err != nil || string(buf) != h2cPrefaceTail {
+			logger.Log("proxy", Fields{"type": "h2c.preface", "error": "invalid client preface"})
+			cn.Close()
+			return
+		}
+
+		pc := &bufferedConn{
+			Conn:   cn,
+			Reader: io.MultiReader(strings.NewReader(http2.ClientPreface), rw),
+		}
+
+		s.ServeConn(pc, &http2.ServeConnOpts{Handler: h})
+	})
+}
+
+// h2cTransport speaks HTTP/2 over cleartext to the backend using the dialer from tr
+func h2cTransport(tr *http.Transport) http.RoundTripper {
+	t := &http2.Transport{AllowHTTP: true}
+
+	t.ConnPool = &h2cConnPool{conns: map[string][]*http2.ClientConn{}, dial: tr.DialContext, transport: t}
+
+	return t
+}
+
+// h2cConnPool shares HTTP/2 connections to the backend between requests. New connections are
+// dialed with the context of the request that needs them, so its values and cancellation apply
+type h2cConnPool struct {
+	conns     map[string][]*http2.ClientConn
+	dial      func(ctx context.Context, network, address string) (net.Conn, error)
+	lock      sync.Mutex
+	transport *http2.Transport
+}
+
+func (p *h2cConnPool) GetClientConn(req *http.Request, addr string) (*http2.ClientConn, error) {
+	p.lock.Lock()
+
+	for _, cc := range p.conns[addr] {
+		if cc.CanTakeNewRequest() {
+			p.lock.Unlock()
+			return cc, nil
+		}
+	}
+
+	p.lock.Unlock()
+
+	cn, err := p.dial(req.Context(), "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	cc, err := p.transport.NewClientConn(cn)
+	if err != nil {
+		cn.Close()
+		return nil, err
+	}
+
+	p.lock.Lock()
+	p.conns[addr] = append(p.conns[addr], cc)
+	p.lock.Unlock()
+
+	return cc, nil
+}
+
+func (p *h2cConnPool) MarkDead(cc *http2.ClientConn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for addr, ccs := range p.conns {
+		for i, c := range ccs {
+			if c == cc {
+				p.conns[addr] = append(ccs[:i:i], ccs[i+1:]...)
+				break
+			}
+		}
+
+		if len(p.conns[addr]) == 0 {
+			delete(p.conns, addr)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/http2"
+)
+
+func TestProxyH2C(t *testing.T) {
+	backend := httptest.NewServer(h2cHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?h2c=true")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, p.H2C)
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	frontend := httptest.NewServer(h2cHandler(h))
+	defer frontend.Close()
+
+	h2 := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, address string, cfg *tls.Config) (net.Conn, error) {
+			return net.Dial(network, address)
+		},
+	}}
+
+	// clients with prior knowledge and plain http/1.1 clients both reach the backend over h2c
+	for _, c := range []*http.Client{h2, http.DefaultClient} {
+		res, err := c.Get(frontend.URL)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		assert.Equal(t, "HTTP/2.0", string(data))
+	}
+
+	res, err := h2.Get(frontend.URL)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "HTTP/2.0", res.Proto)
+		res.Body.Close()
+	}
+
+	// the upgrade handshake is not supported, the request is answered over http/1.1
+	req, _ := http.NewRequest("GET", frontend.URL, nil)
+	req.Header.Set("Connection", "Upgrade, HTTP2-Settings")
+	req.Header.Set("Upgrade", "h2c")
+	req.Header.Set("HTTP2-Settings", "AAMAAABkAARAAAAAAAIAAAAA")
+
+	res, err = http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "HTTP/1.1", res.Proto)
+		assert.Equal(t, "HTTP/2.0", string(data))
+	}
+
+	_, err = e.NewProxy(e.Host, listen, &url.URL{Scheme: "http", Host: "localhost:5000", RawQuery: "h2c=maybe"})
+	assert.EqualError(t, err, `invalid h2c option "maybe": strconv.ParseBool: parsing "maybe": invalid syntax`)
+}
+
+func TestH2CHandlerInvalidPreface(t *testing.T) {
+	l := &testLogger{}
+	SetLogger(l)
+	defer SetLogger(NewLogfmtLogger(ioutil.Discard))
+
+	s := httptest.NewServer(h2cHandler(http.NotFoundHandler()))
+	defer s.Close()
+
+	cn, err := net.Dial("tcp", s.Listener.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cn.Close()
+
+	fmt.Fprint(cn, "PRI * HTTP/2.0\r\n\r\nXX\r\n\r\n")
+
+	// the connection is closed without a response
+	data, _ := ioutil.ReadAll(cn)
+	assert.Empty(t, data)
+
+	assert.Equal(t, []Fields{{"at": "proxy", "type": "h2c.preface", "error": "invalid client preface"}}, l.Events())
+}
+
+type h2cTestKey struct{}
+
+func TestH2CTransportDialContext(t *testing.T) {
+	backend := httptest.NewServer(h2cHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Proto)
+	})))
+	defer backend.Close()
+
+	var lock sync.Mutex
+	dials := []interface{}{}
+
+	tr := &http.Transport{}
+
+	tr.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		lock.Lock()
+		dials = append(dials, ctx.Value(h2cTestKey{}))
+		lock.Unlock()
+
+		return (&net.Dialer{}).DialContext(ctx, network, address)
+	}
+
+	rt := h2cTransport(tr)
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", backend.URL, nil)
+		req = req.WithContext(context.WithValue(req.Context(), h2cTestKey{}, i))
+
+		res, err := rt.RoundTrip(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		assert.Equal(t, "HTTP/2.0", string(data))
+	}
+
+	// the connection dialed for the first request is shared by the others
+	lock.Lock()
+	assert.Equal(t, []interface{}{0}, dials)
+	lock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req, _ := http.NewRequest("GET", "http://127.0.0.1:1", nil)
+
+	_, err := rt.RoundTrip(req.WithContext(ctx))
+	assert.True(t, errors.Is(err, context.Canceled), "unexpected error: %v", err)
+
+	lock.Lock()
+	assert.Equal(t, []interface{}{0, nil}, dials)
+	lock.Unlock()
+}
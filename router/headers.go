@@ -0,0 +1,53 @@
+package router
+
+import "net/http"
+
+// SecurityHeaders are added to http responses that do not already carry them, a value of "off"
+// leaves a header out
+type SecurityHeaders struct {
+	// Enabled turns header injection on, unset values use the defaults
+	Enabled bool
+
+	// StrictTransportSecurity is only sent from https listeners
+	StrictTransportSecurity string
+
+	ContentSecurityPolicy string
+	ContentTypeOptions    string
+	FrameOptions          string
+	ReferrerPolicy        string
+}
+
+var defaultSecurityHeaders = SecurityHeaders{
+	StrictTransportSecurity: "max-age=31536000; includeSubDomains",
+	ContentTypeOptions:      "nosniff",
+	FrameOptions:            "SAMEORIGIN",
+	ReferrerPolicy:          "strict-origin-when-cross-origin",
+}
+
+// securityHeaders adds the configured security headers the backend did not set
+func (p *Proxy) securityHeaders(res *http.Response) error {
+	if !p.SecurityHeaders.Enabled {
+		return nil
+	}
+
+	h := p.SecurityHeaders
+
+	headers := map[string]string{
+		"Content-Security-Policy": h.ContentSecurityPolicy,
+		"X-Content-Type-Options":  coalesceString(h.ContentTypeOptions, defaultSecurityHeaders.ContentTypeOptions),
+		"X-Frame-Options":         coalesceString(h.FrameOptions, defaultSecurityHeaders.FrameOptions),
+		"Referrer-Policy":         coalesceString(h.ReferrerPolicy, defaultSecurityHeaders.ReferrerPolicy),
+	}
+
+	if p.Listen.Scheme == "https" {
+		headers["Strict-Transport-Security"] = coalesceString(h.StrictTransportSecurity, defaultSecurityHeaders.StrictTransportSecurity)
+	}
+
+	for k, v := range headers {
+		if v != "" && v != "off" && res.Header.Get(k) == "" {
+			res.Header.Set(k, v)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultHealthCooldown = 10 * time.Second
+
+// healthChecker passively tracks backends that failed to connect and skips them until a cooldown passes
+type healthChecker struct {
+	cooldown time.Duration
+	failed   map[string]time.Time
+	lock     sync.Mutex
+}
+
+func newHealthChecker(cooldown time.Duration) *healthChecker {
+	return &healthChecker{
+		cooldown: coalesceDuration(cooldown, defaultHealthCooldown),
+		failed:   map[string]time.Time{},
+	}
+}
+
+// Fail marks a backend unhealthy for the cooldown window
+func (h *healthChecker) Fail(id string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.failed[id] = time.Now()
+}
+
+// Healthy returns false while a backend is cooling down after a failure
+func (h *healthChecker) Healthy(id string) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	t, ok := h.failed[id]
+	if !ok {
+		return true
+	}
+
+	if time.Since(t) > h.cooldown {
+		delete(h.failed, id)
+		return true
+	}
+
+	return false
+}
+
+// Filter removes unhealthy backends, if none are healthy all are returned so a recovering service is still tried
+func (h *healthChecker) Filter(bs []Backend) []Backend {
+	healthy := []Backend{}
+
+	for _, be := range bs {
+		if h.Healthy(be.Id) {
+			healthy = append(healthy, be)
+		}
+	}
+
+	if len(healthy) == 0 {
+		return bs
+	}
+
+	return healthy
+}
+
+const (
+	defaultHealthCheckTimeout = 2 * time.Second
+	maxHealthCheckBackoff     = 30 * time.Second
+)
+
+// HealthCheck actively dials single host targets so connections to a target known to be down fail fast,
+// a zero interval disables it
+type HealthCheck struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// Healthy returns false while active health checks find the target down
+func (p *Proxy) Healthy() bool {
+	return atomic.LoadInt32(&p.down) == 0
+}
+
+func (p *Proxy) activeHealthCheck() bool {
+	return p.HealthCheck.Interval > 0 && p.Target.Hostname() != "rack" && p.Target.Scheme != "udp"
+}
+
+// checkHealth dials the target every interval until done is closed, backing off while it is down
+func (p *Proxy) checkHealth(done chan struct{}) {
+	interval := p.HealthCheck.Interval
+	backoff := maxHealthCheckBackoff
+
+	if interval > backoff {
+		backoff = interval
+	}
+
+	wait := time.Duration(0)
+
+	for {
+		t := time.NewTimer(wait)
+
+		select {
+		case <-done:
+			t.Stop()
+			return
+		case <-t.C:
+		}
+
+		network, address := targetAddress(p.Target)
+
+		cn, err := net.DialTimeout(network, address, coalesceDuration(p.HealthCheck.Timeout, defaultHealthCheckTimeout))
+		if err != nil {
+			if atomic.SwapInt32(&p.down, 1) == 0 {
+				logger.Log("health", Fields{"target": address, "state": "down", "error": err})
+			}
+
+			wait = 2 * wait
+
+			switch {
+			case wait < interval:
+				wait = interval
+			case wait > backoff:
+				wait = backoff
+			}
+
+			continue
+		}
+
+		cn.Close()
+
+		if atomic.SwapInt32(&p.down, 0) == 1 {
+			logger.Log("health", Fields{"target": address, "state": "up"})
+		}
+
+		wait = interval
+	}
+}
@@ -0,0 +1,59 @@
+package router
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/convox/praxis/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCheckerCooldown(t *testing.T) {
+	h := newHealthChecker(50 * time.Millisecond)
+
+	pss := []Backend{{Id: "web-1"}, {Id: "web-2"}}
+
+	h.Fail("web-1")
+
+	assert.False(t, h.Healthy("web-1"))
+	assert.True(t, h.Healthy("web-2"))
+	assert.Equal(t, []Backend{{Id: "web-2"}}, h.Filter(pss))
+
+	// every process failing leaves them all in rotation
+	h.Fail("web-2")
+	assert.Equal(t, pss, h.Filter(pss))
+
+	time.Sleep(100 * time.Millisecond)
+
+	assert.True(t, h.Healthy("web-1"))
+	assert.Equal(t, pss, h.Filter(pss))
+
+	assert.Equal(t, defaultHealthCooldown, newHealthChecker(0).cooldown)
+}
+
+func TestProxyDialServiceFailure(t *testing.T) {
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}})
+	defer cleanup()
+
+	ra.fail("web-1")
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("https://0.0.0.0:443")
+	target, _ := url.Parse("https://rack/app/service/web:3000?health_cooldown=1m")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, time.Minute, p.HealthCooldown)
+
+	_, err = p.dialService(context.Background(), "app", "web", 3000)
+	assert.IsType(t, dialError{}, err)
+
+	assert.False(t, p.health.Healthy("web-1"))
+	assert.Equal(t, []Backend{{Id: "web-2"}}, p.health.Filter([]Backend{{Id: "web-1"}, {Id: "web-2"}}))
+}
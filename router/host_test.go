@@ -0,0 +1,79 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyRackHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Host, r.Header.Get("X-Forwarded-Host"))
+	}))
+	defer backend.Close()
+
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}})
+	defer cleanup()
+
+	ra.backend = backend.Listener.Addr().String()
+
+	for opts, expected := range map[string]string{
+		"":                   "test.convox www.example.org",
+		"preserve_host=true": "www.example.org www.example.org",
+	} {
+		_, h := rackServiceHandler(t, opts)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://www.example.org/", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code, opts)
+		assert.Equal(t, expected, w.Body.String(), opts)
+	}
+}
+
+func TestProxyWebsocketHost(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		c.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf("%s %s", r.Host, r.Header.Get("X-Forwarded-Host"))))
+		c.ReadMessage()
+	}))
+	defer backend.Close()
+
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}})
+	defer cleanup()
+
+	ra.backend = backend.Listener.Addr().String()
+
+	for opts, expected := range map[string]string{
+		"":                   "test.convox",
+		"preserve_host=true": "www.example.org",
+	} {
+		_, h := rackServiceHandler(t, opts)
+
+		frontend := httptest.NewServer(h)
+
+		headers := http.Header{"Host": {"www.example.org"}}
+
+		c, _, err := websocket.DefaultDialer.Dial(strings.Replace(frontend.URL, "http", "ws", 1), headers)
+		if assert.NoError(t, err, opts) {
+			_, data, err := c.ReadMessage()
+			assert.NoError(t, err, opts)
+			assert.Equal(t, expected+" www.example.org", string(data), opts)
+
+			c.Close()
+		}
+
+		frontend.Close()
+	}
+}
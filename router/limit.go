@@ -0,0 +1,41 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// acquire reserves one of MaxConnections, it always succeeds when there is no limit
+func (p *Proxy) acquire() bool {
+	if atomic.AddInt64(&p.inflight, 1) > int64(p.MaxConnections) && p.MaxConnections > 0 {
+		atomic.AddInt64(&p.inflight, -1)
+		return false
+	}
+
+	return true
+}
+
+func (p *Proxy) release() {
+	atomic.AddInt64(&p.inflight, -1)
+}
+
+// limitConnections rejects requests with a 503 while MaxConnections requests are in flight
+func (p *Proxy) limitConnections(h http.Handler) http.Handler {
+	if p.MaxConnections <= 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.acquire() {
+			p.metrics.error(fmt.Errorf("connection limit reached"))
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many connections", http.StatusServiceUnavailable)
+			return
+		}
+
+		defer p.release()
+
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,162 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// waitInflight blocks until p has n connections in flight
+func waitInflight(t *testing.T, p *Proxy, n int64) {
+	for i := 0; i < 100; i++ {
+		if atomic.LoadInt64(&p.inflight) == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected %d connections in flight, got %d", n, atomic.LoadInt64(&p.inflight))
+}
+
+func TestProxyMaxConnectionsHTTP(t *testing.T) {
+	release := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/hold" {
+			<-release
+		}
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?max_connections=2")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 2, p.MaxConnections)
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://test.convox"+path, nil))
+		return w
+	}
+
+	held := make(chan int, 2)
+
+	for i := 0; i < 2; i++ {
+		go func() { held <- get("/hold").Code }()
+	}
+
+	waitInflight(t, p, 2)
+
+	// the request over the limit is rejected while the others are in flight
+	w := get("/")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, int64(1), p.Metrics().Errors)
+
+	close(release)
+
+	assert.Equal(t, http.StatusOK, <-held)
+	assert.Equal(t, http.StatusOK, <-held)
+
+	// released connections admit new requests
+	assert.Equal(t, http.StatusOK, get("/").Code)
+}
+
+func TestProxyMaxConnectionsTCP(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	accepted := make(chan net.Conn, 10)
+
+	go func() {
+		for {
+			cn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- cn
+		}
+	}()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse(fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t)))
+	target, _ := url.Parse(fmt.Sprintf("tcp://%s?max_connections=1", backend.Addr()))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go p.Serve()
+	defer p.Shutdown(context.Background())
+
+	waitListening(t, listen.Host)
+	(<-accepted).Close()
+	waitInflight(t, p, 0)
+
+	c1, err := net.Dial("tcp", listen.Host)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	b1 := <-accepted
+	waitInflight(t, p, 1)
+
+	// the connection over the limit is closed without reaching the backend
+	c2, err := net.Dial("tcp", listen.Host)
+	if assert.NoError(t, err) {
+		c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+		_, err := c2.Read(make([]byte, 1))
+		assert.Error(t, err)
+
+		c2.Close()
+	}
+
+	select {
+	case <-accepted:
+		t.Fatal("connection over the limit reached the backend")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c1.Close()
+	b1.Close()
+	waitInflight(t, p, 0)
+
+	c3, err := net.Dial("tcp", listen.Host)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c3.Close()
+
+	select {
+	case b3 := <-accepted:
+		b3.Close()
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection was not admitted after a release")
+	}
+}
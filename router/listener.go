@@ -0,0 +1,158 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+// NewProxyWithListener creates a proxy that serves on ln instead of listening itself, so a
+// new router process can take over the sockets of the one it replaces without dropping clients
+func (e *Endpoint) NewProxyWithListener(host string, listen, target *url.URL, ln net.Listener) (*Proxy, error) {
+	if listen.Scheme == "udp" {
+		return nil, fmt.Errorf("udp proxies can not use a stream listener")
+	}
+
+	if ln == nil {
+		return nil, fmt.Errorf("listener required")
+	}
+
+	p, err := e.NewProxy(host, listen, target)
+	if err != nil {
+		return nil, err
+	}
+
+	p.inherited = ln
+
+	return p, nil
+}
+
+// FileListener returns the listener for a socket file descriptor inherited from a parent process
+func FileListener(fd uintptr, name string) (net.Listener, error) {
+	f := os.NewFile(fd, name)
+	if f == nil {
+		return nil, fmt.Errorf("invalid file descriptor: %d", fd)
+	}
+
+	// the listener holds its own duplicate of the descriptor
+	defer f.Close()
+
+	return net.FileListener(f)
+}
+
+// listen returns the inherited listener of the proxy or a new one on its listen address
+func (p *Proxy) listen() (net.Listener, error) {
+	if p.inherited != nil {
+		return p.inherited, nil
+	}
+
+	return net.Listen("tcp", p.listenAddress())
+}
+
+// Addr returns the address the proxy is bound to, nil until it is listening. Proxies listening on
+// port 0 are given a free port by the system, wait on Ready to read it
+func (p *Proxy) Addr() net.Addr {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	switch {
+	case p.listener != nil:
+		return p.listener.Addr()
+	case p.packet != nil:
+		return p.packet.LocalAddr()
+	}
+
+	return nil
+}
+
+// Ready is closed once the proxy is listening, or when Serve returns without listening in which
+// case Addr is nil and Err reports why
+func (p *Proxy) Ready() <-chan struct{} {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.readyChannel()
+}
+
+// readyChannel must be called with the lock held
+func (p *Proxy) readyChannel() chan struct{} {
+	if p.ready == nil {
+		p.ready = make(chan struct{})
+	}
+
+	return p.ready
+}
+
+// listening marks the proxy ready, it must be called with the lock held
+func (p *Proxy) listening() {
+	ready := p.readyChannel()
+
+	select {
+	case <-ready:
+	default:
+		close(ready)
+	}
+}
+
+// Err returns the error Serve stopped with, nil while it is running or after a clean shutdown
+func (p *Proxy) Err() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.serveErr
+}
+
+// stopped records the result of Serve and releases anyone still waiting on Ready
+func (p *Proxy) stopped(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.serveErr = err
+	p.listening()
+}
+
+// register tracks a proxy listening on port 0 under the port it was given, so the router can
+// shut it down and report on it like any other proxy
+func (p *Proxy) register(addr net.Addr) {
+	if p.Listen.Port() != "0" || p.endpoint == nil {
+		return
+	}
+
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return
+	}
+
+	pi, err := strconv.Atoi(port)
+	if err != nil {
+		return
+	}
+
+	e := p.endpoint
+
+	if e.router != nil {
+		e.router.lock.Lock()
+		defer e.router.lock.Unlock()
+	}
+
+	if e.Proxies == nil {
+		e.Proxies = map[int]*Proxy{}
+	}
+
+	e.Proxies[pi] = p
+}
+
+// listenPort is the port the proxy is bound to, or the configured one before it listens
+func (p *Proxy) listenPort() string {
+	if p.Listen.Port() == "0" {
+		if a := p.Addr(); a != nil {
+			if _, port, err := net.SplitHostPort(a.String()); err == nil {
+				return port
+			}
+		}
+	}
+
+	return p.Listen.Port()
+}
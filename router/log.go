@@ -1,22 +1,184 @@
 package router
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/convox/praxis/sdk/rack"
 )
 
+// Fields are the structured values attached to a log event
+type Fields map[string]interface{}
+
+// Logger receives structured log events from the router
+type Logger interface {
+	Log(at string, fields Fields)
+}
+
+var logger Logger = NewLogfmtLogger(os.Stderr)
+
+// SetLogger replaces the logger used for router events
+func SetLogger(l Logger) {
+	logger = l
+}
+
+type logfmtLogger struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewLogfmtLogger writes events as "ns=convox.router at=event key=value" lines
+func NewLogfmtLogger(w io.Writer) Logger {
+	return &logfmtLogger{w: w}
+}
+
+func (l *logfmtLogger) Log(at string, fields Fields) {
+	keys := make([]string, 0, len(fields))
+
+	for k := range fields {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	parts := []string{"ns=convox.router", fmt.Sprintf("at=%s", at)}
+
+	for _, k := range keys {
+		switch v := fields[k].(type) {
+		case string, error:
+			parts = append(parts, fmt.Sprintf("%s=%q", k, v))
+		default:
+			parts = append(parts, fmt.Sprintf("%s=%v", k, v))
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	fmt.Fprintln(l.w, strings.Join(parts, " "))
+}
+
+type jsonLogger struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+// NewJSONLogger writes events as one json object per line
+func NewJSONLogger(w io.Writer) Logger {
+	return &jsonLogger{w: w}
+}
+
+func (l *jsonLogger) Log(at string, fields Fields) {
+	e := map[string]interface{}{
+		"ns": "convox.router",
+		"at": at,
+	}
+
+	for k, v := range fields {
+		switch t := v.(type) {
+		case error:
+			e[k] = t.Error()
+		case time.Duration:
+			e[k] = t.Seconds()
+		default:
+			e[k] = t
+		}
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.w.Write(append(data, '\n'))
+}
+
 type logTransport struct {
 	http.RoundTripper
-	rack rack.Rack
+	metrics *metrics
+	rack    rack.Rack
 }
 
 func (t logTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	fmt.Printf("ns=convox.router at=proxy type=http target=%q\n", req.URL)
+	start := time.Now()
+
+	t.metrics.request()
+
+	fields := Fields{
+		"method": req.Method,
+		"host":   req.Host,
+		"path":   req.URL.Path,
+	}
+
+	if id := req.Header.Get(RequestIDHeader); id != "" {
+		fields["request_id"] = id
+	}
+
+	res, err := t.RoundTripper.RoundTrip(req)
+	if err != nil {
+		t.metrics.error(err)
+
+		fields["status"] = "error"
+		fields["error"] = err
+		fields["duration"] = time.Since(start)
+		logger.Log("proxy", fields)
+		return nil, err
+	}
+
+	fields["status"] = res.StatusCode
+
+	if res.StatusCode < 500 {
+		t.metrics.success()
+	}
+
+	// upgraded connections need the writable body left intact
+	if res.StatusCode == http.StatusSwitchingProtocols {
+		fields["duration"] = time.Since(start)
+		logger.Log("proxy", fields)
+		return res, nil
+	}
+
+	res.Body = &accessLogBody{ReadCloser: res.Body, fields: fields, start: start}
+
+	return res, nil
+}
+
+// accessLogBody counts response bytes and logs the request once the body is closed
+type accessLogBody struct {
+	io.ReadCloser
+
+	fields Fields
+	once   sync.Once
+	size   int64
+	start  time.Time
+}
+
+func (b *accessLogBody) Read(data []byte) (int, error) {
+	n, err := b.ReadCloser.Read(data)
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *accessLogBody) Close() error {
+	err := b.ReadCloser.Close()
+
+	b.once.Do(func() {
+		b.fields["size"] = b.size
+		b.fields["duration"] = time.Since(b.start)
+		logger.Log("proxy", b.fields)
+	})
 
-	return t.RoundTripper.RoundTrip(req)
+	return err
 }
 
 func logError(err error) {
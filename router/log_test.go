@@ -0,0 +1,107 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testLogger struct {
+	events []Fields
+	lock   sync.Mutex
+}
+
+func (l *testLogger) Log(at string, fields Fields) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	f := Fields{"at": at}
+
+	for k, v := range fields {
+		f[k] = v
+	}
+
+	l.events = append(l.events, f)
+}
+
+func (l *testLogger) Events() []Fields {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return append([]Fields{}, l.events...)
+}
+
+func TestLogfmtLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	NewLogfmtLogger(&buf).Log("proxy", Fields{"status": 200, "path": "/a b", "error": fmt.Errorf("boom")})
+
+	assert.Equal(t, "ns=convox.router at=proxy error=\"boom\" path=\"/a b\" status=200\n", buf.String())
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+
+	NewJSONLogger(&buf).Log("proxy", Fields{"status": 200, "duration": 1500 * time.Millisecond, "error": fmt.Errorf("boom")})
+
+	var e map[string]interface{}
+
+	if assert.NoError(t, json.Unmarshal(buf.Bytes(), &e)) {
+		assert.Equal(t, map[string]interface{}{"ns": "convox.router", "at": "proxy", "status": 200.0, "duration": 1.5, "error": "boom"}, e)
+	}
+}
+
+func TestProxyAccessLog(t *testing.T) {
+	l := &testLogger{}
+
+	SetLogger(l)
+	defer SetLogger(NewLogfmtLogger(ioutil.Discard))
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "hello")
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "http://test.convox/path", nil))
+
+	assert.Equal(t, "hello", w.Body.String())
+
+	events := l.Events()
+
+	if assert.Len(t, events, 1) {
+		ev := events[0]
+		assert.Equal(t, "proxy", ev["at"])
+		assert.Equal(t, "POST", ev["method"])
+		assert.Equal(t, "test.convox", ev["host"])
+		assert.Equal(t, "/path", ev["path"])
+		assert.Equal(t, http.StatusCreated, ev["status"])
+		assert.Equal(t, int64(5), ev["size"])
+		assert.IsType(t, time.Duration(0), ev["duration"])
+	}
+}
@@ -0,0 +1,169 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics is a snapshot of the traffic counters for a proxy
+type Metrics struct {
+	ActiveConnections int64 `json:"active_connections"`
+	Requests          int64 `json:"requests"`
+	BytesIn           int64 `json:"bytes_in"`
+	BytesOut          int64 `json:"bytes_out"`
+	Errors            int64 `json:"errors"`
+}
+
+// ProxyMetrics identifies the proxy a metrics snapshot belongs to
+type ProxyMetrics struct {
+	Host      string      `json:"host"`
+	Listen    string      `json:"listen"`
+	Scheme    string      `json:"scheme"`
+	Target    string      `json:"target"`
+	Metrics   Metrics     `json:"metrics"`
+	LastError *ProxyError `json:"last_error,omitempty"`
+}
+
+// ProxyError is the most recent failure of a proxy
+type ProxyError struct {
+	Error string    `json:"error"`
+	Time  time.Time `json:"time"`
+}
+
+type metrics struct {
+	active   int64
+	requests int64
+	bytesIn  int64
+	bytesOut int64
+	errors   int64
+
+	lastError *ProxyError
+	lock      sync.Mutex
+}
+
+func (m *metrics) snapshot() Metrics {
+	return Metrics{
+		ActiveConnections: atomic.LoadInt64(&m.active),
+		Requests:          atomic.LoadInt64(&m.requests),
+		BytesIn:           atomic.LoadInt64(&m.bytesIn),
+		BytesOut:          atomic.LoadInt64(&m.bytesOut),
+		Errors:            atomic.LoadInt64(&m.errors),
+	}
+}
+
+func (m *metrics) connOpen()  { atomic.AddInt64(&m.active, 1) }
+func (m *metrics) connClose() { atomic.AddInt64(&m.active, -1) }
+func (m *metrics) request()   { atomic.AddInt64(&m.requests, 1) }
+
+// error counts a failure and remembers it as the last error
+func (m *metrics) error(err error) {
+	atomic.AddInt64(&m.errors, 1)
+
+	if err == nil {
+		return
+	}
+
+	m.lock.Lock()
+	m.lastError = &ProxyError{Error: err.Error(), Time: time.Now()}
+	m.lock.Unlock()
+}
+
+// success clears the last error
+func (m *metrics) success() {
+	m.lock.Lock()
+	m.lastError = nil
+	m.lock.Unlock()
+}
+
+// connState tracks active http connections, hijacked connections are counted by their handler
+func (m *metrics) connState(cn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.connOpen()
+	case http.StateClosed, http.StateHijacked:
+		m.connClose()
+	}
+}
+
+// Metrics returns a snapshot of the traffic counters for this proxy
+func (p *Proxy) Metrics() Metrics {
+	return p.metrics.snapshot()
+}
+
+// LastError returns the most recent failure of this proxy, nil once a request succeeded since
+func (p *Proxy) LastError() *ProxyError {
+	p.metrics.lock.Lock()
+	defer p.metrics.lock.Unlock()
+
+	if p.metrics.lastError == nil {
+		return nil
+	}
+
+	pe := *p.metrics.lastError
+
+	return &pe
+}
+
+// Metrics returns a snapshot for every proxy on the router
+func (r *Router) Metrics() []ProxyMetrics {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	pms := []ProxyMetrics{}
+
+	for host, e := range r.endpoints {
+		for _, p := range e.Proxies {
+			pms = append(pms, ProxyMetrics{
+				Host:      host,
+				Listen:    p.Listen.String(),
+				Scheme:    p.Listen.Scheme,
+				Target:    p.Target.String(),
+				Metrics:   p.Metrics(),
+				LastError: p.LastError(),
+			})
+		}
+	}
+
+	sort.Slice(pms, func(i, j int) bool {
+		return fmt.Sprintf("%s %s", pms[i].Host, pms[i].Listen) < fmt.Sprintf("%s %s", pms[j].Host, pms[j].Listen)
+	})
+
+	return pms
+}
+
+// meteredListener counts the bytes moving through accepted connections
+type meteredListener struct {
+	net.Listener
+	metrics *metrics
+}
+
+func (l meteredListener) Accept() (net.Conn, error) {
+	cn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return meteredConn{Conn: cn, metrics: l.metrics}, nil
+}
+
+type meteredConn struct {
+	net.Conn
+	metrics *metrics
+}
+
+func (c meteredConn) Read(data []byte) (int, error) {
+	n, err := c.Conn.Read(data)
+	atomic.AddInt64(&c.metrics.bytesIn, int64(n))
+	return n, err
+}
+
+func (c meteredConn) Write(data []byte) (int, error) {
+	n, err := c.Conn.Write(data)
+	atomic.AddInt64(&c.metrics.bytesOut, int64(n))
+	return n, err
+}
@@ -0,0 +1,194 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyMetricsHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello")
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", freePort(t)))
+	target, _ := url.Parse(backend.URL)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go p.Serve()
+	defer p.Shutdown(context.Background())
+
+	tr := &http.Transport{}
+	c := &http.Client{Transport: tr}
+
+	waitListening(t, listen.Host)
+
+	// let the probe connection close before counting
+	for i := 0; i < 50 && p.Metrics().ActiveConnections > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < 2; i++ {
+		res, err := c.Get(listen.String())
+		if !assert.NoError(t, err) {
+			return
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	m := p.Metrics()
+	assert.Equal(t, int64(2), m.Requests)
+	assert.Equal(t, int64(0), m.Errors)
+	assert.True(t, m.BytesIn > 0)
+	assert.True(t, m.BytesOut > 0)
+
+	// the client keeps its connection alive between requests
+	assert.Equal(t, int64(1), m.ActiveConnections)
+
+	tr.CloseIdleConnections()
+
+	closed := false
+
+	for i := 0; i < 50; i++ {
+		if p.Metrics().ActiveConnections == 0 {
+			closed = true
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.True(t, closed)
+}
+
+func TestProxyMetricsTCPError(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://127.0.0.1:5000")
+	target, _ := url.Parse(fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t)))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	a, b := net.Pipe()
+	defer b.Close()
+
+	assert.Error(t, p.proxyTCPConnection(a, p.Target))
+
+	m := p.Metrics()
+	assert.Equal(t, int64(1), m.Errors)
+	assert.Equal(t, int64(0), m.ActiveConnections)
+}
+
+func TestRouterMetrics(t *testing.T) {
+	r := &Router{endpoints: map[string]Endpoint{}}
+
+	for _, host := range []string{"web.b.convox", "web.a.convox"} {
+		e := Endpoint{Host: host, Proxies: map[int]*Proxy{}, router: r}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse("http://localhost:5000")
+
+		p, err := e.NewProxy(host, listen, target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		p.metrics.request()
+
+		r.endpoints[host] = e
+	}
+
+	pms := r.Metrics()
+
+	if assert.Len(t, pms, 2) {
+		assert.Equal(t, "web.a.convox", pms[0].Host)
+		assert.Equal(t, "web.b.convox", pms[1].Host)
+		assert.Equal(t, "http://0.0.0.0:80", pms[0].Listen)
+		assert.Equal(t, "http", pms[0].Scheme)
+		assert.Equal(t, "http://localhost:5000", pms[0].Target)
+		assert.Equal(t, int64(1), pms[0].Metrics.Requests)
+	}
+}
+
+func TestProxyLastError(t *testing.T) {
+	var fail int32 = 1
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			cn, _, _ := w.(http.Hijacker).Hijack()
+			cn.Close()
+			return
+		}
+	}))
+	defer backend.Close()
+
+	r := &Router{endpoints: map[string]Endpoint{}}
+	e := Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}, router: r}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r.endpoints[e.Host] = e
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Nil(t, p.LastError())
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://test.convox/", nil))
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	le := p.LastError()
+	if assert.NotNil(t, le) {
+		assert.True(t, strings.Contains(le.Error, "EOF"), le.Error)
+		assert.WithinDuration(t, time.Now(), le.Time, time.Second)
+	}
+
+	pms := r.Metrics()
+	if assert.Len(t, pms, 1) {
+		assert.Equal(t, le, pms[0].LastError)
+	}
+
+	// a successful request clears the last error
+	atomic.StoreInt32(&fail, 0)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://test.convox/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Nil(t, p.LastError())
+
+	data, err := json.Marshal(r.Metrics())
+	if assert.NoError(t, err) {
+		assert.False(t, strings.Contains(string(data), "last_error"))
+	}
+}
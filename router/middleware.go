@@ -0,0 +1,16 @@
+package router
+
+import "net/http"
+
+// middleware wraps the handler of an http proxy with the checks that run before proxying
+func (p *Proxy) middleware(h http.Handler) http.Handler {
+	h = p.mirror(h)
+	h = p.authenticate(h)
+	h = p.limitRequestBody(h)
+	h = p.rateLimit(h)
+	h = p.limitConnections(h)
+	h = p.accessControl(h)
+	h = p.traceRequests(h)
+
+	return h
+}
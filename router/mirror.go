@@ -0,0 +1,120 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	mirrorMaxBody     = 1024 * 1024
+	mirrorMaxInflight = 100
+	mirrorTimeout     = 30 * time.Second
+)
+
+// MirrorOptions copies a sample of http requests to a shadow target, the responses are discarded
+type MirrorOptions struct {
+	// Target receives the mirrored requests, nil disables mirroring
+	Target *url.URL
+
+	// Rate is the fraction of requests to mirror between 0 and 1, NewProxy starts it at 1 and zero
+	// mirrors nothing
+	Rate float64
+
+	// KeepCredentials sends the Authorization, Proxy-Authorization and Cookie headers of mirrored
+	// requests to the shadow target, they are removed by default
+	KeepCredentials bool
+}
+
+// mirrorCredentialHeaders are removed from mirrored requests unless credentials are kept
+var mirrorCredentialHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+// mirror sends a copy of sampled requests to the mirror target without delaying the real request
+func (p *Proxy) mirror(h http.Handler) http.Handler {
+	if p.Mirror.Target == nil || p.Mirror.Rate <= 0 {
+		return h
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Timeout:       mirrorTimeout,
+		Transport:     defaultTransport(p.transportOptions()),
+	}
+
+	inflight := make(chan struct{}, mirrorMaxInflight)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// bodies of requests waiting for 100 Continue can not be read ahead of the backend
+		if mrand.Float64() >= p.Mirror.Rate || expectsContinue(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		body, ok := replayableBody(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case inflight <- struct{}{}:
+			mr := r.Clone(context.Background())
+			mr.RequestURI = ""
+			mr.URL.Scheme = p.Mirror.Target.Scheme
+			mr.URL.Host = p.Mirror.Target.Host
+			mr.Host = p.Mirror.Target.Host
+			mr.Body = ioutil.NopCloser(bytes.NewReader(body))
+			mr.ContentLength = int64(len(body))
+
+			if !p.Mirror.KeepCredentials {
+				for _, h := range mirrorCredentialHeaders {
+					mr.Header.Del(h)
+				}
+			}
+
+			go func() {
+				defer func() { <-inflight }()
+
+				res, err := client.Do(mr)
+				if err != nil {
+					logger.Log("mirror", Fields{"method": mr.Method, "path": mr.URL.Path, "target": p.Mirror.Target.Host, "error": err})
+					return
+				}
+
+				io.Copy(ioutil.Discard, res.Body)
+				res.Body.Close()
+			}()
+		default:
+			logger.Log("mirror", Fields{"method": r.Method, "path": r.URL.Path, "target": p.Mirror.Target.Host, "error": "too many mirrored requests in flight"})
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// replayableBody buffers small request bodies and restores them on r, large or
+// streaming bodies are left to the real request and are not replayable
+func replayableBody(r *http.Request) ([]byte, bool) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, true
+	}
+
+	if r.ContentLength > mirrorMaxBody {
+		return nil, false
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, mirrorMaxBody+1))
+
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+
+	if err != nil || len(data) > mirrorMaxBody {
+		return nil, false
+	}
+
+	return data, true
+}
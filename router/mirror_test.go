@@ -0,0 +1,162 @@
+package router
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyMirror(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d", len(data))
+	}))
+	defer backend.Close()
+
+	mirrored := make(chan string, 10)
+
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		mirrored <- fmt.Sprintf("%s %s %s %s", r.Method, r.Host, r.URL.Path, data)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer shadow.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?mirror=" + url.QueryEscape(shadow.URL))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "http://test.convox/orders", strings.NewReader("hello")))
+
+	// the real request gets the whole body and the shadow response is ignored
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "5", w.Body.String())
+
+	select {
+	case m := <-mirrored:
+		assert.Equal(t, fmt.Sprintf("POST %s /orders hello", shadow.Listener.Addr()), m)
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not mirrored")
+	}
+
+	// bodies too large to buffer are only sent to the real target
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "http://test.convox/upload", strings.NewReader(strings.Repeat("x", mirrorMaxBody+1))))
+
+	assert.Equal(t, fmt.Sprintf("%d", mirrorMaxBody+1), w.Body.String())
+
+	select {
+	case m := <-mirrored:
+		t.Fatalf("unexpected mirrored request: %s", m[0:20])
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestProxyMirrorOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	tests := []struct {
+		listen string
+		target string
+		err    string
+	}{
+		{"http://0.0.0.0:80", "http://localhost:5000?mirror=http://shadow:5000&mirror_rate=0.25", ""},
+		{"http://0.0.0.0:80", "http://localhost:5000?mirror=http://shadow:5000&mirror_rate=2", `invalid mirror_rate option "2": rate above 1`},
+		{"http://0.0.0.0:80", "http://localhost:5000?mirror=ftp://shadow", "invalid mirror target: ftp://shadow"},
+		{"tcp://0.0.0.0:5432", "tcp://localhost:5432?mirror=http://shadow:5000", "mirroring not supported for tcp listener"},
+	}
+
+	for _, tt := range tests {
+		listen, _ := url.Parse(tt.listen)
+		target, _ := url.Parse(tt.target)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+
+		if tt.err != "" {
+			if assert.Error(t, err, tt.target) {
+				assert.Contains(t, err.Error(), tt.err, tt.target)
+			}
+			continue
+		}
+
+		if assert.NoError(t, err, tt.target) {
+			assert.Equal(t, "shadow:5000", p.Mirror.Target.Host)
+			assert.Equal(t, 0.25, p.Mirror.Rate)
+		}
+	}
+}
+
+func TestProxyMirrorRateAndCredentials(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	mirrored := make(chan http.Header, 10)
+
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored <- r.Header
+	}))
+	defer shadow.Close()
+
+	tests := []struct {
+		opts   string
+		mirror bool
+		auth   string
+	}{
+		{"", true, ""},
+		{"&mirror_rate=1&mirror_credentials=true", true, "Bearer secret"},
+		{"&mirror_rate=0", false, ""},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse(backend.URL + "?mirror=" + url.QueryEscape(shadow.URL) + tt.opts)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		r := httptest.NewRequest("GET", "http://web.app.test/", nil)
+		r.Header.Set("Authorization", "Bearer secret")
+		r.Header.Set("Cookie", "session=secret")
+		r.Header.Set("X-Custom", "kept")
+
+		h.ServeHTTP(httptest.NewRecorder(), r)
+
+		select {
+		case mh := <-mirrored:
+			assert.True(t, tt.mirror, tt.opts)
+			assert.Equal(t, tt.auth, mh.Get("Authorization"), tt.opts)
+			assert.Equal(t, tt.auth != "", mh.Get("Cookie") != "", tt.opts)
+			assert.Equal(t, "kept", mh.Get("X-Custom"), tt.opts)
+		case <-time.After(200 * time.Millisecond):
+			assert.False(t, tt.mirror, tt.opts)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// behaviors when a rack service has no processes
+const (
+	NoBackendFail = "fail"
+	NoBackendWait = "wait"
+)
+
+const (
+	defaultNoBackendTimeout = 10 * time.Second
+	noBackendPoll           = 250 * time.Millisecond
+)
+
+// noBackendsError is returned when a rack service has no processes to send a request to
+type noBackendsError struct {
+	service string
+	release string
+}
+
+func (e noBackendsError) Error() string {
+	if e.release != "" {
+		return fmt.Sprintf("no processes available for service: %s in release %s", e.service, e.release)
+	}
+
+	return fmt.Sprintf("no processes available for service: %s", e.service)
+}
+
+// awaitBackends resolves the processes of a service, when NoBackendBehavior is wait and there are none
+// it polls until one appears, ctx is done or NoBackendTimeout passes
+func (p *Proxy) awaitBackends(ctx context.Context, app, service string) ([]Backend, error) {
+	bs, err := p.resolve(app, service)
+
+	if p.NoBackendBehavior != NoBackendWait || (err == nil && len(bs) > 0) {
+		return bs, err
+	}
+
+	deadline := time.NewTimer(coalesceDuration(p.NoBackendTimeout, defaultNoBackendTimeout))
+	defer deadline.Stop()
+
+	tick := time.NewTicker(noBackendPoll)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return bs, err
+		case <-tick.C:
+		}
+
+		p.backendCache.invalidate(app, service)
+
+		if bs, err = p.resolve(app, service); err == nil && len(bs) > 0 {
+			return bs, nil
+		}
+	}
+}
+
+func validNoBackendBehavior(behavior string) error {
+	switch behavior {
+	case "", NoBackendFail, NoBackendWait:
+		return nil
+	}
+
+	return fmt.Errorf("unknown no backend behavior: %s", behavior)
+}
@@ -0,0 +1,353 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listOptions may be given more than once on a target url, every other option only once
+var listOptions = map[string]bool{
+	"allow":        true,
+	"auth_user":    true,
+	"deny":         true,
+	"replica":      true,
+	"rewrite_host": true,
+	"route":        true,
+	"split":        true,
+}
+
+// configure applies proxy options passed as query parameters on the target url
+func (p *Proxy) configure(opts url.Values) error {
+	for k := range opts {
+		var err error
+
+		if len(opts[k]) > 1 && !listOptions[k] {
+			return fmt.Errorf("repeated %s option", k)
+		}
+
+		v := opts.Get(k)
+
+		switch k {
+		case "allow":
+			err = optionCIDRs(&p.AllowCIDRs, opts[k])
+		case "deny":
+			err = optionCIDRs(&p.DenyCIDRs, opts[k])
+		case "trust_forwarded_for":
+			err = optionBool(&p.TrustForwardedFor, v)
+		case "auth_user":
+			err = optionUsers(&p.Auth.Users, opts[k])
+		case "auth_token":
+			p.Auth.Token = v
+		case "auth_realm":
+			p.Auth.Realm = v
+		case "auth_forward":
+			err = optionBool(&p.Auth.Forward, v)
+		case "backend_cache_ttl":
+			if v == "off" {
+				p.BackendCacheTTL = -1
+			} else {
+				err = optionDuration(&p.BackendCacheTTL, v)
+			}
+		case "bind":
+			p.BindAddress = v
+		case "error_page_html":
+			err = optionHTMLTemplate(&p.ErrorPages.HTML, p.errorPagesDir(), v)
+		case "error_page_json":
+			err = optionTextTemplate(&p.ErrorPages.JSON, p.errorPagesDir(), v)
+		case "flush_interval":
+			err = optionDuration(&p.FlushInterval, v)
+		case "forwarded_headers":
+			p.ForwardedHeaders = v
+		case "h2c":
+			err = optionBool(&p.H2C, v)
+		case "dial_timeout", "tls_handshake_timeout", "response_header_timeout", "idle_conn_timeout",
+			"max_idle_conns", "max_idle_conns_per_host", "verify_tls":
+			_, err = p.Transport.configure(k, v)
+		case "upstream_scheme":
+			p.UpstreamScheme = v
+		case "no_backend":
+			p.NoBackendBehavior = v
+		case "no_backend_timeout":
+			err = optionDuration(&p.NoBackendTimeout, v)
+		case "pool_max_idle":
+			err = optionInt(&p.Pool.MaxIdle, v)
+		case "pool_max_lifetime":
+			err = optionDuration(&p.Pool.MaxLifetime, v)
+		case "pool_idle_timeout":
+			err = optionDuration(&p.Pool.IdleTimeout, v)
+		case "preserve_host":
+			err = optionBool(&p.PreserveHost, v)
+		case "health_check_interval":
+			err = optionDuration(&p.HealthCheck.Interval, v)
+		case "health_check_timeout":
+			err = optionDuration(&p.HealthCheck.Timeout, v)
+		case "health_cooldown":
+			err = optionDuration(&p.HealthCooldown, v)
+		case "proxy_protocol":
+			p.ProxyProtocol = v
+		case "tcp_keepalive":
+			if v == "off" {
+				p.TCP.KeepAlive = -1
+			} else {
+				err = optionDuration(&p.TCP.KeepAlive, v)
+			}
+		case "tcp_connect_retries":
+			if v == "off" {
+				p.TCP.ConnectRetries = -1
+			} else {
+				err = optionInt(&p.TCP.ConnectRetries, v)
+			}
+		case "tcp_connect_backoff":
+			err = optionDuration(&p.TCP.ConnectBackoff, v)
+		case "tcp_nodelay":
+			p.TCP.NoDelay = new(bool)
+			err = optionBool(p.TCP.NoDelay, v)
+		case "tls_min_version":
+			err = optionTLSVersion(&p.TLS.MinVersion, v)
+		case "tls_ciphers":
+			err = optionCipherSuites(&p.TLS.CipherSuites, v)
+		case "udp_idle_timeout":
+			err = optionDuration(&p.UDPIdleTimeout, v)
+		case "ws_read_buffer_size":
+			err = optionInt(&p.Websocket.ReadBufferSize, v)
+		case "ws_write_buffer_size":
+			err = optionInt(&p.Websocket.WriteBufferSize, v)
+		case "ws_compression":
+			err = optionBool(&p.Websocket.EnableCompression, v)
+		case "ws_handshake_timeout":
+			err = optionDuration(&p.Websocket.HandshakeTimeout, v)
+		case "ws_ping_interval":
+			err = optionDuration(&p.Websocket.PingInterval, v)
+		case "ws_read_timeout":
+			err = optionDuration(&p.Websocket.ReadTimeout, v)
+		case "ws_ignore_deadlines":
+			err = optionBool(&p.Websocket.IgnoreDeadlines, v)
+		case "ws_max_message_size":
+			if v == "off" {
+				p.Websocket.MaxMessageSize = -1
+			} else {
+				err = optionInt64(&p.Websocket.MaxMessageSize, v)
+			}
+		case "ws_max_duration":
+			err = optionDuration(&p.Websocket.MaxConnectionDuration, v)
+		case "ws_idle_timeout":
+			err = optionDuration(&p.Websocket.IdleTimeout, v)
+		case "breaker_threshold":
+			err = optionInt(&p.Breaker.Threshold, v)
+		case "breaker_cooldown":
+			err = optionDuration(&p.Breaker.Cooldown, v)
+		case "gzip":
+			err = optionBool(&p.Compression.Enabled, v)
+		case "gzip_min_size":
+			err = optionInt(&p.Compression.MinSize, v)
+		case "gzip_types":
+			p.Compression.Types = strings.Split(v, ",")
+		case "max_connections":
+			err = optionInt(&p.MaxConnections, v)
+		case "max_header_bytes":
+			err = optionInt(&p.MaxHeaderBytes, v)
+		case "max_request_bytes":
+			err = optionInt64(&p.MaxRequestBytes, v)
+		case "max_response_bytes":
+			err = optionInt64(&p.MaxResponseBytes, v)
+		case "mirror":
+			p.Mirror.Target, err = url.Parse(v)
+		case "mirror_credentials":
+			err = optionBool(&p.Mirror.KeepCredentials, v)
+		case "mirror_rate":
+			err = optionFloat(&p.Mirror.Rate, v)
+			if err == nil && p.Mirror.Rate > 1 {
+				err = fmt.Errorf("rate above 1")
+			}
+		case "rate_limit":
+			err = optionFloat(&p.RateLimit.Rate, v)
+		case "rate_limit_burst":
+			err = optionInt(&p.RateLimit.Burst, v)
+		case "rate_limit_clients":
+			err = optionInt(&p.RateLimit.MaxClients, v)
+		case "rewrite_host":
+			err = optionMap(&p.RewriteHosts, opts[k])
+		case "route":
+			err = optionRoutes(&p.Routes, p.Target, opts[k])
+		case "security_headers":
+			err = optionBool(&p.SecurityHeaders.Enabled, v)
+		case "csp":
+			p.SecurityHeaders.ContentSecurityPolicy = v
+		case "frame_options":
+			p.SecurityHeaders.FrameOptions = v
+		case "hsts":
+			p.SecurityHeaders.StrictTransportSecurity = v
+		case "referrer_policy":
+			p.SecurityHeaders.ReferrerPolicy = v
+		case "strip_prefix":
+			p.StripPrefix = v
+		case "add_prefix":
+			p.AddPrefix = v
+		case "stream_idle_timeout":
+			err = optionDuration(&p.StreamIdleTimeout, v)
+		case "split":
+			err = optionSplit(&p.Targets, p.Target, opts[k])
+		case "weight":
+			err = optionInt(&p.weight, v)
+		case "sticky":
+			err = optionBool(&p.Sticky.Enabled, v)
+		case "sticky_cookie":
+			p.Sticky.CookieName = v
+		case "sticky_ttl":
+			err = optionDuration(&p.Sticky.TTL, v)
+		case "release":
+			p.Release = v
+		case "replica":
+			err = optionReplicas(&p.Replicas, opts[k])
+		case "retry_buffer_bytes":
+			err = optionInt64(&p.RetryBufferBytes, v)
+		case "retries":
+			p.Retries = new(int)
+			err = optionInt(p.Retries, v)
+		default:
+			return fmt.Errorf("unknown proxy option: %s", k)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid %s option %q: %s", k, strings.Join(opts[k], ","), err)
+		}
+	}
+
+	return nil
+}
+
+func optionBool(b *bool, value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+
+	*b = v
+
+	return nil
+}
+
+func optionFloat(f *float64, value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+
+	if v < 0 {
+		return fmt.Errorf("negative value")
+	}
+
+	*f = v
+
+	return nil
+}
+
+func optionInt(i *int, value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+
+	if v < 0 {
+		return fmt.Errorf("negative value")
+	}
+
+	*i = v
+
+	return nil
+}
+
+func optionInt64(i *int64, value string) error {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if v < 0 {
+		return fmt.Errorf("negative value")
+	}
+
+	*i = v
+
+	return nil
+}
+
+// configure applies a transport option, shared by proxies and the endpoints they start from, and
+// reports whether k is one
+func (o *TransportOptions) configure(k, v string) (bool, error) {
+	switch k {
+	case "dial_timeout":
+		return true, optionDuration(&o.Timeouts.Dial, v)
+	case "tls_handshake_timeout":
+		return true, optionDuration(&o.Timeouts.TLSHandshake, v)
+	case "response_header_timeout":
+		return true, optionDuration(&o.Timeouts.ResponseHeader, v)
+	case "idle_conn_timeout":
+		return true, optionDuration(&o.Timeouts.IdleConn, v)
+	case "max_idle_conns":
+		return true, optionInt(&o.MaxIdleConns, v)
+	case "max_idle_conns_per_host":
+		return true, optionInt(&o.MaxIdleConnsPerHost, v)
+	case "verify_tls":
+		return true, optionBool(&o.VerifyTLS, v)
+	}
+
+	return false, nil
+}
+
+// optionTransport parses the transport options an endpoint passes on to its proxies
+func optionTransport(o *TransportOptions, opts url.Values) error {
+	for k := range opts {
+		v := opts.Get(k)
+
+		ok, err := o.configure(k, v)
+		if !ok {
+			return fmt.Errorf("unknown endpoint option: %s", k)
+		}
+		if len(opts[k]) > 1 {
+			return fmt.Errorf("repeated %s option", k)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s option %q: %s", k, v, err)
+		}
+	}
+
+	return nil
+}
+
+func optionDuration(d *time.Duration, value string) error {
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	if v < 0 {
+		return fmt.Errorf("negative duration")
+	}
+
+	*d = v
+
+	return nil
+}
+
+// optionMap parses repeated key=value values
+func optionMap(m *map[string]string, values []string) error {
+	if *m == nil {
+		*m = map[string]string{}
+	}
+
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("expected key=value")
+		}
+
+		(*m)[parts[0]] = parts[1]
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package router
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+)
+
+// PoolOptions tunes how process proxy streams of rack services are reused across requests.
+//
+// A process proxy stream is a plain byte stream to one process with no way to reset it between
+// users, so streams are only reused by http keep-alive: once a response is complete the stream
+// goes back to the service transport's idle pool, still pinned to the process it was opened for.
+// Websockets and tcp proxies hold their stream for the whole connection and are never pooled.
+// Zero values keep the transport defaults.
+type PoolOptions struct {
+	// MaxIdle is how many idle streams are kept for each service
+	MaxIdle int
+
+	// MaxLifetime stops reusing a stream once it is this old, it is closed after its current request
+	MaxLifetime time.Duration
+
+	// IdleTimeout closes streams that have been idle this long
+	IdleTimeout time.Duration
+}
+
+// pool applies the options to the transport of a rack service
+func (o PoolOptions) pool(tr *http.Transport) {
+	if o.MaxIdle > 0 {
+		tr.MaxIdleConnsPerHost = o.MaxIdle
+
+		if tr.MaxIdleConns < o.MaxIdle {
+			tr.MaxIdleConns = o.MaxIdle
+		}
+	}
+
+	if o.IdleTimeout > 0 {
+		tr.IdleConnTimeout = o.IdleTimeout
+	}
+
+	if o.MaxLifetime > 0 {
+		dial := tr.DialContext
+
+		tr.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			cn, err := dial(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+
+			return &pooledConn{Conn: cn, created: time.Now()}, nil
+		}
+	}
+}
+
+// pooledConn remembers when a stream was opened
+type pooledConn struct {
+	net.Conn
+	created time.Time
+}
+
+// lifetimeTransport makes the request that picks up an expired stream its last one
+type lifetimeTransport struct {
+	http.RoundTripper
+	lifetime time.Duration
+}
+
+func (t lifetimeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var r *http.Request
+
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			cn := info.Conn
+
+			if tc, ok := cn.(interface{ NetConn() net.Conn }); ok {
+				cn = tc.NetConn()
+			}
+
+			if pc, ok := cn.(*pooledConn); ok && time.Since(pc.created) > t.lifetime {
+				r.Close = true
+			}
+		},
+	}
+
+	r = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	return t.RoundTripper.RoundTrip(r)
+}
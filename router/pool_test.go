@@ -0,0 +1,136 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/convox/praxis/sdk/rack"
+	"github.com/convox/praxis/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// keepAliveRack answers every request on a process proxy stream until the client closes it
+type keepAliveRack struct {
+	rack.Rack
+
+	opens int64
+	setup time.Duration
+}
+
+func (r *keepAliveRack) ProcessList(app string, opts types.ProcessListOptions) (types.Processes, error) {
+	return types.Processes{{Id: "web-1", Service: "web"}}, nil
+}
+
+func (r *keepAliveRack) ProcessProxy(app, pid string, port int, in io.Reader) (io.ReadCloser, error) {
+	atomic.AddInt64(&r.opens, 1)
+
+	time.Sleep(r.setup)
+
+	pr, pw := io.Pipe()
+
+	go func() {
+		br := bufio.NewReader(in)
+
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			io.Copy(ioutil.Discard, req.Body)
+
+			fmt.Fprintf(pw, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+
+			if req.Close {
+				pw.Close()
+				return
+			}
+		}
+	}()
+
+	return pr, nil
+}
+
+func poolProxy(t testing.TB, kr *keepAliveRack, opts string) http.RoundTripper {
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000" + opts)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Rack = func() (rack.Rack, error) { return kr, nil }
+
+	return p.serviceRoundTripper("app", "web", 3000)
+}
+
+func poolRequest(t testing.TB, rt http.RoundTripper) {
+	req, _ := http.NewRequest("GET", "http://web.app.test/", nil)
+
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+}
+
+func TestServicePoolReusesStreams(t *testing.T) {
+	kr := &keepAliveRack{}
+	rt := poolProxy(t, kr, "?pool_max_idle=4")
+
+	for i := 0; i < 10; i++ {
+		poolRequest(t, rt)
+	}
+
+	assert.EqualValues(t, 1, atomic.LoadInt64(&kr.opens))
+}
+
+func TestServicePoolMaxLifetime(t *testing.T) {
+	kr := &keepAliveRack{}
+	rt := poolProxy(t, kr, "?pool_max_lifetime=50ms")
+
+	// the second request on each stream finds it expired and closes it
+	for i := 0; i < 4; i++ {
+		poolRequest(t, rt)
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	assert.EqualValues(t, 2, atomic.LoadInt64(&kr.opens))
+}
+
+func BenchmarkServiceProcessProxy(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		opts string
+	}{
+		// sticky proxies disable keep-alives so every request opens a stream
+		{"unpooled", "?sticky=true"},
+		{"pooled", "?pool_max_idle=16"},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			kr := &keepAliveRack{setup: time.Millisecond}
+			rt := poolProxy(b, kr, bb.opts)
+
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				poolRequest(b, rt)
+			}
+
+			b.ReportMetric(float64(atomic.LoadInt64(&kr.opens))/float64(b.N), "opens/op")
+		})
+	}
+}
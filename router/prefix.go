@@ -0,0 +1,71 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// rewritePath strips StripPrefix from the path of requests sent to http backends and then prepends
+// AddPrefix. Prefixes match whole path segments and ignore a trailing slash, so stripping /service
+// turns /service and /service/ into / and /service/users into /users but leaves /services alone.
+// Added prefixes keep the trailing slash of the path, / becomes /api/ and /users becomes /api/users
+func (p *Proxy) rewritePath(u *url.URL) {
+	if p.StripPrefix == "" && p.AddPrefix == "" {
+		return
+	}
+
+	raw := u.RawPath
+
+	u.Path = rewritePrefix(u.Path, p.StripPrefix, p.AddPrefix)
+
+	if raw != "" {
+		u.RawPath = rewritePrefix(raw, p.StripPrefix, p.AddPrefix)
+
+		// drop an escaped path that no longer matches so the url is encoded from Path
+		if up, err := url.PathUnescape(u.RawPath); err != nil || up != u.Path {
+			u.RawPath = ""
+		}
+	}
+}
+
+func rewritePrefix(path, strip, add string) string {
+	if path == "" {
+		path = "/"
+	}
+
+	if strip = strings.TrimSuffix(strip, "/"); strip != "" && hasPathPrefix(path, strip) {
+		path = coalesceString(strings.TrimPrefix(path, strip), "/")
+	}
+
+	if add = strings.TrimSuffix(add, "/"); add != "" {
+		path = add + path
+	}
+
+	return path
+}
+
+// hasPathPrefix matches prefix against whole segments of path, ignoring a trailing slash on the prefix
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+func (p *Proxy) validatePrefixes() error {
+	if p.StripPrefix == "" && p.AddPrefix == "" {
+		return nil
+	}
+
+	if p.Listen.Scheme != "http" && p.Listen.Scheme != "https" {
+		return fmt.Errorf("path rewriting not supported for %s listener", p.Listen.Scheme)
+	}
+
+	for _, prefix := range []string{p.StripPrefix, p.AddPrefix} {
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			return fmt.Errorf("path prefix must start with /: %s", prefix)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+const preflightTimeout = 3 * time.Second
+
+// Preflight checks that the target can be reached: rack services need a running process,
+// rack resources must exist and other targets must accept a connection
+func (p *Proxy) Preflight() error {
+	if p.Target.Hostname() != "rack" {
+		if p.Target.Scheme == "udp" {
+			return nil
+		}
+
+		network, address := targetAddress(p.Target)
+
+		cn, err := net.DialTimeout(network, address, preflightTimeout)
+		if err != nil {
+			return fmt.Errorf("target %s is not reachable: %s", p.Target, err)
+		}
+
+		return cn.Close()
+	}
+
+	app, kind, name, _, err := parseRackTarget(p.Target)
+	if err != nil {
+		return err
+	}
+
+	switch kind {
+	case "service":
+		bs, err := p.resolver().Resolve(app, name)
+		if err != nil {
+			return fmt.Errorf("could not list processes for %s/%s: %s", app, name, err)
+		}
+
+		if len(bs) == 0 {
+			return fmt.Errorf("no processes running for %s/%s", app, name)
+		}
+	case "resource":
+		r, err := p.rack()
+		if err != nil {
+			return err
+		}
+
+		if _, err := r.ResourceGet(app, name); err != nil {
+			return fmt.Errorf("could not find resource %s/%s: %s", app, name, err)
+		}
+	default:
+		return fmt.Errorf("unknown proxy type: %s", kind)
+	}
+
+	return nil
+}
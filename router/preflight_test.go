@@ -0,0 +1,59 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyPreflight(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	_, cleanup := newRackAPI(types.Processes{{Id: "web-1", Service: "web"}})
+	defer cleanup()
+
+	closed := freePort(t)
+
+	tests := []struct {
+		listen string
+		target string
+		err    string
+	}{
+		{"tcp://0.0.0.0:5000", fmt.Sprintf("tcp://%s", ln.Addr()), ""},
+		{"tcp://0.0.0.0:5000", fmt.Sprintf("tcp://127.0.0.1:%d", closed), fmt.Sprintf("target tcp://127.0.0.1:%d is not reachable", closed)},
+		{"udp://0.0.0.0:5000", fmt.Sprintf("udp://127.0.0.1:%d", closed), ""},
+		{"http://0.0.0.0:80", "http://rack/app/service/web:3000", ""},
+		{"http://0.0.0.0:80", "http://rack/app/service/worker:3000", "no processes running for app/worker"},
+		{"tcp://0.0.0.0:5432", "tcp://rack/app/resource/database:5432", "could not find resource app/database"},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse(tt.listen)
+		target, _ := url.Parse(tt.target)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err, tt.target) {
+			continue
+		}
+
+		err = p.Preflight()
+
+		if tt.err == "" {
+			assert.NoError(t, err, tt.target)
+		} else if assert.Error(t, err, tt.target) {
+			assert.True(t, strings.HasPrefix(err.Error(), tt.err), err.Error())
+		}
+	}
+
+}
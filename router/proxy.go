@@ -12,28 +12,256 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/convox/praxis/helpers"
 	"github.com/convox/praxis/sdk/rack"
-	"github.com/convox/praxis/types"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
-
-	mrand "math/rand"
 )
 
 type Proxy struct {
 	Listen *url.URL
 	Target *url.URL
 
-	endpoint *Endpoint
+	// Routes sends matching requests to other rack services before falling back to Target
+	Routes []RouteRule
+
+	// Targets splits requests between weighted rack services, Target is only used when empty
+	Targets []WeightedTarget
+
+	// FlushInterval is how often http responses are flushed to the client, zero leaves it to the
+	// response writer and negative flushes after every write. Streaming responses are always
+	// flushed as they arrive
+	FlushInterval time.Duration
+
+	// ErrorPages renders failed requests on http listeners
+	ErrorPages ErrorPages
+
+	// ForwardedHeaders controls the X-Forwarded-* headers sent upstream: append (the default), replace or off
+	ForwardedHeaders string
+
+	// UpstreamScheme is the scheme, http or https, spoken to http backends whatever the listener, empty
+	// uses the scheme of the target so https listeners can offload tls and http listeners originate it
+	UpstreamScheme string
+
+	// StripPrefix is removed from the path of http requests before they are sent upstream
+	StripPrefix string
+
+	// AddPrefix is prepended to the path of http requests sent upstream, after StripPrefix is removed
+	AddPrefix string
+
+	// H2C serves and forwards HTTP/2 over cleartext on http listeners. Clients need prior knowledge,
+	// an HTTP/1.1 Upgrade: h2c request is not upgraded and is proxied over HTTP/1.1
+	H2C bool
+
+	// Transport tunes upstream connections, it starts from the endpoint's options
+	Transport TransportOptions
+
+	// Timeouts overrides the upstream timeouts of Transport field by field
+	//
+	// Deprecated: set Transport.Timeouts instead
+	Timeouts Timeouts
+
+	// BackendCacheTTL is how long resolved backends are reused, zero uses 2s and a negative value disables the cache
+	BackendCacheTTL time.Duration
+
+	// Tracer creates a span for every http request and websocket upgrade and propagates it upstream, nil disables tracing
+	Tracer Tracer
+
+	// EventHandler is called in the background for proxy and connection lifecycle events
+	EventHandler EventHandler
+
+	// OnBackendsChanged is called in the background when processes join or leave a rack service
+	OnBackendsChanged func(app, service string, added, removed []Backend)
+
+	// Rack creates the rack client used to reach processes and resources, nil uses rack.NewFromEnv
+	Rack func() (rack.Rack, error)
+
+	// Resolver finds the backends of rack services, nil lists the processes on the rack
+	Resolver BackendResolver
+
+	// StreamIdleTimeout closes process proxy streams of rack services, websockets included, that moved no
+	// data for this long, zero never closes them. Idle pooled streams are closed by it too
+	StreamIdleTimeout time.Duration
+
+	// Pool tunes the reuse of process proxy streams by http requests to rack services
+	Pool PoolOptions
+
+	// Replicas are other rack resources serving the same data as a resource target, tried in turn
+	// when connecting fails before any data was sent
+	Replicas []string
+
+	// ResourceResolver finds the replicas of rack resources, nil uses the target followed by Replicas
+	ResourceResolver ResourceResolver
+
+	// NoBackendBehavior is what requests to a rack service without processes do: fail (the default)
+	// answers 503 right away and wait polls for a process for up to NoBackendTimeout
+	NoBackendBehavior string
+
+	// NoBackendTimeout is how long requests wait for a process, zero uses 10s
+	NoBackendTimeout time.Duration
+
+	// Release limits rack services to the processes of one release, empty uses every process
+	Release string
+
+	// AllowCIDRs limits clients to these networks, empty allows every client
+	AllowCIDRs []*net.IPNet
+
+	// DenyCIDRs rejects clients from these networks even when they are allowed
+	DenyCIDRs []*net.IPNet
+
+	// Auth requires basic auth or a bearer token on http proxies, off when empty
+	Auth AuthOptions
+
+	// TrustForwardedFor checks the last X-Forwarded-For address of http requests, the one added by the
+	// load balancer in front of the router, instead of the connection for access rules and rate limits
+	TrustForwardedFor bool
+
+	// BindAddress listens on this ip instead of the host of the Listen url
+	BindAddress string
+
+	// PreserveHost passes the Host header of the client to rack services instead of the endpoint host
+	PreserveHost bool
+
+	// HealthCheck actively checks tcp and http targets that are not rack services
+	HealthCheck HealthCheck
+
+	// HealthCooldown is how long a process that failed to connect is skipped
+	HealthCooldown time.Duration
+
+	// Retries is how many other processes an idempotent request is retried on when a dial fails, nil uses the default
+	Retries *int
+
+	// RetryBufferBytes caps the body buffered to retry requests with an Idempotency-Key, zero uses 64KB
+	RetryBufferBytes int64
+
+	// ProxyProtocol sends or receives a PROXY protocol header on tcp proxies
+	ProxyProtocol string
+
+	// TCP tunes the sockets of tcp proxies
+	TCP TCPOptions
+
+	// TLS sets the protocol versions and cipher suites of https and tls listeners
+	TLS TLSOptions
+
+	// UDPIdleTimeout closes udp sessions that have not seen traffic for this long
+	UDPIdleTimeout time.Duration
+
+	// Breaker fails requests to a rack service fast while it keeps failing
+	Breaker BreakerOptions
+
+	// Compression gzips http responses for clients that accept it
+	Compression CompressionOptions
+
+	// MaxConnections caps the concurrent tcp connections or http requests, zero is unlimited
+	MaxConnections int
+
+	// MaxHeaderBytes limits the size of request headers on http listeners, larger ones get a 431,
+	// zero uses the net/http default of 1MB
+	MaxHeaderBytes int
+
+	// MaxRequestBytes rejects request bodies larger than this with a 413, zero is unlimited
+	MaxRequestBytes int64
+
+	// MaxResponseBytes fails responses larger than this with a 502, zero is unlimited
+	MaxResponseBytes int64
+
+	// Mirror copies a sample of http requests to a shadow target
+	Mirror MirrorOptions
+
+	// RateLimit limits requests per client ip on http proxies
+	RateLimit RateLimitOptions
+
+	// RewriteHosts maps backend hosts in Location and Set-Cookie headers to public hosts
+	RewriteHosts map[string]string
+
+	// SecurityHeaders adds security headers to http responses
+	SecurityHeaders SecurityHeaders
+
+	// Sticky pins clients of rack services to a process with a cookie
+	Sticky StickyOptions
+
+	// Websocket tunes the websocket connections of rack service proxies
+	Websocket WebsocketOptions
+
+	backendCache *backendCache
+	balancer     *balancer
+	breakers     breakers
+	cancel       context.CancelFunc
+	conns        sync.WaitGroup
+	ctx          context.Context
+	down         int32
+	drain        *drainer
+	events       eventQueue
+	endpoint     *Endpoint
+	health       *healthChecker
+	inflight     int64
+	inherited    net.Listener
+	listener     net.Listener
+	lock         sync.Mutex
+	metrics      *metrics
+	packet       net.PacketConn
+	ready        chan struct{}
+	serveErr     error
+	registry     connRegistry
+	rackClient   rack.Rack
+	rackLock     sync.Mutex
+	rackURL      string
+	resourceNext uint64
+	server       *http.Server
+	shutdown     bool
+	weight       int
 }
 
 func (e *Endpoint) NewProxy(host string, listen, target *url.URL) (*Proxy, error) {
 	p := &Proxy{
-		Listen:   listen,
-		Target:   target,
-		endpoint: e,
+		Listen:       listen,
+		Target:       target,
+		Transport:    e.Transport,
+		Mirror:       MirrorOptions{Rate: 1},
+		backendCache: newBackendCache(),
+		balancer:     newBalancer(),
+		drain:        newDrainer(),
+		endpoint:     e,
+		metrics:      &metrics{},
+		weight:       100,
+	}
+
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	if err := p.configure(target.Query()); err != nil {
+		return nil, err
+	}
+
+	t := *target
+	t.RawQuery = ""
+	p.Target = &t
+
+	if len(p.Targets) > 0 {
+		p.Targets = append([]WeightedTarget{{Target: p.Target, Weight: p.weight}}, p.Targets...)
+	}
+
+	p.health = newHealthChecker(p.HealthCooldown)
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	// the header is only read and written on raw tcp connections, never through tls
+	if p.ProxyProtocol != ProxyProtocolNone && p.Listen.Scheme != "tcp" {
+		return nil, fmt.Errorf("proxy protocol not supported for %s listener", p.Listen.Scheme)
+	}
+
+	switch p.ProxyProtocol {
+	case ProxyProtocolNone, ProxyProtocolReceive:
+	case ProxyProtocolSend, ProxyProtocolSendV2:
+		if p.Target.Hostname() == "rack" {
+			return nil, fmt.Errorf("proxy protocol %s not supported for rack targets", p.ProxyProtocol)
+		}
+	default:
+		return nil, fmt.Errorf("unknown proxy protocol mode: %s", p.ProxyProtocol)
 	}
 
 	pi, err := strconv.Atoi(listen.Port())
@@ -41,45 +269,214 @@ func (e *Endpoint) NewProxy(host string, listen, target *url.URL) (*Proxy, error
 		return nil, err
 	}
 
+	// proxies on port 0 only learn their port once listening and are registered by Serve
+	if pi == 0 {
+		return p, nil
+	}
+
 	if _, ok := e.Proxies[pi]; ok {
 		return nil, fmt.Errorf("proxy already exists for port: %d", pi)
 	}
 
-	e.Proxies[pi] = *p
+	e.Proxies[pi] = p
 
 	return p, nil
 }
 
-func (p Proxy) MarshalJSON() ([]byte, error) {
+func (p *Proxy) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]string{
 		"listen": p.Listen.String(),
 		"target": p.Target.String(),
 	})
 }
 
-func (p *Proxy) Serve() error {
-	ln, err := net.Listen("tcp", p.Listen.Host)
+// target schemes each listener scheme can proxy to
+var proxySchemes = map[string][]string{
+	"http":  {"http", "https", "unix"},
+	"https": {"http", "https", "unix"},
+	"tcp":   {"tcp", "unix"},
+	"udp":   {"udp"},
+}
+
+// rack endpoint kinds each listener scheme can proxy to
+var rackKinds = map[string]string{
+	"http":  "service",
+	"https": "service",
+	"tcp":   "resource",
+}
+
+// listenAddress is the address Serve binds to
+func (p *Proxy) listenAddress() string {
+	if p.BindAddress != "" {
+		return net.JoinHostPort(p.BindAddress, p.Listen.Port())
+	}
+
+	return p.Listen.Host
+}
+
+// validate rejects listener and target combinations that Serve can not handle
+func (p *Proxy) validate() error {
+	if p.BindAddress != "" && net.ParseIP(p.BindAddress) == nil {
+		return fmt.Errorf("invalid bind address: %s", p.BindAddress)
+	}
+
+	targets, ok := proxySchemes[p.Listen.Scheme]
+	if !ok {
+		return fmt.Errorf("unknown listener scheme: %s", p.Listen.Scheme)
+	}
+
+	if !helpers.In(p.Target.Scheme, targets) {
+		return fmt.Errorf("can not proxy %s listener to %s target", p.Listen.Scheme, p.Target.Scheme)
+	}
+
+	if p.Mirror.Target != nil {
+		if p.Listen.Scheme != "http" && p.Listen.Scheme != "https" {
+			return fmt.Errorf("mirroring not supported for %s listener", p.Listen.Scheme)
+		}
+
+		if (p.Mirror.Target.Scheme != "http" && p.Mirror.Target.Scheme != "https") || p.Mirror.Target.Host == "" {
+			return fmt.Errorf("invalid mirror target: %s", p.Mirror.Target)
+		}
+	}
+
+	if err := p.validateUpstreamScheme(); err != nil {
+		return err
+	}
+
+	if err := p.validatePrefixes(); err != nil {
+		return err
+	}
+
+	if err := validForwardedHeaders(p.ForwardedHeaders); err != nil {
+		return err
+	}
+
+	if err := validNoBackendBehavior(p.NoBackendBehavior); err != nil {
+		return err
+	}
+
+	if err := p.validateSplit(); err != nil {
+		return err
+	}
+
+	if err := p.validateRoutes(); err != nil {
+		return err
+	}
+
+	if p.Target.Scheme == "unix" {
+		return validateSocket(p.Target)
+	}
+
+	if p.Target.Hostname() != "rack" {
+		if p.Target.Host == "" {
+			return fmt.Errorf("target has no host: %s", p.Target)
+		}
+		return nil
+	}
+
+	kind, ok := rackKinds[p.Listen.Scheme]
+	if !ok {
+		return fmt.Errorf("rack targets not supported for %s listener", p.Listen.Scheme)
+	}
+
+	_, k, _, _, err := parseRackTarget(p.Target)
 	if err != nil {
 		return err
 	}
 
-	defer ln.Close()
+	if k != kind {
+		return fmt.Errorf("can not proxy %s listener to rack %s", p.Listen.Scheme, k)
+	}
 
-	switch p.Listen.Scheme {
-	case "https", "tls":
-		cert, err := p.endpoint.router.generateCertificate(p.endpoint.Host)
+	return nil
+}
+
+// parseRackTarget splits a rack target of the form /app/kind/name:port
+func parseRackTarget(u *url.URL) (app, kind, name string, port int, err error) {
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+
+	if len(parts) > 3 {
+		return "", "", "", 0, fmt.Errorf("invalid rack target %s: too many path segments", u)
+	}
+
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+
+	switch {
+	case parts[0] == "":
+		return "", "", "", 0, fmt.Errorf("invalid rack target %s: missing app", u)
+	case parts[1] == "":
+		return "", "", "", 0, fmt.Errorf("invalid rack target %s: missing kind", u)
+	case parts[2] == "":
+		return "", "", "", 0, fmt.Errorf("invalid rack target %s: missing %s", u, parts[1])
+	}
+
+	np := strings.SplitN(parts[2], ":", 2)
+
+	if np[0] == "" {
+		return "", "", "", 0, fmt.Errorf("invalid rack target %s: missing %s", u, parts[1])
+	}
+
+	if len(np) < 2 || np[1] == "" {
+		return "", "", "", 0, fmt.Errorf("invalid rack target %s: missing port", u)
+	}
+
+	pi, err := strconv.Atoi(np[1])
+	if err != nil || pi < 1 || pi > 65535 {
+		return "", "", "", 0, fmt.Errorf("invalid rack target %s: invalid port: %s", u, np[1])
+	}
+
+	return parts[0], parts[1], np[0], pi, nil
+}
+
+func (p *Proxy) Serve() (err error) {
+	defer func() {
 		if err != nil {
-			return err
+			p.metrics.error(err)
 		}
 
-		cfg := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-		}
+		p.stopped(err)
+		p.emit(EventStop, nil, err)
+	}()
 
-		// TODO: check for h2
-		cfg.NextProtos = []string{"h2"}
+	if p.Listen.Scheme == "udp" {
+		return p.serveUDP()
+	}
 
-		ln = tls.NewListener(ln, cfg)
+	ln, err := p.listen()
+	if err != nil {
+		return err
+	}
+
+	defer ln.Close()
+
+	ln = meteredListener{Listener: ln, metrics: p.metrics}
+	ln = registeredListener{Listener: ln, registry: &p.registry}
+
+	p.register(ln.Addr())
+
+	p.lock.Lock()
+	if p.shutdown {
+		p.lock.Unlock()
+		return nil
+	}
+	p.listener = ln
+	p.listening()
+	p.lock.Unlock()
+
+	p.emit(EventStart, nil, nil)
+
+	if p.activeHealthCheck() {
+		done := make(chan struct{})
+		defer close(done)
+
+		go p.checkHealth(done)
+	}
+
+	switch p.Listen.Scheme {
+	case "https", "tls":
+		ln = tls.NewListener(ln, p.tlsConfig())
 	}
 
 	switch p.Listen.Scheme {
@@ -89,11 +486,21 @@ func (p *Proxy) Serve() error {
 			return err
 		}
 
-		if err := http.Serve(ln, h); err != nil {
+		if p.H2C && p.Listen.Scheme == "http" {
+			h = h2cHandler(h)
+		}
+
+		s := &http.Server{Handler: h, ConnState: p.connState, MaxHeaderBytes: p.MaxHeaderBytes}
+
+		p.lock.Lock()
+		p.server = s
+		p.lock.Unlock()
+
+		if err := s.Serve(ln); err != nil && err != http.ErrServerClosed {
 			return err
 		}
 	case "tcp":
-		if err := proxyTCP(ln, p.Target); err != nil {
+		if err := p.proxyTCP(ln, p.Target); err != nil && !p.isShutdown() {
 			return err
 		}
 	default:
@@ -103,6 +510,59 @@ func (p *Proxy) Serve() error {
 	return nil
 }
 
+// getCertificate serves the endpoint certificate when it covers the requested server name, or a
+// certificate for that name from the router's certificate source when it belongs to another endpoint
+func (p *Proxy) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	r := p.endpoint.router
+
+	// a configured source also issues the certificates for the endpoint's own names
+	if host == "" || !validHostname(host) || r.Certificates == nil {
+		names, ips := p.endpoint.certificateNames()
+
+		cert, leaf, err := r.certificate(names, ips)
+		if err != nil {
+			return nil, err
+		}
+
+		if host == "" || !validHostname(host) || leaf.VerifyHostname(host) == nil {
+			return &cert, nil
+		}
+	}
+
+	if !p.endpoint.servesHost(host) && !r.servesHost(host) {
+		return nil, fmt.Errorf("unknown server name: %s", host)
+	}
+
+	cert, err := r.certificateSource().GetCertificate(host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cert, nil
+}
+
+func validHostname(host string) bool {
+	if len(host) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z') && !(c >= '0' && c <= '9') && c != '-' {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
 func (p *Proxy) proxyHTTP(listen, target *url.URL) (http.Handler, error) {
 	if target.Hostname() == "rack" {
 		h, err := p.proxyRackHTTP()
@@ -110,84 +570,227 @@ func (p *Proxy) proxyHTTP(listen, target *url.URL) (http.Handler, error) {
 			return nil, err
 		}
 
-		return h, nil
+		return p.middleware(h), nil
 	}
 
-	px := httputil.NewSingleHostReverseProxy(target)
+	upstream := *target
+	upstream.Scheme = p.upstreamScheme()
+	tr := defaultTransport(p.transportOptions())
 
-	px.Transport = logTransport{RoundTripper: defaultTransport()}
+	if target.Scheme == "unix" {
+		upstream = url.URL{Scheme: p.upstreamScheme(), Host: "unix"}
+		tr = p.unixTransport(target.Path)
+	}
 
-	return px, nil
+	px := httputil.NewSingleHostReverseProxy(&upstream)
+
+	px.ErrorHandler = p.proxyError
+	px.FlushInterval = p.FlushInterval
+	px.ModifyResponse = p.modifyResponse
+
+	director := px.Director
+
+	px.Director = nil
+	px.Rewrite = p.rewriter(func(r *http.Request) {
+		// rewrite the client path before it is joined to the path of the target
+		p.rewritePath(r.URL)
+		director(r)
+		p.forwardedDirector(r)
+		p.injectTrace(r.Context(), r.Header)
+		ensureRequestID(r.Header)
+	})
+
+	px.Transport = logTransport{RoundTripper: p.transport(tr), metrics: p.metrics}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !p.Healthy() {
+			p.renderError(w, r, http.StatusServiceUnavailable, "backend unavailable")
+			return
+		}
+
+		px.ServeHTTP(w, r)
+	})
+
+	return p.middleware(h), nil
+}
+
+// Shutdown stops accepting connections and waits for active ones to finish or ctx to expire
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	p.lock.Lock()
+	p.shutdown = true
+	ln := p.listener
+	pc := p.packet
+	s := p.server
+	p.lock.Unlock()
+
+	if pc != nil {
+		pc.Close()
+	}
+
+	if s != nil {
+		if err := s.Shutdown(ctx); err != nil {
+			return err
+		}
+	} else if ln != nil {
+		ln.Close()
+	}
+
+	// hijacked websockets and tcp connections are not tracked by http.Server
+	done := make(chan struct{})
+
+	go func() {
+		p.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		// stop in-flight tcp copies that did not drain in time
+		p.cancel()
+		return ctx.Err()
+	}
+}
+
+func (p *Proxy) isShutdown() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.shutdown
 }
 
-func proxyTCP(listener net.Listener, target *url.URL) error {
+func (p *Proxy) proxyTCP(listener net.Listener, target *url.URL) error {
+	var delay time.Duration
+
 	for {
 		cn, err := listener.Accept()
 		if err != nil {
+			// back off on temporary errors like running out of file descriptors, as net/http does
+			if ne, ok := err.(net.Error); ok && ne.Temporary() && !p.isShutdown() {
+				delay = acceptBackoff(delay)
+				logger.Log("accept", Fields{"type": "tcp", "error": err, "retry": delay})
+				time.Sleep(delay)
+				continue
+			}
+
 			return err
 		}
 
-		go proxyRackTCP(cn, target)
+		delay = 0
+
+		// with a PROXY header the client is only known once the header is read
+		if p.ProxyProtocol != ProxyProtocolReceive && !p.allowedAddr(cn.RemoteAddr()) {
+			logger.Log("access", Fields{"type": "tcp", "remote": cn.RemoteAddr().String(), "status": "denied"})
+			cn.Close()
+			continue
+		}
+
+		if !p.acquire() {
+			p.metrics.error(fmt.Errorf("connection limit reached"))
+			cn.Close()
+			continue
+		}
+
+		p.conns.Add(1)
+
+		go func() {
+			defer p.conns.Done()
+			defer p.release()
+			p.proxyTCPConnection(cn, target)
+		}()
 	}
 }
 
-func proxyTCPConnection(cn net.Conn, target *url.URL) error {
+func (p *Proxy) proxyTCPConnection(cn net.Conn, target *url.URL) error {
+	p.metrics.connOpen()
+	defer p.metrics.connClose()
+
+	p.emit(EventAccept, cn.RemoteAddr(), nil)
+	defer p.emit(EventClose, cn.RemoteAddr(), nil)
+
+	p.TCP.tuneTCP(cn)
+
+	if p.ProxyProtocol == ProxyProtocolReceive {
+		pc, err := receiveProxyHeader(cn)
+		if err != nil {
+			p.metrics.error(err)
+			cn.Close()
+			return err
+		}
+		cn = pc
+
+		if !p.allowedAddr(cn.RemoteAddr()) {
+			logger.Log("access", Fields{"type": "tcp", "remote": cn.RemoteAddr().String(), "status": "denied"})
+			cn.Close()
+			return nil
+		}
+	}
+
+	logger.Log("proxy", Fields{"type": "tcp", "remote": cn.RemoteAddr().String(), "target": target.String()})
+
 	if target.Hostname() == "rack" {
-		return proxyRackTCP(cn, target)
+		if err := p.proxyRackTCP(cn, target); err != nil {
+			p.metrics.error(err)
+			return err
+		}
+
+		return nil
 	}
 
 	defer cn.Close()
 
-	oc, err := net.Dial("tcp", target.Host)
-	if err != nil {
+	if !p.Healthy() {
+		err := fmt.Errorf("target is down: %s", target.Host)
+		p.metrics.error(err)
 		return err
 	}
 
-	defer oc.Close()
+	network, address := targetAddress(target)
 
-	return helpers.Pipe(cn, oc)
-}
-
-func proxyRackTCP(cn net.Conn, target *url.URL) error {
-	defer cn.Close()
+	oc, err := net.Dial(network, address)
+	if err != nil {
+		p.metrics.error(err)
+		return err
+	}
 
-	parts := strings.Split(target.Path, "/")
+	p.metrics.success()
 
-	if len(parts) < 4 {
-		return fmt.Errorf("invalid rack endpoint: %s", target)
-	}
+	defer oc.Close()
 
-	app := parts[1]
-	kind := parts[2]
-	rp := strings.Split(parts[3], ":")
+	p.TCP.tuneTCP(oc)
 
-	if len(rp) < 2 {
-		return fmt.Errorf("invalid %s endpoint: %s", kind, parts[2])
+	if err := writeProxyHeader(oc, p.ProxyProtocol, cn.RemoteAddr(), cn.LocalAddr()); err != nil {
+		p.metrics.error(err)
+		return err
 	}
 
-	resource := rp[0]
+	return helpers.PipeContext(p.ctx, cn, oc)
+}
 
-	var pr io.ReadCloser
+func (p *Proxy) proxyRackTCP(cn net.Conn, target *url.URL) error {
+	defer cn.Close()
 
-	r, err := rack.NewFromEnv()
+	app, kind, resource, _, err := parseRackTarget(target)
 	if err != nil {
 		return err
 	}
 
+	var pr io.ReadCloser
+
 	switch kind {
 	case "resource":
-		rc, err := r.ResourceProxy(app, resource, cn)
+		pr, err = p.dialResource(app, resource, &startedReader{Reader: cn}, target)
 		if err != nil {
 			return err
 		}
-		pr = rc
 	default:
 		return fmt.Errorf("unknown proxy type: %s", kind)
 	}
 
 	defer pr.Close()
 
-	if err := helpers.Stream(cn, pr); err != nil {
+	if err := helpers.StreamContext(p.ctx, cn, pr); err != nil {
 		return err
 	}
 
@@ -195,32 +798,38 @@ func proxyRackTCP(cn net.Conn, target *url.URL) error {
 }
 
 func (p *Proxy) proxyRackHTTP() (http.Handler, error) {
-	parts := strings.Split(p.Target.Path, "/")
+	var h http.Handler
+	var err error
 
-	if len(parts) < 4 {
-		return nil, fmt.Errorf("invalid rack endpoint")
+	if len(p.Targets) > 0 {
+		h, err = p.proxySplit()
+	} else {
+		h, err = p.proxyRackService(p.Target)
 	}
 
-	app := parts[1]
-	kind := parts[2]
-	sp := strings.Split(parts[3], ":")
+	if err != nil {
+		return nil, err
+	}
 
-	if len(sp) < 2 {
-		return nil, fmt.Errorf("invalid %s endpoint: %s", kind, parts[2])
+	if len(p.Routes) > 0 {
+		return p.proxyRules(h)
 	}
 
-	service := sp[0]
+	return h, nil
+}
 
-	pi, err := strconv.Atoi(sp[1])
+// proxyRackService proxies http and websocket requests to the rack service in target
+func (p *Proxy) proxyRackService(target *url.URL) (http.Handler, error) {
+	app, kind, service, pi, err := parseRackTarget(target)
 	if err != nil {
 		return nil, err
 	}
 
-	rp := &httputil.ReverseProxy{Director: p.rackDirector}
+	rp := &httputil.ReverseProxy{Rewrite: p.rewriter(p.rackDirector), ErrorHandler: p.proxyError, FlushInterval: p.FlushInterval, ModifyResponse: p.modifyResponse}
 
 	switch kind {
 	case "service":
-		rp.Transport = logTransport{RoundTripper: serviceTransport(app, service, pi)}
+		rp.Transport = p.serviceRoundTripper(app, service, pi)
 	default:
 		return nil, fmt.Errorf("unknown proxy type: %s", kind)
 	}
@@ -232,140 +841,210 @@ func (p *Proxy) proxyRackHTTP() (http.Handler, error) {
 	return px, nil
 }
 
+// transport upgrades tr to HTTP/2 over cleartext when h2c is enabled for a plaintext target
+func (p *Proxy) transport(tr *http.Transport) http.RoundTripper {
+	if p.H2C && p.upstreamScheme() == "http" {
+		return h2cTransport(tr)
+	}
+
+	return tr
+}
+
 func (p *Proxy) rackDirector(r *http.Request) {
+	p.forwardHeaders(r.Header, r)
+
 	r.URL.Host = p.endpoint.Host
-	r.URL.Scheme = p.Target.Scheme
+	r.URL.Scheme = p.upstreamScheme()
 
-	r.Header.Add("X-Forwarded-For", r.RemoteAddr)
-	r.Header.Add("X-Forwarded-Port", p.Listen.Port())
-	r.Header.Add("X-Forwarded-Proto", p.Listen.Scheme)
-}
+	p.rewritePath(r.URL)
 
-func serviceTransport(app, service string, port int) http.RoundTripper {
-	tr := defaultTransport()
+	if !p.PreserveHost {
+		r.Host = p.endpoint.Host
+	}
 
-	tr.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
-		r, err := rack.NewFromEnv()
-		if err != nil {
-			return nil, err
-		}
+	p.forwardedDirector(r)
+	p.injectTrace(r.Context(), r.Header)
 
-		pss, err := r.ProcessList(app, types.ProcessListOptions{Service: service})
-		if err != nil {
-			return nil, err
-		}
+	ensureRequestID(r.Header)
+}
 
-		if len(pss) < 1 {
-			return nil, fmt.Errorf("no processes available for service: %s", service)
-		}
+// serviceRoundTripper layers logging, affinity and retries over the transport to a rack service
+func (p *Proxy) serviceRoundTripper(app, service string, port int) http.RoundTripper {
+	var rt http.RoundTripper
 
-		ps := pss[mrand.Intn(len(pss))]
+	rt = p.transport(p.serviceTransport(app, service, port))
 
-		a, b := net.Pipe()
+	if p.Pool.MaxLifetime > 0 {
+		rt = lifetimeTransport{RoundTripper: rt, lifetime: p.Pool.MaxLifetime}
+	}
+	rt = drainTransport{RoundTripper: rt, app: app, proxy: p, service: service}
+	rt = retryTransport{RoundTripper: rt, buffer: p.retryBufferBytes(), retries: p.retries()}
 
-		go serviceProxy(r, app, ps.Id, port, a)
+	if p.Breaker.Threshold > 0 {
+		rt = breakerTransport{RoundTripper: rt, breaker: p.breakers.get(fmt.Sprintf("%s/%s", app, service), p.Breaker)}
+	}
 
-		return b, nil
+	if p.Sticky.Enabled {
+		rt = stickyTransport{RoundTripper: rt, options: p.Sticky}
 	}
 
-	return tr
+	return logTransport{RoundTripper: rt, metrics: p.metrics}
 }
 
-func serviceProxy(rk rack.Rack, app, pid string, port int, rw io.ReadWriter) error {
-	pr, err := rk.ProcessProxy(app, pid, port, rw)
-	if err != nil {
-		return err
+func (p *Proxy) serviceTransport(app, service string, port int) *http.Transport {
+	tr := defaultTransport(p.transportOptions())
+
+	tr.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return p.dialService(ctx, app, service, port)
 	}
 
-	defer pr.Close()
+	p.Pool.pool(tr)
 
-	if _, err := io.Copy(rw, pr); err != nil {
-		return err
+	p.drain.onDrain(app, service, tr.CloseIdleConnections)
+
+	// pooled connections would bypass per request process selection
+	if p.Sticky.Enabled {
+		tr.DisableKeepAlives = true
 	}
 
-	return nil
+	return tr
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-}
+// dialService connects to one of the processes running service
+func (p *Proxy) dialService(ctx context.Context, app, service string, port int) (net.Conn, error) {
+	var err error
 
-func (p *Proxy) ws(app, service string, port int) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		frontend, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			fmt.Printf("ns=convox.router at=proxy type=ws.upgrader error=%q\n", err)
-			return
-		}
+	bs, ok := resolvedBackends(ctx)
 
-		dialer := &websocket.Dialer{
-			Proxy: http.ProxyFromEnvironment,
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+	if !ok {
+		if bs, err = p.awaitBackends(ctx, app, service); err != nil {
+			return nil, err
 		}
 
-		dialer.NetDial = func(network, address string) (net.Conn, error) {
-			r, err := rack.NewFromEnv()
-			if err != nil {
-				return nil, err
-			}
+		p.drain.observe(app, service, bs, p.OnBackendsChanged)
+	}
 
-			pss, err := r.ProcessList(app, types.ProcessListOptions{Service: service})
-			if err != nil {
-				return nil, err
-			}
+	if len(bs) < 1 {
+		return nil, noBackendsError{service: service}
+	}
 
-			if len(pss) < 1 {
-				return nil, fmt.Errorf("no processes available for service: %s", service)
-			}
+	healthy := p.health.Filter(bs)
 
-			ps := pss[mrand.Intn(len(pss))]
+	be := stickyBackend(ctx, healthy)
 
-			a, b := net.Pipe()
+	if be == nil {
+		be, err = p.balancer.Pick(balancerKey(app, service, port), healthy)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			go serviceProxy(r, app, ps.Id, port, a)
+	recordSticky(ctx, be)
 
-			return &nopDeadlineConn{b}, nil
+	if be.Address != "" {
+		cn, err := (&net.Dialer{Timeout: coalesceDuration(p.transportOptions().Timeouts.Dial, defaultTimeouts.Dial)}).DialContext(ctx, "tcp", be.Address)
+		if err != nil {
+			p.health.Fail(be.Id)
+			p.backendCache.invalidate(app, service)
+			return nil, dialError{err}
 		}
 
-		r.URL.Host = p.endpoint.Host
-		r.URL.Scheme = "wss"
+		return p.drain.track(be.Id, cn), nil
+	}
 
-		headers := http.Header{}
-		headers.Add("X-Forwarded-For", r.RemoteAddr)
-		headers.Add("X-Forwarded-Port", p.Listen.Port())
-		headers.Add("X-Forwarded-Proto", p.Listen.Scheme)
+	r, err := p.rack()
+	if err != nil {
+		return nil, err
+	}
 
-		for k, v := range r.Header {
-			// Websocket headers to skip as they are set by the dialer and duplicates aren't allowed
-			if k == "Upgrade" || k == "Connection" || k == "Sec-Websocket-Key" ||
-				k == "Sec-Websocket-Version" || k == "Sec-Websocket-Extensions" || k == "Sec-Websocket-Protocol" {
-				continue
-			}
-			for _, s := range v {
-				headers.Add(k, s)
-			}
-		}
+	a, b := net.Pipe()
+	upr, upw := io.Pipe()
 
-		backend, _, err := dialer.Dial(r.URL.String(), headers)
-		if err != nil {
-			fmt.Printf("ns=convox.router at=proxy type=ws.dial error=%q\n", err)
-			return
-		}
+	pr, err := r.ProcessProxy(app, be.Id, port, upr)
+	if err != nil {
+		p.resetRack()
+		p.health.Fail(be.Id)
+		p.backendCache.invalidate(app, service)
+		a.Close()
+		b.Close()
+		upw.Close()
+		return nil, dialError{err}
+	}
+
+	go func() {
+		err := serviceProxy(pr, a, upw, p.StreamIdleTimeout)
 
-		errc := make(chan error, 2)
-		cp := func(dst io.Writer, src io.Reader) {
-			_, err := io.Copy(dst, src)
-			errc <- err
+		switch {
+		case err == errStreamIdle:
+			p.metrics.error(err)
+			logger.Log("proxy", Fields{"type": "stream", "app": app, "service": service, "pid": be.Id, "error": err})
+		case err != nil && err != io.ErrClosedPipe:
+			p.metrics.error(err)
 		}
+	}()
 
-		go cp(frontend.UnderlyingConn(), backend.UnderlyingConn())
-		go cp(backend.UnderlyingConn(), frontend.UnderlyingConn())
+	return p.drain.track(be.Id, b), nil
+}
 
-		if err := <-errc; err != nil {
-			fmt.Printf("ns=convox.router at=proxy type=ws.cp error=%q\n", err)
-		}
+// errStreamIdle ends process proxy streams that moved no data for StreamIdleTimeout
+var errStreamIdle = fmt.Errorf("stream idle timeout")
+
+// serviceProxy copies client data from rw to the process through up and process
+// data from pr back to rw. When the client is done sending, up is closed so the
+// process sees EOF while its response keeps flowing; the rack sdk can only pass
+// that half-close on for streaming bodies, over websockets the process never sees it.
+// The first error, or the end of the response, closes everything, as does moving no data
+// in either direction for longer than a non-zero idle.
+func serviceProxy(pr io.ReadCloser, rw io.ReadWriteCloser, up io.WriteCloser, idle time.Duration) error {
+	defer rw.Close()
+	defer pr.Close()
+	defer up.Close()
+
+	var client, process io.Reader = rw, pr
+	var expired int32
+
+	if idle > 0 {
+		t := time.AfterFunc(idle, func() {
+			atomic.StoreInt32(&expired, 1)
+			rw.Close()
+			pr.Close()
+			up.Close()
+		})
+		defer t.Stop()
+
+		touch := func() { t.Reset(idle) }
+
+		client = activityReader{rw, touch}
+		process = activityReader{pr, touch}
+	}
+
+	type result struct {
+		up  bool
+		err error
+	}
+
+	rc := make(chan result, 2)
+
+	go func() {
+		_, err := io.Copy(up, client)
+		up.Close()
+		rc <- result{up: true, err: err}
+	}()
+
+	go func() {
+		_, err := io.Copy(rw, process)
+		rc <- result{err: err}
+	}()
+
+	r := <-rc
+
+	if r.err == nil && r.up {
+		r = <-rc
 	}
+
+	if atomic.LoadInt32(&expired) == 1 {
+		return errStreamIdle
+	}
+
+	return r.err
 }
@@ -0,0 +1,1979 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/convox/praxis/sdk/rack"
+	"github.com/convox/praxis/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProxySchemes(t *testing.T) {
+	tests := []struct {
+		listen string
+		target string
+		err    string
+	}{
+		{"http://0.0.0.0:80", "http://localhost:5000", ""},
+		{"http://0.0.0.0:80", "https://localhost:5000", ""},
+		{"https://0.0.0.0:443", "http://localhost:5000", ""},
+		{"https://0.0.0.0:443", "https://localhost:5000", ""},
+		{"tcp://0.0.0.0:5432", "tcp://localhost:5432", ""},
+		{"udp://0.0.0.0:53", "udp://localhost:53", ""},
+		{"http://0.0.0.0:80", "http://rack/app/service/web:3000", ""},
+		{"https://0.0.0.0:443", "https://rack/app/service/web:3000", ""},
+		{"tcp://0.0.0.0:5432", "tcp://rack/app/resource/db:5432", ""},
+		{"ftp://0.0.0.0:21", "tcp://localhost:21", "unknown listener scheme: ftp"},
+		{"tls://0.0.0.0:5432", "tcp://localhost:5432", "unknown listener scheme: tls"},
+		{"http://0.0.0.0:80", "tcp://localhost:5000", "can not proxy http listener to tcp target"},
+		{"https://0.0.0.0:443", "udp://localhost:5000", "can not proxy https listener to udp target"},
+		{"tcp://0.0.0.0:5432", "http://localhost:5432", "can not proxy tcp listener to http target"},
+		{"udp://0.0.0.0:53", "tcp://localhost:53", "can not proxy udp listener to tcp target"},
+		{"http://0.0.0.0:80", "http:///path", "target has no host: http:///path"},
+		{"udp://0.0.0.0:53", "udp://rack/app/resource/dns:53", "rack targets not supported for udp listener"},
+		{"http://0.0.0.0:80", "http://rack/app/service", "invalid rack target http://rack/app/service: missing service"},
+		{"http://0.0.0.0:80", "http://rack/app/service/web", "invalid rack target http://rack/app/service/web: missing port"},
+		{"http://0.0.0.0:80", "http://rack//service/web:3000", "invalid rack target http://rack//service/web:3000: missing app"},
+		{"http://0.0.0.0:80", "http://rack/app/resource/db:5432", "can not proxy http listener to rack resource"},
+		{"tcp://0.0.0.0:5432", "tcp://rack/app/service/web:3000", "can not proxy tcp listener to rack service"},
+		{"http://0.0.0.0:80", "http://localhost:5000?bind=127.0.0.1", ""},
+		{"http://0.0.0.0:80", "http://localhost:5000?bind=::1", ""},
+		{"http://0.0.0.0:80", "http://localhost:5000?bind=localhost", "invalid bind address: localhost"},
+		{"http://0.0.0.0:80", "unix:///nonexistent/web.sock", "socket does not exist: /nonexistent/web.sock"},
+		{"tcp://0.0.0.0:5432", "unix://", "target has no socket path: unix:"},
+		{"udp://0.0.0.0:53", "unix:///tmp/dns.sock", "can not proxy udp listener to unix target"},
+		{"http://0.0.0.0:80", "http://rack/app/service/web:3000?route=/api%3D/app/service/api:3000", ""},
+		{"http://0.0.0.0:80", "http://localhost:5000?route=/api%3D/app/service/api:3000", "route rules require a rack target"},
+		{"http://0.0.0.0:80", "http://rack/app/service/web:3000?route=api%3D/app/service/api:3000", "invalid route path prefix: api"},
+		{"http://0.0.0.0:80", "http://rack/app/service/web:3000?weight=90&split=/app/service/canary:3000=10", ""},
+		{"http://0.0.0.0:80", "http://localhost:5000?split=/app/service/canary:3000=10", "traffic splitting requires a rack target"},
+		{"http://0.0.0.0:80", "http://rack/app/service/web:3000?split=/app/resource/db:5432=10", "can only split traffic between rack services: http://rack/app/resource/db:5432"},
+		{"http://0.0.0.0:80", "http://rack/app/service/web:3000?weight=0&split=/app/service/canary:3000=10", "invalid weight for http://rack/app/service/web:3000: 0"},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+		listen, err := url.Parse(tt.listen)
+		assert.NoError(t, err)
+
+		target, err := url.Parse(tt.target)
+		assert.NoError(t, err)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+
+		if tt.err == "" {
+			assert.NoError(t, err, "%s -> %s", tt.listen, tt.target)
+			assert.NotNil(t, p)
+		} else if assert.Error(t, err, "%s -> %s", tt.listen, tt.target) {
+			assert.Equal(t, tt.err, err.Error())
+		}
+	}
+}
+
+func TestParseRackTarget(t *testing.T) {
+	u, err := url.Parse("http://rack/app/service/web:3000")
+	assert.NoError(t, err)
+
+	app, kind, name, port, err := parseRackTarget(u)
+	assert.NoError(t, err)
+	assert.Equal(t, "app", app)
+	assert.Equal(t, "service", kind)
+	assert.Equal(t, "web", name)
+	assert.Equal(t, 3000, port)
+
+	tests := []struct {
+		target string
+		err    string
+	}{
+		{"tcp://rack", "invalid rack target tcp://rack: missing app"},
+		{"tcp://rack/", "invalid rack target tcp://rack/: missing app"},
+		{"tcp://rack//resource/db:5432", "invalid rack target tcp://rack//resource/db:5432: missing app"},
+		{"tcp://rack/app", "invalid rack target tcp://rack/app: missing kind"},
+		{"tcp://rack/app//db:5432", "invalid rack target tcp://rack/app//db:5432: missing kind"},
+		{"tcp://rack/app/resource", "invalid rack target tcp://rack/app/resource: missing resource"},
+		{"tcp://rack/app/resource/:5432", "invalid rack target tcp://rack/app/resource/:5432: missing resource"},
+		{"tcp://rack/app/resource/db", "invalid rack target tcp://rack/app/resource/db: missing port"},
+		{"tcp://rack/app/resource/db:", "invalid rack target tcp://rack/app/resource/db:: missing port"},
+		{"tcp://rack/app/resource/db:pg", "invalid rack target tcp://rack/app/resource/db:pg: invalid port: pg"},
+		{"tcp://rack/app/resource/db:0", "invalid rack target tcp://rack/app/resource/db:0: invalid port: 0"},
+		{"tcp://rack/app/resource/db:70000", "invalid rack target tcp://rack/app/resource/db:70000: invalid port: 70000"},
+		{"tcp://rack/app/resource/db:5432/extra", "invalid rack target tcp://rack/app/resource/db:5432/extra: too many path segments"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.target)
+		assert.NoError(t, err)
+
+		_, _, _, _, err = parseRackTarget(u)
+
+		if assert.Error(t, err, tt.target) {
+			assert.Equal(t, tt.err, err.Error())
+		}
+	}
+}
+
+func TestServiceRoundTripperResolver(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s", r.URL.Path)
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p.Resolver = StaticResolver{
+		"app/web": {{Id: "web-1", Address: backend.Listener.Addr().String()}},
+	}
+
+	req, err := http.NewRequest("GET", "http://web.app.test/foo", nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	res, err := p.serviceRoundTripper("app", "web", 3000).RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello /foo", string(data))
+
+	_, err = p.serviceRoundTripper("app", "worker", 3000).RoundTrip(req)
+	assert.EqualError(t, err, "no backends for service: app/worker")
+}
+
+func TestProxySizeLimits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s", strings.Repeat("x", len(data)))
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?max_request_bytes=10&max_response_bytes=5")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{"abcde", http.StatusOK},
+		{"abcdef", http.StatusBadGateway},
+		{"abcdefghijk", http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(tt.body)))
+		assert.Equal(t, tt.status, w.Code, tt.body)
+	}
+}
+
+func TestProxyUnixTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "praxis-router")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	sock := filepath.Join(dir, "web.sock")
+
+	ln, err := net.Listen("unix", sock)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %s %s", r.Host, r.URL.Path)
+	}))
+	backend.Listener = ln
+	backend.Start()
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("unix://" + sock)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://web.test.convox/foo", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "hello web.test.convox /foo", w.Body.String())
+}
+
+func TestProxyRouteRules(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s %s", name, r.Method, r.URL.Path)
+		}))
+	}
+
+	web, api, upload := backend("web"), backend("api"), backend("upload")
+	defer web.Close()
+	defer api.Close()
+	defer upload.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000")
+
+	target.RawQuery = url.Values{"route": {"/api=/app/service/api:3000", "POST,PUT /api/upload=/app/service/upload:3000"}}.Encode()
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p.Resolver = StaticResolver{
+		"app/web":    {{Id: "web-1", Address: web.Listener.Addr().String()}},
+		"app/api":    {{Id: "api-1", Address: api.Listener.Addr().String()}},
+		"app/upload": {{Id: "upload-1", Address: upload.Listener.Addr().String()}},
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"GET", "/", "web GET /"},
+		{"GET", "/apis", "web GET /apis"},
+		{"GET", "/api", "api GET /api"},
+		{"GET", "/api/users", "api GET /api/users"},
+		{"GET", "/api/upload", "api GET /api/upload"},
+		{"POST", "/api/uploads", "api POST /api/uploads"},
+		{"POST", "/api/upload/file", "upload POST /api/upload/file"},
+		{"DELETE", "/other", "web DELETE /other"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tt.method, "http://web.app.test"+tt.path, nil))
+		assert.Equal(t, tt.body, w.Body.String(), "%s %s", tt.method, tt.path)
+	}
+}
+
+func TestServiceDrainsRemovedBackends(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok")
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000?backend_cache_ttl=off")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	resolver := StaticResolver{
+		"app/web": {{Id: "web-1", Address: backend.Listener.Addr().String()}},
+	}
+
+	p.Resolver = resolver
+
+	removed := make(chan []Backend, 1)
+
+	p.OnBackendsChanged = func(app, service string, added, gone []Backend) {
+		removed <- gone
+	}
+
+	rt := p.serviceRoundTripper("app", "web", 3000)
+
+	req, _ := http.NewRequest("GET", "http://web.app.test/", nil)
+
+	res, err := rt.RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]int64{"web-1": 1}, p.BackendConnections())
+
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	resolver["app/web"] = []Backend{{Id: "web-2", Address: backend.Listener.Addr().String()}}
+
+	res, err = rt.RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	select {
+	case gone := <-removed:
+		assert.Equal(t, []Backend{{Id: "web-1", Address: backend.Listener.Addr().String()}}, gone)
+	case <-time.After(time.Second):
+		t.Error("backend change not reported")
+	}
+
+	assert.Equal(t, map[string]int64{"web-2": 1}, p.BackendConnections())
+}
+
+func TestServiceResolvesOncePerRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok")
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000?backend_cache_ttl=off")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	resolver := &countingResolver{backends: []Backend{{Id: "web-1", Address: backend.Listener.Addr().String()}}}
+
+	p.Resolver = resolver
+
+	rt := p.serviceRoundTripper("app", "web", 3000)
+
+	for i := 1; i <= 3; i++ {
+		req, _ := http.NewRequest("GET", "http://web.app.test/", nil)
+		req.Close = true
+
+		res, err := rt.RoundTrip(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		assert.Equal(t, i, resolver.count())
+	}
+}
+
+// testRack answers process proxies with a canned http response
+type testRack struct {
+	rack.Rack
+
+	lock      sync.Mutex
+	processes types.Processes
+}
+
+func (r *testRack) add(ps types.Process) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.processes = append(r.processes, ps)
+}
+
+func (r *testRack) ProcessList(app string, opts types.ProcessListOptions) (types.Processes, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	pss := types.Processes{}
+
+	for _, ps := range r.processes {
+		if opts.Release == "" || ps.Release == opts.Release {
+			pss = append(pss, ps)
+		}
+	}
+
+	return pss, nil
+}
+
+func (r *testRack) ProcessProxy(app, pid string, port int, in io.Reader) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		req, err := http.ReadRequest(bufio.NewReader(in))
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		body := fmt.Sprintf("%s %s:%d %s", app, pid, port, req.URL.Path)
+
+		fmt.Fprintf(pw, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+func TestRackResolverRelease(t *testing.T) {
+	tr := &testRack{processes: types.Processes{
+		{Id: "web-1", Service: "web", Release: "RBLUE"},
+		{Id: "web-2", Service: "web", Release: "RGREEN"},
+	}}
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("https://0.0.0.0:443")
+	target, _ := url.Parse("https://rack/app/service/web:3000?release=RGREEN")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p.Rack = func() (rack.Rack, error) { return tr, nil }
+
+	bs, err := p.resolver().Resolve("app", "web")
+	assert.NoError(t, err)
+	assert.Equal(t, []Backend{{Id: "web-2"}}, bs)
+
+	p.Release = "RMISSING"
+
+	_, err = p.resolver().Resolve("app", "web")
+	assert.Equal(t, noBackendsError{service: "web", release: "RMISSING"}, err)
+
+	p.Release = ""
+
+	bs, err = p.resolver().Resolve("app", "web")
+	assert.NoError(t, err)
+	assert.Len(t, bs, 2)
+}
+
+func TestServiceRoundTripperRack(t *testing.T) {
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p.Rack = func() (rack.Rack, error) {
+		return &testRack{processes: types.Processes{{Id: "web-1", Service: "web"}}}, nil
+	}
+
+	req, _ := http.NewRequest("GET", "http://web.app.test/foo", nil)
+
+	res, err := p.serviceRoundTripper("app", "web", 3000).RoundTrip(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "app web-1:3000 /foo", string(data))
+}
+
+func TestProxySecurityHeaders(t *testing.T) {
+	tests := []struct {
+		listen  string
+		options string
+		backend http.Header
+		want    http.Header
+	}{
+		{"http://0.0.0.0:80", "", nil, http.Header{}},
+		{
+			"http://0.0.0.0:80", "security_headers=true", nil,
+			http.Header{"X-Content-Type-Options": {"nosniff"}, "X-Frame-Options": {"SAMEORIGIN"}, "Referrer-Policy": {"strict-origin-when-cross-origin"}},
+		},
+		{
+			"https://0.0.0.0:443", "security_headers=true&frame_options=off&csp=default-src+%27self%27", http.Header{"Referrer-Policy": {"no-referrer"}},
+			http.Header{"Strict-Transport-Security": {"max-age=31536000; includeSubDomains"}, "X-Content-Type-Options": {"nosniff"}, "Referrer-Policy": {"no-referrer"}, "Content-Security-Policy": {"default-src 'self'"}},
+		},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse(tt.listen)
+		target, _ := url.Parse("http://localhost:5000?" + tt.options)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		res := &http.Response{Header: http.Header{}}
+
+		for k, v := range tt.backend {
+			res.Header[k] = v
+		}
+
+		assert.NoError(t, p.securityHeaders(res))
+		assert.Equal(t, tt.want, res.Header, tt.options)
+	}
+}
+
+func TestProxyStreamsResponses(t *testing.T) {
+	next := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			<-next
+		}
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?gzip=true&gzip_types=text/event-stream")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	res, err := http.Get(s.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+
+	r := bufio.NewReader(res.Body)
+
+	for i := 0; i < 3; i++ {
+		line := make(chan string, 1)
+
+		go func() {
+			l, _ := r.ReadString('\n')
+			r.ReadString('\n')
+			line <- l
+		}()
+
+		select {
+		case l := <-line:
+			assert.Equal(t, fmt.Sprintf("data: %d\n", i), l)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %d was not streamed", i)
+		}
+
+		next <- struct{}{}
+	}
+}
+
+// streamingRack answers process proxies with an event stream, sending an event each time next receives
+type streamingRack struct {
+	rack.Rack
+
+	next chan struct{}
+}
+
+func (r *streamingRack) ProcessList(app string, opts types.ProcessListOptions) (types.Processes, error) {
+	return types.Processes{{Id: "web-1", Service: "web"}}, nil
+}
+
+func (r *streamingRack) ProcessProxy(app, pid string, port int, in io.Reader) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		if _, err := http.ReadRequest(bufio.NewReader(in)); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+
+		fmt.Fprintf(pw, "HTTP/1.1 200 OK\r\nContent-Type: text/event-stream\r\nConnection: close\r\n\r\n")
+
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(pw, "data: %d\n\n", i)
+			<-r.next
+		}
+
+		pw.Close()
+	}()
+
+	return pr, nil
+}
+
+func TestProxyRackStreamsEvents(t *testing.T) {
+	sr := &streamingRack{next: make(chan struct{})}
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000?flush_interval=1h")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p.Rack = func() (rack.Rack, error) { return sr, nil }
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	res, err := http.Get(s.URL + "/events")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+
+	r := bufio.NewReader(res.Body)
+
+	for i := 0; i < 3; i++ {
+		line := make(chan string, 1)
+
+		go func() {
+			l, _ := r.ReadString('\n')
+			r.ReadString('\n')
+			line <- l
+		}()
+
+		select {
+		case l := <-line:
+			assert.Equal(t, fmt.Sprintf("data: %d\n", i), l)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("event %d was not streamed", i)
+		}
+
+		sr.next <- struct{}{}
+	}
+}
+
+func TestProxyFlushInterval(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+
+	// responses other than streams are not flushed on every write by default
+	p, err := e.NewProxy(e.Host, listen, &url.URL{Scheme: "http", Host: "localhost:5000"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, time.Duration(0), p.FlushInterval)
+	}
+
+	e = &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	target, _ := url.Parse("http://localhost:5000?flush_interval=250ms")
+
+	p, err = e.NewProxy(e.Host, listen, target)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 250*time.Millisecond, p.FlushInterval)
+	}
+}
+
+func TestServiceProxyIdleTimeout(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	_, upw := io.Pipe()
+
+	errc := make(chan error, 1)
+
+	go func() { errc <- serviceProxy(pr, a, upw, 100*time.Millisecond) }()
+
+	// traffic keeps the stream open past the timeout
+	for i := 0; i < 3; i++ {
+		time.Sleep(50 * time.Millisecond)
+
+		go pw.Write([]byte("x"))
+
+		buf := make([]byte, 1)
+		_, err := b.Read(buf)
+		assert.NoError(t, err)
+	}
+
+	select {
+	case err := <-errc:
+		assert.Equal(t, errStreamIdle, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle stream was not closed")
+	}
+
+	_, err := b.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+// failingTransport fails the first dials and then echoes the request body
+type failingTransport struct {
+	failures int
+	attempts *int
+}
+
+func (t failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	*t.attempts++
+
+	var data []byte
+
+	if req.Body != nil {
+		data, _ = ioutil.ReadAll(req.Body)
+	}
+
+	if *t.attempts <= t.failures {
+		return nil, dialError{fmt.Errorf("connection refused")}
+	}
+
+	return &http.Response{StatusCode: 200, Body: ioutil.NopCloser(strings.NewReader(string(data)))}, nil
+}
+
+func TestRetryIdempotencyKey(t *testing.T) {
+	tests := []struct {
+		key      string
+		body     string
+		attempts int
+		err      bool
+	}{
+		{"", "hello", 1, true},
+		{"abc", "hello", 2, false},
+		{"abc", "too large body", 1, true},
+	}
+
+	for _, tt := range tests {
+		attempts := 0
+
+		rt := retryTransport{RoundTripper: failingTransport{failures: 1, attempts: &attempts}, buffer: 10, retries: 2}
+
+		req, _ := http.NewRequest("POST", "http://web.app.test/", ioutil.NopCloser(strings.NewReader(tt.body)))
+
+		if tt.key != "" {
+			req.Header.Set("Idempotency-Key", tt.key)
+		}
+
+		res, err := rt.RoundTrip(req)
+
+		assert.Equal(t, tt.attempts, attempts, "%s %s", tt.key, tt.body)
+
+		if tt.err {
+			assert.Error(t, err)
+			continue
+		}
+
+		if assert.NoError(t, err) {
+			data, _ := ioutil.ReadAll(res.Body)
+			assert.Equal(t, tt.body, string(data))
+		}
+	}
+}
+
+func TestProxyForwardedHeaders(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%q %q %q", r.Header["X-Forwarded-For"], r.Header["X-Forwarded-Proto"], r.Header.Get("X-Forwarded-Host"))
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		mode string
+		body string
+	}{
+		{"", `["10.0.0.1, 192.168.0.1:5000, 192.168.0.1"] ["https" "http"] "web.app.test"`},
+		{"append", `["10.0.0.1, 192.168.0.1:5000, 192.168.0.1"] ["https" "http"] "web.app.test"`},
+		{"replace", `["192.168.0.1"] ["http"] "web.app.test"`},
+		{"off", `["10.0.0.1"] ["https"] ""`},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse("http://rack/app/service/web:3000?forwarded_headers=" + tt.mode)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		p.Resolver = StaticResolver{"app/web": {{Id: "web-1", Address: backend.Listener.Addr().String()}}}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		r := httptest.NewRequest("GET", "http://web.app.test/", nil)
+		r.RemoteAddr = "192.168.0.1:5000"
+		r.Header.Set("X-Forwarded-For", "10.0.0.1")
+		r.Header.Set("X-Forwarded-Proto", "https")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tt.body, w.Body.String(), tt.mode)
+	}
+}
+
+func TestProxyRewriterForwardedFor(t *testing.T) {
+	tests := []struct {
+		mode   string
+		header http.Header
+	}{
+		{"append", http.Header{"X-Forwarded-For": {"10.0.0.1, 192.168.0.1"}, "X-Forwarded-Proto": {"https"}}},
+		{"replace", http.Header{"X-Forwarded-For": {"192.168.0.1"}, "X-Forwarded-Proto": {"https"}}},
+		{"off", http.Header{"X-Forwarded-For": {"10.0.0.1"}, "X-Forwarded-Proto": {"https"}}},
+	}
+
+	for _, tt := range tests {
+		p := &Proxy{ForwardedHeaders: tt.mode}
+
+		in := httptest.NewRequest("GET", "http://web.app.test/", nil)
+		in.RemoteAddr = "192.168.0.1:5000"
+		in.Header.Set("X-Forwarded-For", "10.0.0.1")
+		in.Header.Set("X-Forwarded-Proto", "https")
+
+		// the reverse proxy hands Rewrite a copy without the forwarded headers
+		out := in.Clone(in.Context())
+		out.Header = http.Header{}
+
+		p.rewriter(p.forwardedDirector)(&httputil.ProxyRequest{In: in, Out: out})
+
+		assert.Equal(t, tt.header, out.Header, tt.mode)
+	}
+}
+
+func TestProxyWebsocketRejectsPlainRequests(t *testing.T) {
+	hits := 0
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p.Resolver = StaticResolver{"app/web": {{Id: "web-1", Address: backend.Listener.Addr().String()}}}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var log bytes.Buffer
+
+	SetLogger(NewJSONLogger(&log))
+	defer SetLogger(NewLogfmtLogger(os.Stderr))
+
+	r := httptest.NewRequest("GET", "http://web.app.test/socket", nil)
+	r.Header.Set("Upgrade", "websocket")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "13", w.Header().Get("Sec-Websocket-Version"))
+	assert.Equal(t, "Bad Request\n", w.Body.String())
+	assert.Equal(t, 0, hits)
+
+	var event map[string]interface{}
+
+	if assert.NoError(t, json.NewDecoder(&log).Decode(&event)) {
+		assert.Equal(t, "handshake", event["phase"])
+		assert.Equal(t, "app", event["app"])
+		assert.Equal(t, "web", event["service"])
+		assert.Equal(t, float64(3000), event["port"])
+		assert.Contains(t, event["error"], "missing upgrade headers")
+	}
+}
+
+func TestProxyActiveHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(fmt.Sprintf("http://%s?health_check_interval=10ms", addr))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, p.activeHealthCheck())
+	assert.True(t, p.Healthy())
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go p.checkHealth(done)
+
+	assert.True(t, waitFor(func() bool { return !p.Healthy() }))
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://web.app.test/", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	ln, err = net.Listen("tcp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ln.Close()
+
+	assert.True(t, waitFor(p.Healthy))
+}
+
+// waitFor polls cond for up to a second
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	return false
+}
+
+// flakyResourceRack fails resource proxies until failures runs out, then echoes the client
+type flakyResourceRack struct {
+	rack.Rack
+
+	failures int
+	attempts int
+}
+
+func (r *flakyResourceRack) ResourceProxy(app, resource string, in io.Reader) (io.ReadCloser, error) {
+	r.attempts++
+
+	if r.failures > 0 {
+		r.failures--
+		return nil, fmt.Errorf("resource unavailable")
+	}
+
+	return ioutil.NopCloser(in), nil
+}
+
+func TestProxyRackTCPRetries(t *testing.T) {
+	for _, failures := range []int{2, 3} {
+		e := &Endpoint{Host: "db.app.test", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("tcp://0.0.0.0:5432")
+		target, _ := url.Parse("tcp://rack/app/resource/db:5432?tcp_connect_retries=2&tcp_connect_backoff=1ms")
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		fr := &flakyResourceRack{failures: failures}
+
+		p.Rack = func() (rack.Rack, error) { return fr, nil }
+
+		client, server := net.Pipe()
+
+		done := make(chan error, 1)
+
+		go func() { done <- p.proxyRackTCP(server, p.Target) }()
+
+		if failures > 2 {
+			assert.EqualError(t, <-done, "resource unavailable")
+			assert.Equal(t, 3, fr.attempts)
+			client.Close()
+			continue
+		}
+
+		client.Write([]byte("ping"))
+
+		buf := make([]byte, 4)
+
+		_, err = io.ReadFull(client, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+		assert.Equal(t, 3, fr.attempts)
+
+		client.Close()
+		<-done
+	}
+}
+
+// replicaRack fails resource proxies to the down replicas and echoes the client on the others
+type replicaRack struct {
+	rack.Rack
+
+	down     map[string]bool
+	attempts []string
+}
+
+func (r *replicaRack) ResourceProxy(app, resource string, in io.Reader) (io.ReadCloser, error) {
+	r.attempts = append(r.attempts, resource)
+
+	if r.down[resource] {
+		return nil, fmt.Errorf("resource unavailable: %s", resource)
+	}
+
+	return ioutil.NopCloser(in), nil
+}
+
+func TestProxyRackTCPReplicas(t *testing.T) {
+	e := &Endpoint{Host: "db.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://0.0.0.0:5432")
+	target, _ := url.Parse("tcp://rack/app/resource/db:5432?replica=db-b,db-c&tcp_connect_retries=off")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rr := &replicaRack{down: map[string]bool{"db-b": true}}
+
+	p.Rack = func() (rack.Rack, error) { return rr, nil }
+
+	// connections start on successive replicas and fail over past db-b
+	for _, want := range [][]string{{"db-b", "db-c"}, {"db-c"}, {"db"}} {
+		rr.attempts = nil
+
+		client, server := net.Pipe()
+
+		done := make(chan error, 1)
+
+		go func() { done <- p.proxyRackTCP(server, p.Target) }()
+
+		client.Write([]byte("ping"))
+
+		buf := make([]byte, 4)
+
+		_, err = io.ReadFull(client, buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+
+		client.Close()
+		<-done
+
+		assert.Equal(t, want, rr.attempts)
+	}
+
+	rr.down = map[string]bool{"db": true, "db-b": true, "db-c": true}
+	rr.attempts = nil
+
+	client, server := net.Pipe()
+	defer client.Close()
+
+	assert.EqualError(t, p.proxyRackTCP(server, p.Target), "resource unavailable: db")
+	assert.Equal(t, []string{"db-b", "db-c", "db"}, rr.attempts)
+}
+
+// temporaryError is an accept error that net/http style loops retry
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails its first accepts with temporary errors
+type flakyListener struct {
+	net.Listener
+	failures int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.failures, -1) >= 0 {
+		return nil, temporaryError{}
+	}
+
+	return l.Listener.Accept()
+}
+
+func TestProxyTCPAcceptBackoff(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer backend.Close()
+
+	go func() {
+		for {
+			cn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(cn, cn)
+		}
+	}()
+
+	e := &Endpoint{Host: "tcp.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://127.0.0.1:0")
+	target, _ := url.Parse(fmt.Sprintf("tcp://%s", backend.Addr()))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	fl := &flakyListener{Listener: ln, failures: 3}
+
+	done := make(chan error, 1)
+
+	go func() { done <- p.proxyTCP(fl, p.Target) }()
+
+	cn, err := net.Dial("tcp", ln.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cn.Close()
+
+	cn.Write([]byte("ping"))
+
+	buf := make([]byte, 4)
+
+	_, err = io.ReadFull(cn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+
+	// permanent errors still end the loop
+	ln.Close()
+
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("accept loop did not stop")
+	}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	var d time.Duration
+
+	for _, want := range []time.Duration{5, 10, 20, 40, 80, 160, 320, 640, 1000, 1000} {
+		d = acceptBackoff(d)
+		assert.Equal(t, want*time.Millisecond, d)
+	}
+}
+
+func TestProxyActiveConnections(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer backend.Close()
+
+	go func() {
+		for {
+			cn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(cn, cn)
+		}
+	}()
+
+	e := &Endpoint{Host: "tcp.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://127.0.0.1:0")
+	target, _ := url.Parse(fmt.Sprintf("tcp://%s", backend.Addr()))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go p.proxyTCP(registeredListener{Listener: ln, registry: &p.registry}, p.Target)
+	defer ln.Close()
+
+	cn, err := net.Dial("tcp", ln.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cn.Close()
+
+	cn.Write([]byte("ping"))
+
+	buf := make([]byte, 4)
+
+	_, err = io.ReadFull(cn, buf)
+	assert.NoError(t, err)
+
+	cs := p.ActiveConnections()
+
+	if assert.Len(t, cs, 1) {
+		assert.Equal(t, cn.LocalAddr().String(), cs[0].Remote)
+		assert.Equal(t, p.Target.String(), cs[0].Target)
+		assert.Equal(t, int64(4), cs[0].BytesIn)
+	}
+
+	assert.EqualError(t, p.CloseConnection("missing"), "no such connection: missing")
+	assert.NoError(t, p.CloseConnection(cs[0].Id))
+
+	_, err = cn.Read(buf)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Len(t, p.ActiveConnections(), 0)
+}
+
+func TestProxyExpectContinue(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a slow backend only asks for the body once it is ready for it
+		time.Sleep(200 * time.Millisecond)
+
+		data, _ := ioutil.ReadAll(r.Body)
+
+		fmt.Fprintf(w, "%s %s", r.Header.Get("Expect"), data)
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p.Resolver = StaticResolver{"app/web": {{Id: "web-1", Address: backend.Listener.Addr().String()}}}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+
+	req, _ := http.NewRequest("POST", s.URL, strings.NewReader("upload"))
+	req.Header.Set("Expect", "100-continue")
+
+	start := time.Now()
+
+	res, err := client.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "100-continue upload", string(data))
+
+	// without the interim response the client only sends the body after its timeout
+	assert.True(t, time.Since(start) < 2*time.Second, "waited %s for 100 Continue", time.Since(start))
+}
+
+func TestNewProxyWithListener(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL)
+
+	p, err := e.NewProxyWithListener(e.Host, listen, target, ln)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go p.Serve()
+
+	res, err := http.Get(fmt.Sprintf("http://%s/", ln.Addr()))
+	if assert.NoError(t, err) {
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, "ok", string(data))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	assert.NoError(t, p.Shutdown(ctx))
+
+	udp, _ := url.Parse("udp://0.0.0.0:53")
+
+	_, err = e.NewProxyWithListener(e.Host, udp, target, ln)
+	assert.EqualError(t, err, "udp proxies can not use a stream listener")
+}
+
+func TestProxyErrorPages(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	tmpl := filepath.Join(dir, "error.json")
+
+	assert.NoError(t, ioutil.WriteFile(tmpl, []byte(`{"code":{{.Status}},"id":"{{.RequestID}}"}`), 0644))
+
+	tests := []struct {
+		accept  string
+		options string
+		ctype   string
+		body    string
+	}{
+		{"", "", "text/plain; charset=utf-8", "could not reach backend\n"},
+		{"application/json", "", "application/json", `{"status":502,"error":"Bad Gateway","message":"could not reach backend","request_id":"req-1"}` + "\n"},
+		{"text/html,application/xhtml+xml", "", "text/html; charset=utf-8", "<h1>502 Bad Gateway</h1>"},
+		{"application/json", "?error_page_json=error.json", "application/json", `{"code":502,"id":"req-1"}`},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}, router: &Router{ErrorPagesDir: dir}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse(fmt.Sprintf("http://%s%s", addr, tt.options))
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		r := httptest.NewRequest("GET", "http://web.app.test/", nil)
+		r.Header.Set("Accept", tt.accept)
+		r.Header.Set(RequestIDHeader, "req-1")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Equal(t, tt.ctype, w.Header().Get("Content-Type"))
+		assert.Equal(t, "req-1", w.Header().Get(RequestIDHeader))
+		assert.Contains(t, w.Body.String(), tt.body)
+	}
+}
+
+func TestProxyErrorPageTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "pages"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "pages", "error.html"), []byte(`<p>{{.Status}}</p>`), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secret"), []byte(`secret`), 0644))
+
+	tests := []struct {
+		dir   string
+		name  string
+		valid bool
+	}{
+		{filepath.Join(dir, "pages"), "error.html", true},
+		{filepath.Join(dir, "pages"), filepath.Join(dir, "secret"), false},
+		{filepath.Join(dir, "pages"), "../secret", false},
+		{filepath.Join(dir, "pages"), "..", false},
+		{filepath.Join(dir, "pages"), "missing.html", false},
+		{"", "error.html", false},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}, router: &Router{ErrorPagesDir: tt.dir}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse("http://localhost:5000?error_page_html=" + url.QueryEscape(tt.name))
+
+		p, err := e.NewProxy(e.Host, listen, target)
+
+		if tt.valid {
+			if assert.NoError(t, err, tt.name) {
+				assert.NotNil(t, p.ErrorPages.HTML)
+			}
+		} else {
+			assert.Error(t, err, "%s in %q", tt.name, tt.dir)
+		}
+	}
+}
+
+func TestProxyListenPortZero(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://127.0.0.1:0")
+	target, _ := url.Parse(backend.URL)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// port 0 proxies do not claim the port on the endpoint
+	_, err = e.NewProxy(e.Host, listen, target)
+	assert.NoError(t, err)
+
+	assert.Nil(t, p.Addr())
+
+	go p.Serve()
+
+	select {
+	case <-p.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("proxy did not become ready")
+	}
+
+	defer p.Shutdown(context.Background())
+
+	_, port, err := net.SplitHostPort(p.Addr().String())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NotEqual(t, "0", port)
+	assert.Equal(t, port, p.listenPort())
+
+	// once listening it is tracked under its port like other proxies
+	pi, _ := strconv.Atoi(port)
+	assert.Equal(t, p, e.Proxies[pi])
+	assert.NotContains(t, e.Proxies, 0)
+
+	res, err := http.Get(fmt.Sprintf("http://%s/", p.Addr()))
+	if assert.NoError(t, err) {
+		data, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, "ok", string(data))
+	}
+}
+
+func TestProxyMaxHeaderBytes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://127.0.0.1:0")
+	target, _ := url.Parse(backend.URL + "?max_header_bytes=4096")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 4096, p.MaxHeaderBytes)
+
+	go p.Serve()
+
+	select {
+	case <-p.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("proxy did not become ready")
+	}
+
+	defer p.Shutdown(context.Background())
+
+	tests := []struct {
+		size   int
+		status int
+	}{
+		{100, http.StatusOK},
+		{16 * 1024, http.StatusRequestHeaderFieldsTooLarge},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("http://%s/", p.Addr()), nil)
+		req.Header.Set("X-Large", strings.Repeat("a", tt.size))
+
+		res, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err, tt.size) {
+			continue
+		}
+		res.Body.Close()
+
+		assert.Equal(t, tt.status, res.StatusCode, tt.size)
+	}
+}
+
+func TestProxyReadyServeError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer taken.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://" + taken.Addr().String())
+	target, _ := url.Parse("tcp://127.0.0.1:1")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	served := make(chan error, 1)
+
+	go func() { served <- p.Serve() }()
+
+	select {
+	case <-p.Ready():
+	case <-time.After(time.Second):
+		t.Fatal("ready not closed when serve failed")
+	}
+
+	assert.Nil(t, p.Addr())
+	assert.Error(t, p.Err())
+	assert.Equal(t, p.Err(), <-served)
+}
+
+func TestProxyCompressionRangesAndETags(t *testing.T) {
+	content := strings.Repeat("compressible text ", 200)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?gzip=true")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	tests := []struct {
+		rng      string
+		status   int
+		encoding string
+		etag     string
+		body     string
+	}{
+		{"", http.StatusOK, "gzip", `W/"v1"`, content},
+		{"bytes=0-9", http.StatusPartialContent, "", `"v1"`, content[0:10]},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "http://web.app.test/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+
+		if tt.rng != "" {
+			r.Header.Set("Range", tt.rng)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		assert.Equal(t, tt.status, w.Code, tt.rng)
+		assert.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"), tt.rng)
+		assert.Equal(t, tt.etag, w.Header().Get("ETag"), tt.rng)
+
+		body := w.Body.String()
+
+		if tt.encoding == "gzip" {
+			gz, err := gzip.NewReader(w.Body)
+			if !assert.NoError(t, err) {
+				continue
+			}
+
+			data, _ := ioutil.ReadAll(gz)
+			body = string(data)
+		}
+
+		assert.Equal(t, tt.body, body, tt.rng)
+	}
+}
+
+func TestRewritePrefix(t *testing.T) {
+	tests := []struct {
+		path  string
+		strip string
+		add   string
+		want  string
+	}{
+		{"/service", "/service", "", "/"},
+		{"/service/", "/service", "", "/"},
+		{"/service/users", "/service/", "", "/users"},
+		{"/service/users/", "/service", "", "/users/"},
+		{"/services", "/service", "", "/services"},
+		{"/other", "/service", "", "/other"},
+		{"/", "", "/api", "/api/"},
+		{"", "", "/api/", "/api/"},
+		{"/users", "", "/api/", "/api/users"},
+		{"/service/users", "/service", "/api", "/api/users"},
+		{"/service", "/service", "/api", "/api/"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, rewritePrefix(tt.path, tt.strip, tt.add), "%s strip=%s add=%s", tt.path, tt.strip, tt.add)
+	}
+}
+
+func TestProxyPathPrefixes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s?%s", r.URL.EscapedPath(), r.URL.RawQuery)
+	}))
+	defer backend.Close()
+
+	addr := backend.Listener.Addr().String()
+
+	for target, base := range map[string]string{"http://rack/app/service/web:3000": "", "http://" + addr: "", "http://" + addr + "/base": "/base"} {
+		e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		tu, _ := url.Parse(target + "?strip_prefix=/service/&add_prefix=/v1")
+
+		p, err := e.NewProxy(e.Host, listen, tu)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		p.Resolver = StaticResolver{"app/web": {{Id: "web-1", Address: addr}}}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		tests := []struct {
+			path string
+			body string
+		}{
+			{"/service", "/v1/?"},
+			{"/service/", "/v1/?"},
+			{"/service/users?page=2", "/v1/users?page=2"},
+			{"/service/a%2Fb", "/v1/a%2Fb?"},
+			{"/services", "/v1/services?"},
+		}
+
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "http://web.app.test"+tt.path, nil))
+			assert.Equal(t, base+tt.body, w.Body.String(), "%s %s", target, tt.path)
+		}
+	}
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://0.0.0.0:5000")
+	tu, _ := url.Parse("tcp://" + addr + "?strip_prefix=/service")
+
+	_, err := e.NewProxy(e.Host, listen, tu)
+	assert.EqualError(t, err, "path rewriting not supported for tcp listener")
+
+	listen, _ = url.Parse("http://0.0.0.0:80")
+	tu, _ = url.Parse("http://" + addr + "?add_prefix=v1")
+
+	_, err = e.NewProxy(e.Host, listen, tu)
+	assert.EqualError(t, err, "path prefix must start with /: v1")
+}
+
+func TestProxyUpstreamScheme(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "secure")
+	}))
+	defer backend.Close()
+
+	addr := backend.Listener.Addr().String()
+
+	tests := []struct {
+		listen string
+		target string
+		status int
+		err    string
+	}{
+		// the tls backend rejects plaintext requests
+		{"http://0.0.0.0:80", "http://" + addr, http.StatusBadRequest, ""},
+		{"http://0.0.0.0:80", "http://" + addr + "?upstream_scheme=https", http.StatusOK, ""},
+		{"https://0.0.0.0:443", "https://" + addr, http.StatusOK, ""},
+		{"http://0.0.0.0:80", "http://" + addr + "?upstream_scheme=ftp", 0, "unknown upstream scheme: ftp"},
+		{"tcp://0.0.0.0:5000", "tcp://" + addr + "?upstream_scheme=https", 0, "upstream scheme not supported for tcp listener"},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse(tt.listen)
+		target, _ := url.Parse(tt.target)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if tt.err != "" {
+			assert.EqualError(t, err, tt.err, tt.target)
+			continue
+		}
+		if !assert.NoError(t, err, tt.target) {
+			continue
+		}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, tt.status, w.Code, tt.target)
+	}
+}
+
+// appearingResolver has no backends until calls reaches after
+type appearingResolver struct {
+	after   int32
+	calls   int32
+	backend Backend
+}
+
+func (r *appearingResolver) Resolve(app, service string) ([]Backend, error) {
+	if atomic.AddInt32(&r.calls, 1) <= r.after {
+		return []Backend{}, nil
+	}
+
+	return []Backend{r.backend}, nil
+}
+
+func TestProxyNoBackendBehavior(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		options string
+		after   int32
+		status  int
+	}{
+		{"", 1, http.StatusServiceUnavailable},
+		{"?no_backend=wait&no_backend_timeout=2s", 2, http.StatusOK},
+		{"?no_backend=wait&no_backend_timeout=100ms", 100, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse("http://rack/app/service/web:3000" + tt.options)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		p.Resolver = &appearingResolver{after: tt.after, backend: Backend{Id: "web-1", Address: backend.Listener.Addr().String()}}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://web.app.test/", nil))
+
+		assert.Equal(t, tt.status, w.Code, tt.options)
+
+		if tt.status == http.StatusServiceUnavailable {
+			assert.Equal(t, "1", w.Header().Get("Retry-After"), tt.options)
+		}
+	}
+
+	// a release without processes yet is waited for like a service without any
+	for _, tt := range []struct {
+		options string
+		status  int
+	}{
+		{"?release=RGREEN", http.StatusServiceUnavailable},
+		{"?release=RGREEN&no_backend=wait&no_backend_timeout=2s", http.StatusOK},
+	} {
+		tr := &testRack{processes: types.Processes{{Id: "web-1", Service: "web", Release: "RBLUE"}}}
+
+		e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse("http://rack/app/service/web:3000" + tt.options)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		p.Rack = func() (rack.Rack, error) { return tr, nil }
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		time.AfterFunc(300*time.Millisecond, func() {
+			tr.add(types.Process{Id: "web-2", Service: "web", Release: "RGREEN"})
+		})
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://web.app.test/", nil))
+
+		assert.Equal(t, tt.status, w.Code, tt.options)
+	}
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000?no_backend=queue")
+
+	_, err := e.NewProxy(e.Host, listen, target)
+	assert.EqualError(t, err, "unknown no backend behavior: queue")
+}
+
+func TestProxyRepeatedOptions(t *testing.T) {
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+
+	target, _ := url.Parse("http://localhost:5000?h2c=true&h2c=false")
+
+	_, err := e.NewProxy(e.Host, listen, target)
+	assert.EqualError(t, err, "repeated h2c option")
+
+	// list options collect every value
+	target, _ = url.Parse("http://localhost:5000?allow=10.0.0.0/8&allow=192.168.0.0/16")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if assert.NoError(t, err) {
+		assert.Len(t, p.AllowCIDRs, 2)
+	}
+}
+
+func TestEndpointTransportOptions(t *testing.T) {
+	var o TransportOptions
+
+	assert.NoError(t, optionTransport(&o, url.Values{"dial_timeout": {"3s"}, "response_header_timeout": {"5s"}, "verify_tls": {"true"}}))
+	assert.EqualError(t, optionTransport(&TransportOptions{}, url.Values{"sticky": {"true"}}), "unknown endpoint option: sticky")
+	assert.EqualError(t, optionTransport(&TransportOptions{}, url.Values{"dial_timeout": {"soon"}}), `invalid dial_timeout option "soon": time: invalid duration "soon"`)
+	assert.EqualError(t, optionTransport(&TransportOptions{}, url.Values{"dial_timeout": {"3s", "5s"}}), "repeated dial_timeout option")
+
+	e := &Endpoint{Host: "web.app.test", Proxies: map[int]*Proxy{}, Transport: o}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000?response_header_timeout=7s")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// proxies start from the endpoint options and override them with their own
+	tr := defaultTransport(p.transportOptions())
+	assert.Equal(t, 7*time.Second, tr.ResponseHeaderTimeout)
+	assert.False(t, tr.TLSClientConfig.InsecureSkipVerify)
+	assert.Equal(t, 3*time.Second, p.transportOptions().Timeouts.Dial)
+
+	// the deprecated timeouts still take precedence
+	p.Timeouts = Timeouts{Dial: time.Second}
+	assert.Equal(t, time.Second, p.transportOptions().Timeouts.Dial)
+	assert.Equal(t, 7*time.Second, p.transportOptions().Timeouts.ResponseHeader)
+}
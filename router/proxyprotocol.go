@@ -0,0 +1,209 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PROXY protocol modes for tcp proxies
+const (
+	ProxyProtocolNone    = ""
+	ProxyProtocolSend    = "send"
+	ProxyProtocolSendV2  = "send-v2"
+	ProxyProtocolReceive = "receive"
+)
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const proxyProtocolHeaderTimeout = 5 * time.Second
+
+// receiveProxyHeader strips a v1 or v2 PROXY header from cn and returns a
+// connection that reports the original client as its remote address
+func receiveProxyHeader(cn net.Conn) (net.Conn, error) {
+	cn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+	defer cn.SetReadDeadline(time.Time{})
+
+	br := bufio.NewReader(cn)
+
+	sig, err := br.Peek(len(proxyProtocolV2Signature))
+	if err != nil && !bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return nil, fmt.Errorf("could not read proxy protocol header: %s", err)
+	}
+
+	var remote net.Addr
+
+	switch {
+	case bytes.HasPrefix(sig, []byte("PROXY ")):
+		remote, err = readProxyHeaderV1(br)
+	case bytes.Equal(sig, proxyProtocolV2Signature):
+		remote, err = readProxyHeaderV2(br)
+	default:
+		return nil, fmt.Errorf("missing proxy protocol header")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if remote == nil {
+		remote = cn.RemoteAddr()
+	}
+
+	return &bufferedConn{Conn: cn, Reader: br, remote: remote}, nil
+}
+
+// proxyProtocolV1MaxLength is the longest v1 header line the spec allows, crlf included
+const proxyProtocolV1MaxLength = 107
+
+func readProxyHeaderV1(br *bufio.Reader) (net.Addr, error) {
+	var buf bytes.Buffer
+
+	// read at most one header length so a client can not stream bytes without ever ending the line
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		buf.WriteByte(c)
+
+		if c == '\n' {
+			break
+		}
+
+		if buf.Len() >= proxyProtocolV1MaxLength {
+			return nil, fmt.Errorf("invalid proxy protocol v1 header")
+		}
+	}
+
+	line := buf.String()
+
+	if !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header")
+	}
+
+	parts := strings.Fields(line)
+
+	if len(parts) > 1 && parts[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(parts) != 6 || (parts[1] != "TCP4" && parts[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header")
+	}
+
+	ip := net.ParseIP(parts[2])
+	if ip == nil {
+		return nil, fmt.Errorf("invalid proxy protocol source address: %s", parts[2])
+	}
+
+	port, err := strconv.Atoi(parts[4])
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid proxy protocol source port: %s", parts[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyHeaderV2(br *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, err
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("invalid proxy protocol v2 version")
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, err
+	}
+
+	// LOCAL command, connection was not proxied
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+
+	switch header[13] {
+	case 0x11:
+		if len(body) < 12 {
+			return nil, fmt.Errorf("invalid proxy protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21:
+		if len(body) < 36 {
+			return nil, fmt.Errorf("invalid proxy protocol v2 address")
+		}
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+
+	return nil, nil
+}
+
+// writeProxyHeader announces the client at src connecting to dst to the backend
+func writeProxyHeader(w io.Writer, mode string, src, dst net.Addr) error {
+	sa, sok := src.(*net.TCPAddr)
+	da, dok := dst.(*net.TCPAddr)
+
+	switch mode {
+	case ProxyProtocolSend:
+		if !sok || !dok {
+			_, err := io.WriteString(w, "PROXY UNKNOWN\r\n")
+			return err
+		}
+
+		// both addresses must be of the announced family, mixed ones are sent as ipv6
+		if sa.IP.To4() != nil && da.IP.To4() != nil {
+			_, err := fmt.Fprintf(w, "PROXY TCP4 %s %s %d %d\r\n", sa.IP.To4(), da.IP.To4(), sa.Port, da.Port)
+			return err
+		}
+
+		_, err := fmt.Fprintf(w, "PROXY TCP6 %s %s %d %d\r\n", ipv6String(sa.IP), ipv6String(da.IP), sa.Port, da.Port)
+		return err
+	case ProxyProtocolSendV2:
+		var buf bytes.Buffer
+
+		buf.Write(proxyProtocolV2Signature)
+
+		switch {
+		case !sok || !dok:
+			buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		case sa.IP.To4() != nil && da.IP.To4() != nil:
+			buf.Write([]byte{0x21, 0x11, 0x00, 12})
+			buf.Write(sa.IP.To4())
+			buf.Write(da.IP.To4())
+			binary.Write(&buf, binary.BigEndian, uint16(sa.Port))
+			binary.Write(&buf, binary.BigEndian, uint16(da.Port))
+		default:
+			buf.Write([]byte{0x21, 0x21, 0x00, 36})
+			buf.Write(sa.IP.To16())
+			buf.Write(da.IP.To16())
+			binary.Write(&buf, binary.BigEndian, uint16(sa.Port))
+			binary.Write(&buf, binary.BigEndian, uint16(da.Port))
+		}
+
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	return nil
+}
+
+// ipv6String formats ip as an ipv6 address, ipv4 addresses in their mapped form
+func ipv6String(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return "::ffff:" + ip4.String()
+	}
+
+	return ip.String()
+}
@@ -0,0 +1,209 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyHeaderRoundTrip(t *testing.T) {
+	tests := []struct {
+		mode string
+		src  *net.TCPAddr
+		dst  *net.TCPAddr
+	}{
+		{ProxyProtocolSend, &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 40000}, &net.TCPAddr{IP: net.ParseIP("10.0.0.2").To4(), Port: 5432}},
+		{ProxyProtocolSend, &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 40000}, &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 5432}},
+		{ProxyProtocolSendV2, &net.TCPAddr{IP: net.ParseIP("10.0.0.1").To4(), Port: 40000}, &net.TCPAddr{IP: net.ParseIP("10.0.0.2").To4(), Port: 5432}},
+		{ProxyProtocolSendV2, &net.TCPAddr{IP: net.ParseIP("fd00::1"), Port: 40000}, &net.TCPAddr{IP: net.ParseIP("fd00::2"), Port: 5432}},
+	}
+
+	for _, tt := range tests {
+		a, b := net.Pipe()
+
+		go func() {
+			writeProxyHeader(a, tt.mode, tt.src, tt.dst)
+			a.Write([]byte("payload"))
+			a.Close()
+		}()
+
+		cn, err := receiveProxyHeader(b)
+		if !assert.NoError(t, err, tt.mode) {
+			continue
+		}
+
+		assert.Equal(t, tt.src.String(), cn.RemoteAddr().String(), tt.mode)
+
+		data, err := ioutil.ReadAll(cn)
+		assert.NoError(t, err)
+		assert.Equal(t, "payload", string(data))
+	}
+}
+
+func TestProxyHeaderUnknown(t *testing.T) {
+	a, b := net.Pipe()
+
+	go func() {
+		writeProxyHeader(a, ProxyProtocolSend, &net.UnixAddr{Name: "/tmp/sock"}, &net.UnixAddr{Name: "/tmp/sock"})
+		a.Close()
+	}()
+
+	cn, err := receiveProxyHeader(b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, b.RemoteAddr(), cn.RemoteAddr())
+	}
+}
+
+func TestProxyHeaderInvalid(t *testing.T) {
+	for _, header := range []string{"GET / HTTP/1.1\r\n\r\n", "PROXY TCP4 nope 10.0.0.2 1 2\r\n", "PROXY TCP4 10.0.0.1 10.0.0.2 1\r\n"} {
+		a, b := net.Pipe()
+
+		go func() {
+			a.Write([]byte(header))
+			a.Close()
+		}()
+
+		_, err := receiveProxyHeader(b)
+		assert.Error(t, err, header)
+	}
+}
+
+func TestProxyProtocolSend(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse(fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t)))
+	target, _ := url.Parse(fmt.Sprintf("tcp://%s?proxy_protocol=send", backend.Addr()))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go p.Serve()
+
+	waitListening(t, listen.Host)
+
+	cn, err := net.Dial("tcp", listen.Host)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cn.Close()
+
+	cn.Write([]byte("hello\n"))
+
+	ca := cn.LocalAddr().(*net.TCPAddr)
+	expected := fmt.Sprintf("PROXY TCP4 127.0.0.1 127.0.0.1 %d %s\r\n", ca.Port, listen.Port())
+
+	var br *bufio.Reader
+
+	// the connection probing for the listener may reach the backend first
+	for br == nil {
+		bc, err := backend.Accept()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer bc.Close()
+
+		r := bufio.NewReader(bc)
+
+		if header, err := r.ReadString('\n'); err == nil && header == expected {
+			br = r
+		}
+	}
+
+	line, err := br.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", line)
+}
+
+func TestProxyProtocolReceive(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	l := &testLogger{}
+
+	SetLogger(l)
+	defer SetLogger(NewLogfmtLogger(ioutil.Discard))
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse(fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t)))
+	target, _ := url.Parse(fmt.Sprintf("tcp://%s?proxy_protocol=receive", backend.Addr()))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go p.Serve()
+
+	waitListening(t, listen.Host)
+
+	cn, err := net.Dial("tcp", listen.Host)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cn.Close()
+
+	fmt.Fprintf(cn, "PROXY TCP4 192.0.2.10 127.0.0.1 40000 %s\r\nhello\n", listen.Port())
+
+	bc, err := backend.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer bc.Close()
+
+	// the header is stripped before the stream reaches the backend
+	line, err := bufio.NewReader(bc).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", line)
+
+	remotes := []interface{}{}
+
+	for _, ev := range l.Events() {
+		if ev["type"] == "tcp" {
+			remotes = append(remotes, ev["remote"])
+		}
+	}
+
+	assert.Contains(t, remotes, "192.0.2.10:40000")
+}
+
+func TestProxyProtocolOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://0.0.0.0:5000")
+
+	target, _ := url.Parse("tcp://localhost:5432?proxy_protocol=sideways")
+	_, err := e.NewProxy(e.Host, listen, target)
+	assert.EqualError(t, err, "unknown proxy protocol mode: sideways")
+
+	target, _ = url.Parse("tcp://rack/app/resource/db:5432?proxy_protocol=send")
+	_, err = e.NewProxy(e.Host, listen, target)
+	assert.EqualError(t, err, "proxy protocol send not supported for rack targets")
+
+	// a header behind tls would be read as part of the handshake
+	listen, _ = url.Parse("https://0.0.0.0:443")
+	target, _ = url.Parse("http://localhost:5000?proxy_protocol=receive")
+	_, err = e.NewProxy(e.Host, listen, target)
+	assert.EqualError(t, err, "proxy protocol not supported for https listener")
+
+	listen, _ = url.Parse("tls://0.0.0.0:5001")
+	target, _ = url.Parse("tcp://localhost:5432?proxy_protocol=receive")
+	_, err = e.NewProxy(e.Host, listen, target)
+	assert.EqualError(t, err, "unknown listener scheme: tls")
+}
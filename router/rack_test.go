@@ -0,0 +1,214 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/convox/praxis/types"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// rackAPI fakes the rack api that proxies reach through RACK_URL, process
+// proxies answer http requests with "app pid:port path" or relay to backend when set
+type rackAPI struct {
+	*httptest.Server
+
+	backend   string
+	done      chan struct{}
+	failing   map[string]bool
+	lock      sync.Mutex
+	processes types.Processes
+	proxied   []string
+}
+
+func newRackAPI(pss types.Processes) (*rackAPI, func()) {
+	r := &rackAPI{done: make(chan struct{}), failing: map[string]bool{}, processes: pss}
+
+	m := mux.NewRouter()
+	m.HandleFunc("/system", r.options).Methods("OPTIONS")
+	m.HandleFunc("/apps/{app}/processes", r.processList).Methods("GET")
+	m.HandleFunc("/apps/{app}/processes/{pid}/proxy/{port}", r.processProxy).Methods("POST")
+
+	r.Server = httptest.NewServer(m)
+
+	env := os.Getenv("RACK_URL")
+	os.Setenv("RACK_URL", r.URL)
+
+	return r, func() {
+		os.Setenv("RACK_URL", env)
+		close(r.done)
+		r.CloseClientConnections()
+		r.Close()
+	}
+}
+
+func (r *rackAPI) setProcesses(pss types.Processes) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.processes = pss
+}
+
+func (r *rackAPI) fail(pid string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.failing[pid] = true
+}
+
+// Proxied returns the process ids that were proxied to, in order
+func (r *rackAPI) Proxied() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]string{}, r.proxied...)
+}
+
+func (r *rackAPI) options(w http.ResponseWriter, req *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{"streaming": "http2"})
+}
+
+func (r *rackAPI) processList(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	pss := types.Processes{}
+
+	for _, ps := range r.processes {
+		if s := req.URL.Query().Get("service"); s == "" || ps.Service == "" || ps.Service == s {
+			pss = append(pss, ps)
+		}
+	}
+
+	json.NewEncoder(w).Encode(pss)
+}
+
+func (r *rackAPI) processProxy(w http.ResponseWriter, req *http.Request) {
+	v := mux.Vars(req)
+
+	r.lock.Lock()
+	r.proxied = append(r.proxied, v["pid"])
+	failing := r.failing[v["pid"]]
+	running := false
+
+	for _, ps := range r.processes {
+		running = running || ps.Id == v["pid"]
+	}
+
+	r.lock.Unlock()
+
+	// the request body stays open for the life of the proxy
+	http.NewResponseController(w).EnableFullDuplex()
+
+	// processes that were stopped refuse connections like failing ones
+	if failing || !running {
+		http.Error(w, "connection refused", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	if r.backend != "" {
+		r.relay(w, req)
+		return
+	}
+
+	pr, err := http.ReadRequest(bufio.NewReader(req.Body))
+	if err != nil {
+		return
+	}
+
+	body := fmt.Sprintf("%s %s:%s %s", v["app"], v["pid"], v["port"], pr.URL.Path)
+
+	fmt.Fprintf(w, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s", len(body), body)
+}
+
+func (r *rackAPI) relay(w http.ResponseWriter, req *http.Request) {
+	cn, err := net.Dial("tcp", r.backend)
+	if err != nil {
+		return
+	}
+
+	defer cn.Close()
+
+	go io.Copy(cn, req.Body)
+
+	go func() {
+		<-r.done
+		cn.Close()
+	}()
+
+	buf := make([]byte, 32*1024)
+
+	for {
+		n, err := cn.Read(buf)
+		if n > 0 {
+			w.Write(buf[0:n])
+			w.(http.Flusher).Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestProxyRackClientReuse(t *testing.T) {
+	url := os.Getenv("RACK_URL")
+	defer os.Setenv("RACK_URL", url)
+
+	os.Setenv("RACK_URL", "https://rack-a.convox:5443")
+
+	p := &Proxy{}
+
+	a, err := p.rack()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	b, err := p.rack()
+	assert.NoError(t, err)
+	assert.True(t, a == b)
+
+	// a failure resets the client
+	p.resetRack()
+
+	c, err := p.rack()
+	assert.NoError(t, err)
+	assert.False(t, a == c)
+
+	// a new rack url builds a new client
+	os.Setenv("RACK_URL", "https://rack-b.convox:5443")
+
+	d, err := p.rack()
+	assert.NoError(t, err)
+	assert.False(t, c == d)
+}
+
+func TestProxyRackClientResetOnFailure(t *testing.T) {
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}})
+	defer cleanup()
+
+	p, _ := rackServiceHandler(t, "")
+
+	_, err := p.dialService(context.Background(), "app", "web", 3000)
+	if assert.NoError(t, err) {
+		assert.NotNil(t, p.rackClient)
+	}
+
+	ra.fail("web-1")
+
+	_, err = p.dialService(context.Background(), "app", "web", 3000)
+	assert.Error(t, err)
+	assert.Nil(t, p.rackClient)
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultRateLimitClients = 10000
+
+// RateLimitOptions configures a token bucket per client ip, a zero rate disables limiting
+type RateLimitOptions struct {
+	Rate       float64
+	Burst      int
+	MaxClients int
+}
+
+// rateLimiter keeps token buckets for the most recently seen clients
+type rateLimiter struct {
+	options RateLimitOptions
+
+	buckets map[string]*list.Element
+	lock    sync.Mutex
+	lru     *list.List
+}
+
+type rateBucket struct {
+	client string
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(opts RateLimitOptions) *rateLimiter {
+	if opts.Burst < 1 {
+		opts.Burst = int(math.Max(1, math.Ceil(opts.Rate)))
+	}
+
+	if opts.MaxClients < 1 {
+		opts.MaxClients = defaultRateLimitClients
+	}
+
+	return &rateLimiter{
+		options: opts,
+		buckets: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Allow takes a token for client, returning how long to wait when none are left
+func (l *rateLimiter) Allow(client string) (bool, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+
+	var b *rateBucket
+
+	if e, ok := l.buckets[client]; ok {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*rateBucket)
+		b.tokens = math.Min(float64(l.options.Burst), b.tokens+now.Sub(b.last).Seconds()*l.options.Rate)
+		b.last = now
+	} else {
+		b = &rateBucket{client: client, tokens: float64(l.options.Burst), last: now}
+		l.buckets[client] = l.lru.PushFront(b)
+
+		for l.lru.Len() > l.options.MaxClients {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*rateBucket).client)
+		}
+	}
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.options.Rate * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+func (p *Proxy) rateLimit(h http.Handler) http.Handler {
+	if p.RateLimit.Rate <= 0 {
+		return h
+	}
+
+	l := newRateLimiter(p.RateLimit)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := l.Allow(p.clientIP(r)); !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientIP is the connection address, or the last X-Forwarded-For entry when the proxy trusts it. The
+// last entry is the one added by the trusted hop in front of us, earlier ones are set by the client
+func (p *Proxy) clientIP(r *http.Request) string {
+	if p.TrustForwardedFor {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			entries := strings.Split(xff[len(xff)-1], ",")
+
+			if ip := strings.TrimSpace(entries[len(entries)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+
+	return r.RemoteAddr
+}
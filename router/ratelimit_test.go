@@ -0,0 +1,99 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(RateLimitOptions{Rate: 10, Burst: 2})
+
+	ok, _ := l.Allow("10.0.0.1")
+	assert.True(t, ok)
+
+	ok, _ = l.Allow("10.0.0.1")
+	assert.True(t, ok)
+
+	ok, wait := l.Allow("10.0.0.1")
+	assert.False(t, ok)
+	assert.True(t, wait > 0 && wait <= 100*time.Millisecond)
+
+	// other clients have their own bucket
+	ok, _ = l.Allow("10.0.0.2")
+	assert.True(t, ok)
+
+	time.Sleep(120 * time.Millisecond)
+
+	ok, _ = l.Allow("10.0.0.1")
+	assert.True(t, ok)
+}
+
+func TestRateLimiterMaxClients(t *testing.T) {
+	l := newRateLimiter(RateLimitOptions{Rate: 1, MaxClients: 2})
+
+	assert.Equal(t, 1, l.options.Burst)
+
+	l.Allow("10.0.0.1")
+	l.Allow("10.0.0.2")
+	l.Allow("10.0.0.3")
+
+	assert.Equal(t, 2, l.lru.Len())
+
+	// the least recently seen client was evicted and starts with a full bucket
+	ok, _ := l.Allow("10.0.0.1")
+	assert.True(t, ok)
+
+	ok, _ = l.Allow("10.0.0.3")
+	assert.False(t, ok)
+}
+
+func TestProxyRateLimit(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?rate_limit=0.5&rate_limit_burst=2&rate_limit_clients=100")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, RateLimitOptions{Rate: 0.5, Burst: 2, MaxClients: 100}, p.RateLimit)
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	get := func(remote, xff string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "http://test.convox/", nil)
+		req.RemoteAddr = remote
+
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1000", "").Code)
+	assert.Equal(t, http.StatusOK, get("10.0.0.1:1001", "").Code)
+
+	w := get("10.0.0.1:1002", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	// forwarded addresses are not trusted by default so they do not get a fresh bucket
+	assert.Equal(t, http.StatusTooManyRequests, get("10.0.0.1:1003", "192.0.2.1, 10.0.0.1").Code)
+}
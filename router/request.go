@@ -0,0 +1,42 @@
+package router
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries a request id from the router through to the backend
+var RequestIDHeader = "X-Request-Id"
+
+// expectsContinue is true when the client waits for 100 Continue before sending the body. Reading
+// the body makes the server send it, so such bodies are only read once the backend asked for them.
+// The transports wait up to a second for the backend's 100 Continue, also over the pipes to rack
+// processes, after that the body is sent anyway.
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// ensureRequestID keeps an incoming request id or sets a new one, and returns it
+func ensureRequestID(h http.Header) string {
+	if id := h.Get(RequestIDHeader); id != "" {
+		return id
+	}
+
+	id := generateRequestID()
+
+	h.Set(RequestIDHeader, id)
+
+	return id
+}
+
+func generateRequestID() string {
+	data := make([]byte, 16)
+
+	if _, err := rand.Read(data); err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(data)
+}
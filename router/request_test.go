@@ -0,0 +1,163 @@
+package router
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+var requestIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// requestIDBackend answers with the request id it received
+func requestIDBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("X-Request-Id"))
+	}))
+}
+
+// requestIDs sends one request with an id and one without through h and
+// returns the ids seen by the backend and the access log
+func requestIDs(t *testing.T, h http.Handler) ([]string, []string) {
+	l := &testLogger{}
+
+	SetLogger(l)
+	defer SetLogger(NewLogfmtLogger(ioutil.Discard))
+
+	backend := []string{}
+
+	for _, id := range []string{"abc123", ""} {
+		req := httptest.NewRequest("GET", "http://test.convox/", nil)
+
+		if id != "" {
+			req.Header.Set("X-Request-Id", id)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		backend = append(backend, w.Body.String())
+	}
+
+	logged := []string{}
+
+	for _, ev := range l.Events() {
+		if id, ok := ev["request_id"].(string); ok {
+			logged = append(logged, id)
+		}
+	}
+
+	return backend, logged
+}
+
+func TestProxyRequestID(t *testing.T) {
+	backend := requestIDBackend()
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	seen, logged := requestIDs(t, h)
+
+	if assert.Len(t, seen, 2) {
+		assert.Equal(t, "abc123", seen[0])
+		assert.Regexp(t, requestIDPattern, seen[1])
+	}
+
+	assert.Equal(t, seen, logged)
+}
+
+func TestProxyRackRequestID(t *testing.T) {
+	backend := requestIDBackend()
+	defer backend.Close()
+
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}})
+	defer cleanup()
+
+	ra.backend = backend.Listener.Addr().String()
+
+	_, h := rackServiceHandler(t, "")
+
+	seen, logged := requestIDs(t, h)
+
+	if assert.Len(t, seen, 2) {
+		assert.Equal(t, "abc123", seen[0])
+		assert.Regexp(t, requestIDPattern, seen[1])
+	}
+
+	assert.Equal(t, seen, logged)
+}
+
+func TestProxyWebsocketRequestID(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		c.WriteMessage(websocket.TextMessage, []byte(r.Header.Get("X-Request-Id")))
+		c.ReadMessage()
+	}))
+	defer backend.Close()
+
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}})
+	defer cleanup()
+
+	ra.backend = backend.Listener.Addr().String()
+
+	_, h := rackServiceHandler(t, "")
+
+	frontend := httptest.NewServer(h)
+	defer frontend.Close()
+
+	u := strings.Replace(frontend.URL, "http", "ws", 1)
+
+	for _, header := range []http.Header{{"X-Request-Id": {"abc123"}}, nil} {
+		c, _, err := websocket.DefaultDialer.Dial(u, header)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		_, data, err := c.ReadMessage()
+		assert.NoError(t, err)
+
+		if header != nil {
+			assert.Equal(t, "abc123", string(data))
+		} else {
+			assert.Regexp(t, requestIDPattern, string(data))
+		}
+
+		c.Close()
+	}
+}
+
+func TestEnsureRequestID(t *testing.T) {
+	h := http.Header{}
+
+	id := ensureRequestID(h)
+
+	assert.Regexp(t, requestIDPattern, id)
+	assert.Equal(t, id, h.Get("X-Request-Id"))
+	assert.Equal(t, id, ensureRequestID(h))
+}
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/convox/praxis/sdk/rack"
+	"github.com/convox/praxis/types"
+)
+
+// Backend is one instance of a service that requests can be sent to
+type Backend struct {
+	// Id identifies the backend, for rack backends it is the process id
+	Id string
+
+	// Address is dialed directly when set, otherwise the process is reached through the rack
+	Address string
+}
+
+// BackendResolver finds the backends of a service
+type BackendResolver interface {
+	Resolve(app, service string) ([]Backend, error)
+}
+
+// rackResolver lists the running processes of a service on the rack, only those of release when set
+type rackResolver struct {
+	rack    func() (rack.Rack, error)
+	release string
+}
+
+func (rr rackResolver) Resolve(app, service string) ([]Backend, error) {
+	r, err := rr.rack()
+	if err != nil {
+		return nil, err
+	}
+
+	pss, err := r.ProcessList(app, types.ProcessListOptions{Release: rr.release, Service: service})
+	if err != nil {
+		return nil, err
+	}
+
+	if rr.release != "" && len(pss) == 0 {
+		return nil, noBackendsError{service: service, release: rr.release}
+	}
+
+	bs := make([]Backend, len(pss))
+
+	for i, ps := range pss {
+		bs[i] = Backend{Id: ps.Id}
+	}
+
+	return bs, nil
+}
+
+// StaticResolver serves fixed backend lists keyed by app/service
+type StaticResolver map[string][]Backend
+
+func (s StaticResolver) Resolve(app, service string) ([]Backend, error) {
+	bs, ok := s[fmt.Sprintf("%s/%s", app, service)]
+	if !ok {
+		return nil, fmt.Errorf("no backends for service: %s/%s", app, service)
+	}
+
+	return bs, nil
+}
+
+func (p *Proxy) resolver() BackendResolver {
+	if p.Resolver == nil {
+		return rackResolver{rack: p.rack, release: p.Release}
+	}
+
+	return p.Resolver
+}
+
+// rack returns a client for the rack hosting rack targets, the client built from the environment is
+// reused until RACK_URL changes or it is reset after a failure
+func (p *Proxy) rack() (rack.Rack, error) {
+	if p.Rack != nil {
+		return p.Rack()
+	}
+
+	endpoint := os.Getenv("RACK_URL")
+
+	p.rackLock.Lock()
+	defer p.rackLock.Unlock()
+
+	if p.rackClient != nil && p.rackURL == endpoint {
+		return p.rackClient, nil
+	}
+
+	r, err := rack.New(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	p.rackClient = r
+	p.rackURL = endpoint
+
+	return r, nil
+}
+
+// resetRack drops the cached rack client so the next dial builds a new one
+func (p *Proxy) resetRack() {
+	p.rackLock.Lock()
+	defer p.rackLock.Unlock()
+
+	p.rackClient = nil
+}
@@ -0,0 +1,100 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ResourceResolver finds the rack resources that serve a resource, replicas included
+type ResourceResolver interface {
+	ResolveResource(app, resource string) ([]string, error)
+}
+
+// replicaResolver serves the resource itself followed by its configured replicas
+type replicaResolver struct {
+	replicas []string
+}
+
+func (rr replicaResolver) ResolveResource(app, resource string) ([]string, error) {
+	return append([]string{resource}, rr.replicas...), nil
+}
+
+func (p *Proxy) resourceResolver() ResourceResolver {
+	if p.ResourceResolver == nil {
+		return replicaResolver{replicas: p.Replicas}
+	}
+
+	return p.ResourceResolver
+}
+
+// dialResource connects to the replicas of resource in round robin order, moving on to the next
+// replica and then retrying with a backoff only while nothing has been read from the client
+func (p *Proxy) dialResource(app, resource string, in *startedReader, target *url.URL) (io.ReadCloser, error) {
+	names, err := p.resourceResolver().ResolveResource(app, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no replicas for resource: %s", resource)
+	}
+
+	start := int(atomic.AddUint64(&p.resourceNext, 1) % uint64(len(names)))
+	backoff := coalesceDuration(p.TCP.ConnectBackoff, defaultTCPConnectBackoff)
+
+	var last error
+
+	for attempt := 0; ; attempt++ {
+		for i := range names {
+			name := names[(start+i)%len(names)]
+
+			r, err := p.rack()
+			if err != nil {
+				return nil, err
+			}
+
+			rc, err := r.ResourceProxy(app, name, in)
+			if err == nil {
+				return rc, nil
+			}
+
+			p.resetRack()
+
+			last = err
+
+			// once the client's data has been sent it can not be replayed
+			if in.started() || (attempt >= p.TCP.connectRetries() && i == len(names)-1) {
+				return nil, err
+			}
+
+			logger.Log("proxy", Fields{"type": "tcp", "target": target.String(), "resource": name, "attempt": attempt + 1, "error": err})
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-p.ctx.Done():
+			return nil, last
+		}
+
+		backoff *= 2
+	}
+}
+
+// optionReplicas parses comma separated or repeated resource names
+func optionReplicas(replicas *[]string, values []string) error {
+	for _, v := range values {
+		for _, r := range strings.Split(v, ",") {
+			if r = strings.TrimSpace(r); r == "" {
+				return fmt.Errorf("empty replica")
+			}
+
+			*replicas = append(*replicas, r)
+		}
+	}
+
+	return nil
+}
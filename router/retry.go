@@ -0,0 +1,130 @@
+package router
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+const (
+	defaultRetries          = 2
+	defaultRetryBufferBytes = 64 * 1024
+)
+
+// dialError marks failures to establish a backend connection, before any bytes were written
+type dialError struct {
+	error
+}
+
+// retryTransport retries idempotent requests whose backend dial failed, buffering the body of
+// requests carrying an Idempotency-Key so they can be sent again
+type retryTransport struct {
+	http.RoundTripper
+	buffer  int64
+	retries int
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.retries > 0 && req.Header.Get("Idempotency-Key") != "" && !expectsContinue(req) {
+		r, err := bufferBody(req, t.buffer)
+		if err != nil {
+			return nil, err
+		}
+		req = r
+	}
+
+	for i := 0; ; i++ {
+		res, err := t.RoundTripper.RoundTrip(req)
+		if err == nil || i >= t.retries || !retryable(req) || !isDialError(err) {
+			return res, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+
+			r := req.Clone(req.Context())
+			r.Body = body
+			req = r
+		}
+	}
+}
+
+// bufferBody makes the body of req replayable when it fits in max bytes, larger bodies are left
+// streaming and the request is not retried
+func bufferBody(req *http.Request, max int64) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return req, nil
+	}
+
+	if req.ContentLength > max {
+		return req, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(req.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+
+	r := req.Clone(req.Context())
+
+	if int64(len(data)) > max {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(data), req.Body), req.Body}
+		return r, nil
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(data))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	return r, nil
+}
+
+func (p *Proxy) retries() int {
+	if p.Retries == nil {
+		return defaultRetries
+	}
+
+	return *p.Retries
+}
+
+func (p *Proxy) retryBufferBytes() int64 {
+	if p.RetryBufferBytes > 0 {
+		return p.RetryBufferBytes
+	}
+
+	return defaultRetryBufferBytes
+}
+
+func isDialError(err error) bool {
+	switch t := err.(type) {
+	case dialError:
+		return true
+	case *net.OpError:
+		return t.Op == "dial"
+	}
+
+	return false
+}
+
+func retryable(req *http.Request) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if req.Header.Get("Idempotency-Key") != "" {
+		return true
+	}
+
+	switch req.Method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,108 @@
+package router
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func rackServiceHandler(t *testing.T, opts string) (*Proxy, http.Handler) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://rack/app/service/web:3000?" + opts)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	h, err := p.proxyRackHTTP()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	return p, h
+}
+
+func TestProxyRetryOtherProcess(t *testing.T) {
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}, {Id: "web-2"}})
+	defer cleanup()
+
+	ra.fail("web-1")
+
+	_, h := rackServiceHandler(t, "")
+
+	for i := 0; i < 4; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://test.convox/path", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "app web-2:3000 /path", w.Body.String())
+	}
+
+	// web-1 is skipped once it has failed
+	failed := 0
+
+	for _, pid := range ra.Proxied() {
+		if pid == "web-1" {
+			failed++
+		}
+	}
+
+	assert.True(t, failed <= 1)
+}
+
+func TestProxyRetryLimit(t *testing.T) {
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}, {Id: "web-2"}})
+	defer cleanup()
+
+	ra.fail("web-1")
+	ra.fail("web-2")
+
+	p, h := rackServiceHandler(t, "retries=1")
+
+	if assert.NotNil(t, p.Retries) {
+		assert.Equal(t, 1, *p.Retries)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://test.convox/", nil))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Len(t, ra.Proxied(), 2)
+
+	// a request body that can not be replayed is not retried
+	req := httptest.NewRequest("PUT", "http://test.convox/", ioutil.NopCloser(strings.NewReader("data")))
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Len(t, ra.Proxied(), 3)
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "http://test.convox/", nil))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Len(t, ra.Proxied(), 4)
+}
+
+func TestRetryable(t *testing.T) {
+	for method, ok := range map[string]bool{"GET": true, "HEAD": true, "PUT": true, "DELETE": true, "POST": false, "PATCH": false} {
+		req, _ := http.NewRequest(method, "http://test.convox/", nil)
+		assert.Equal(t, ok, retryable(req), method)
+	}
+
+	req, _ := http.NewRequest("PUT", "http://test.convox/", strings.NewReader("data"))
+	assert.True(t, retryable(req))
+
+	req.GetBody = nil
+	assert.False(t, retryable(req))
+}
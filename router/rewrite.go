@@ -0,0 +1,116 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// modifyResponse runs the response hooks shared by every http proxy
+func (p *Proxy) modifyResponse(res *http.Response) error {
+	hooks := []func(*http.Response) error{
+		p.limitResponse,
+		p.rewriteResponse,
+		p.securityHeaders,
+		p.compressResponse,
+	}
+
+	for _, hook := range hooks {
+		if err := hook(res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteRules maps backend hosts to public hosts, an empty public host means the host the client requested
+func (p *Proxy) rewriteRules() map[string]string {
+	rules := map[string]string{}
+
+	if p.Target.Hostname() != "rack" && p.Target.Host != "" {
+		rules[p.Target.Host] = ""
+	}
+
+	for from, to := range p.RewriteHosts {
+		rules[from] = to
+	}
+
+	return rules
+}
+
+// rewriteResponse points Location and Set-Cookie domains at the public host instead of the backend
+func (p *Proxy) rewriteResponse(res *http.Response) error {
+	rules := p.rewriteRules()
+
+	if len(rules) == 0 || res.Request == nil {
+		return nil
+	}
+
+	public := res.Request.Host
+
+	if loc := res.Header.Get("Location"); loc != "" {
+		if u, err := url.Parse(loc); err == nil && u.Host != "" {
+			if to, ok := matchRewrite(rules, u.Host); ok {
+				u.Host = coalesceString(to, public)
+				res.Header.Set("Location", u.String())
+			}
+		}
+	}
+
+	cookies := res.Header["Set-Cookie"]
+
+	for i, c := range cookies {
+		attrs := strings.Split(c, ";")
+
+		for j, a := range attrs {
+			kv := strings.SplitN(strings.TrimSpace(a), "=", 2)
+
+			if len(kv) != 2 || !strings.EqualFold(kv[0], "domain") {
+				continue
+			}
+
+			if to, ok := matchRewrite(rules, strings.TrimPrefix(kv[1], ".")); ok {
+				attrs[j] = " Domain=" + hostname(coalesceString(to, public))
+			}
+		}
+
+		cookies[i] = strings.Join(attrs, ";")
+	}
+
+	return nil
+}
+
+// matchRewrite finds the rule for host, comparing without ports when there is no exact match
+func matchRewrite(rules map[string]string, host string) (string, bool) {
+	if to, ok := rules[host]; ok {
+		return to, true
+	}
+
+	for from, to := range rules {
+		if hostname(from) == hostname(host) {
+			return to, true
+		}
+	}
+
+	return "", false
+}
+
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return host
+}
+
+func coalesceString(ss ...string) string {
+	for _, s := range ss {
+		if s != "" {
+			return s
+		}
+	}
+
+	return ""
+}
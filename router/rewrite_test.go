@@ -0,0 +1,71 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyRewriteHeaders(t *testing.T) {
+	var backend *httptest.Server
+
+	backend = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/self":
+			bu, _ := url.Parse(backend.URL)
+			w.Header().Set("Location", backend.URL+"/next?a=b")
+			w.Header().Add("Set-Cookie", "session=1; Path=/; Domain="+bu.Hostname()+"; HttpOnly")
+		case "/internal":
+			w.Header().Set("Location", "https://internal.example:8443/login")
+			w.Header().Add("Set-Cookie", "a=1; Domain=.internal.example")
+			w.Header().Add("Set-Cookie", "b=2; Domain=other.example")
+		case "/relative":
+			w.Header().Set("Location", "/next")
+		}
+
+		w.WriteHeader(http.StatusFound)
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("https://0.0.0.0:443")
+	target, _ := url.Parse(backend.URL + "?rewrite_host=internal.example=public.example")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]string{"internal.example": "public.example"}, p.RewriteHosts)
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	get := func(path string) http.Header {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "https://web.test.convox"+path, nil))
+		return w.Header()
+	}
+
+	// the backend host becomes the host the client requested
+	hs := get("/self")
+	assert.Equal(t, "http://web.test.convox/next?a=b", hs.Get("Location"))
+	assert.Equal(t, []string{"session=1; Path=/; Domain=web.test.convox; HttpOnly"}, hs["Set-Cookie"])
+
+	// configured hosts map to their public host, others are left alone
+	hs = get("/internal")
+	assert.Equal(t, "https://public.example/login", hs.Get("Location"))
+	assert.Equal(t, []string{"a=1; Domain=public.example", "b=2; Domain=other.example"}, hs["Set-Cookie"])
+
+	hs = get("/relative")
+	assert.Equal(t, "/next", hs.Get("Location"))
+
+	_, err = e.NewProxy(e.Host, &url.URL{Scheme: "https", Host: "0.0.0.0:444"}, &url.URL{Scheme: "http", Host: "localhost:5000", RawQuery: "rewrite_host=nope"})
+	assert.EqualError(t, err, `invalid rewrite_host option "nope": expected key=value`)
+}
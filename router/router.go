@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
@@ -17,9 +18,16 @@ import (
 )
 
 type Endpoint struct {
-	Host    string        `json:"host"`
-	IP      net.IP        `json:"ip"`
-	Proxies map[int]Proxy `json:"proxies"`
+	Host    string         `json:"host"`
+	IP      net.IP         `json:"ip"`
+	Proxies map[int]*Proxy `json:"proxies"`
+
+	// Aliases are extra dns names, wildcards included, served by the endpoint certificate
+	Aliases []string `json:"aliases,omitempty"`
+
+	// Transport is the default upstream tuning for proxies created on this endpoint, set from the
+	// transport options passed when the endpoint is created
+	Transport TransportOptions `json:"transport"`
 
 	router *Router
 }
@@ -30,12 +38,31 @@ type Router struct {
 	Subnet    string
 	Version   string
 
+	// EventHandler receives the lifecycle events of every proxy created on the router
+	EventHandler EventHandler
+
+	// Tracer traces the requests of every proxy created on the router, nil disables tracing
+	Tracer Tracer
+
+	// Certificates issues the certificates of https and tls proxies, nil signs them with the router ca.
+	// Certificates from it are cached until they are due for renewal, it must be set before proxies start
+	Certificates CertificateSource
+
+	// ErrorPagesDir holds the templates named by the error_page_html and error_page_json proxy
+	// options, empty disables those options
+	ErrorPagesDir string
+
 	ca        tls.Certificate
+	certs     certificateCache
+	certLock  sync.Mutex
 	dns       *DNS
 	endpoints map[string]Endpoint
 	lock      sync.Mutex
 	ip        net.IP
 	net       *net.IPNet
+
+	source     CertificateSource
+	sourceOnce sync.Once
 }
 
 func New(version, domain, iface, subnet string) (*Router, error) {
@@ -87,7 +114,7 @@ func (r *Router) Serve() error {
 
 	rh := fmt.Sprintf("rack.%s", r.Domain)
 
-	ep, err := r.createEndpoint(rh)
+	ep, err := r.createEndpoint(rh, nil, TransportOptions{})
 	if err != nil {
 		return err
 	}
@@ -106,6 +133,9 @@ func (r *Router) Serve() error {
 	a.Route("POST", "/endpoints/{host}", r.EndpointCreate)
 	a.Route("DELETE", "/endpoints/{host}", r.EndpointDelete)
 	a.Route("POST", "/endpoints/{host}/proxies/{port}", r.ProxyCreate)
+	a.Route("GET", "/endpoints/{host}/proxies/{port}/connections", r.ConnectionList)
+	a.Route("DELETE", "/endpoints/{host}/proxies/{port}/connections/{id}", r.ConnectionClose)
+	a.Route("GET", "/proxies", r.ProxyList)
 	a.Route("POST", "/terminate", r.Terminate)
 	a.Route("GET", "/version", r.VersionGet)
 
@@ -116,7 +146,46 @@ func (r *Router) Serve() error {
 	return nil
 }
 
-func (r *Router) createEndpoint(host string) (*Endpoint, error) {
+// Shutdown gracefully stops every proxy, waiting for active connections until ctx expires
+func (r *Router) Shutdown(ctx context.Context) error {
+	r.lock.Lock()
+
+	ps := []*Proxy{}
+
+	for _, e := range r.endpoints {
+		for _, p := range e.Proxies {
+			ps = append(ps, p)
+		}
+	}
+
+	r.lock.Unlock()
+
+	errs := make(chan error, len(ps))
+
+	for _, p := range ps {
+		go func(p *Proxy) {
+			errs <- p.Shutdown(ctx)
+		}(p)
+	}
+
+	var err error
+
+	for range ps {
+		if perr := <-errs; perr != nil {
+			err = perr
+		}
+	}
+
+	return err
+}
+
+func (r *Router) createEndpoint(host string, aliases []string, transport TransportOptions) (*Endpoint, error) {
+	for _, a := range aliases {
+		if !validHostname(strings.TrimPrefix(a, "*.")) {
+			return nil, fmt.Errorf("invalid alias: %s", a)
+		}
+	}
+
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
@@ -134,10 +203,12 @@ func (r *Router) createEndpoint(host string) (*Endpoint, error) {
 	}
 
 	e := Endpoint{
-		Host:    host,
-		IP:      ip,
-		Proxies: map[int]Proxy{},
-		router:  r,
+		Host:      host,
+		IP:        ip,
+		Aliases:   aliases,
+		Proxies:   map[int]*Proxy{},
+		Transport: transport,
+		router:    r,
 	}
 
 	r.endpoints[host] = e
@@ -162,6 +233,29 @@ func (r *Router) matchEndpoint(host string) (*Endpoint, error) {
 	return &ep, nil
 }
 
+// proxy returns the proxy listening on port of the endpoint for host
+func (r *Router) proxy(host, port string) (*Proxy, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ep, ok := r.endpoints[host]
+	if !ok {
+		return nil, fmt.Errorf("no such endpoint: %s", host)
+	}
+
+	pi, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", port)
+	}
+
+	p, ok := ep.Proxies[pi]
+	if !ok {
+		return nil, fmt.Errorf("no proxy on port %d of %s", pi, host)
+	}
+
+	return p, nil
+}
+
 func (r *Router) createProxy(host, listen, target string) (*Proxy, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -186,8 +280,8 @@ func (r *Router) createProxy(host, listen, target string) (*Proxy, error) {
 		return nil, err
 	}
 
-	if p, ok := r.endpoints[host].Proxies[pi]; ok {
-		return &p, nil
+	if p, ok := r.endpoints[host].Proxies[pi]; ok && pi != 0 {
+		return p, nil
 	}
 
 	p, err := ep.NewProxy(host, ul, ut)
@@ -195,7 +289,20 @@ func (r *Router) createProxy(host, listen, target string) (*Proxy, error) {
 		return nil, err
 	}
 
-	r.endpoints[host].Proxies[pi] = *p
+	p.EventHandler = r.EventHandler
+	p.Tracer = r.Tracer
+
+	// proxies on port 0 register themselves once they have a port
+	if pi != 0 {
+		r.endpoints[host].Proxies[pi] = p
+	}
+
+	// backends are often started after their proxy so only warn
+	go func() {
+		if err := p.Preflight(); err != nil {
+			logger.Log("preflight", Fields{"host": host, "listen": listen, "error": err})
+		}
+	}()
 
 	go p.Serve()
 
@@ -254,3 +361,22 @@ func writeFile(path string, data []byte) error {
 
 	return ioutil.WriteFile(path, data, 0644)
 }
+
+// certificateNames are the subject alternative names of the endpoint certificate
+func (e *Endpoint) certificateNames() ([]string, []net.IP) {
+	host := strings.ToLower(e.Host)
+
+	names := []string{host, fmt.Sprintf("*.%s", host)}
+
+	for _, a := range e.Aliases {
+		names = append(names, strings.ToLower(a))
+	}
+
+	var ips []net.IP
+
+	if e.IP != nil {
+		ips = append(ips, e.IP)
+	}
+
+	return names, ips
+}
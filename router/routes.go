@@ -1,6 +1,7 @@
 package router
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,10 +10,43 @@ import (
 	"github.com/convox/praxis/api"
 )
 
+func (rt *Router) ConnectionClose(w http.ResponseWriter, r *http.Request, c *api.Context) error {
+	p, err := rt.proxy(c.Var("host"), c.Var("port"))
+	if err != nil {
+		return err
+	}
+
+	if err := p.CloseConnection(c.Var("id")); err != nil {
+		return err
+	}
+
+	return c.RenderOK()
+}
+
+func (rt *Router) ConnectionList(w http.ResponseWriter, r *http.Request, c *api.Context) error {
+	p, err := rt.proxy(c.Var("host"), c.Var("port"))
+	if err != nil {
+		return err
+	}
+
+	return c.RenderJSON(p.ActiveConnections())
+}
+
 func (rt *Router) EndpointCreate(w http.ResponseWriter, r *http.Request, c *api.Context) error {
 	host := c.Var("host")
 
-	ep, err := rt.createEndpoint(host)
+	r.ParseForm()
+
+	aliases := r.Form["alias"]
+	delete(r.Form, "alias")
+
+	var transport TransportOptions
+
+	if err := optionTransport(&transport, r.Form); err != nil {
+		return err
+	}
+
+	ep, err := rt.createEndpoint(host, aliases, transport)
 	if err != nil {
 		return err
 	}
@@ -47,9 +81,21 @@ func (rt *Router) ProxyCreate(w http.ResponseWriter, r *http.Request, c *api.Con
 	return c.RenderJSON(p)
 }
 
+func (rt *Router) ProxyList(w http.ResponseWriter, r *http.Request, c *api.Context) error {
+	return c.RenderJSON(rt.Metrics())
+}
+
 func (rt *Router) Terminate(w http.ResponseWriter, r *http.Request, c *api.Context) error {
 	go func() {
 		time.Sleep(1 * time.Second)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		if err := rt.Shutdown(ctx); err != nil {
+			logError(err)
+		}
+
+		cancel()
 		os.Exit(0)
 	}()
 	return c.RenderOK()
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// RouteRule sends requests matching a path prefix, and optionally a method, to another rack service
+type RouteRule struct {
+	// PathPrefix matches the leading segments of the request path, the longest matching prefix wins
+	PathPrefix string
+
+	// Methods limits the rule to these http methods, empty matches any method
+	Methods []string
+
+	// Target is the rack service target, for example http://rack/app/service/api:3000
+	Target *url.URL
+}
+
+// proxyRules routes requests matching a rule to its service and everything else to fallback
+func (p *Proxy) proxyRules(fallback http.Handler) (http.Handler, error) {
+	rules := make([]RouteRule, len(p.Routes))
+	copy(rules, p.Routes)
+
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].PathPrefix) > len(rules[j].PathPrefix)
+	})
+
+	px := mux.NewRouter()
+
+	for _, rule := range rules {
+		h, err := p.proxyRackService(rule.Target)
+		if err != nil {
+			return nil, err
+		}
+
+		prefix := rule.PathPrefix
+
+		// mux path prefixes match partial segments, /api would also catch /apis
+		r := px.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+			return hasPathPrefix(req.URL.Path, prefix)
+		})
+
+		if len(rule.Methods) > 0 {
+			r = r.Methods(rule.Methods...)
+		}
+
+		r.Handler(h)
+	}
+
+	px.PathPrefix("/").Handler(fallback)
+
+	return px, nil
+}
+
+// validateRoutes checks that every rule points at a rack service from a rack http proxy
+func (p *Proxy) validateRoutes() error {
+	if len(p.Routes) == 0 {
+		return nil
+	}
+
+	if p.Listen.Scheme != "http" && p.Listen.Scheme != "https" {
+		return fmt.Errorf("route rules not supported for %s listener", p.Listen.Scheme)
+	}
+
+	if p.Target.Hostname() != "rack" {
+		return fmt.Errorf("route rules require a rack target")
+	}
+
+	for _, r := range p.Routes {
+		if !strings.HasPrefix(r.PathPrefix, "/") {
+			return fmt.Errorf("invalid route path prefix: %s", r.PathPrefix)
+		}
+
+		_, kind, _, _, err := parseRackTarget(r.Target)
+		if err != nil {
+			return err
+		}
+
+		if kind != "service" {
+			return fmt.Errorf("can only route to rack services: %s", r.Target)
+		}
+	}
+
+	return nil
+}
+
+// optionRoutes parses repeated "[METHOD,...] /prefix=/app/service/name:port" values
+func optionRoutes(rules *[]RouteRule, target *url.URL, values []string) error {
+	for _, v := range values {
+		var methods []string
+
+		if parts := strings.SplitN(strings.TrimSpace(v), " ", 2); len(parts) == 2 {
+			methods = strings.Split(strings.ToUpper(parts[0]), ",")
+			v = parts[1]
+		}
+
+		i := strings.Index(v, "=")
+		if i < 1 {
+			return fmt.Errorf("expected prefix=target")
+		}
+
+		*rules = append(*rules, RouteRule{
+			PathPrefix: strings.TrimSpace(v[0:i]),
+			Methods:    methods,
+			Target:     &url.URL{Scheme: target.Scheme, Host: target.Host, Path: strings.TrimSpace(v[i+1:])},
+		})
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package router
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceProxy(t *testing.T) {
+	client, rw := net.Pipe()
+	upr, upw := io.Pipe()
+	pr, pw := io.Pipe()
+
+	errc := make(chan error, 1)
+
+	go func() { errc <- serviceProxy(pr, rw, upw, 0) }()
+
+	// client data reaches the process and process data reaches the client
+	go client.Write([]byte("hello"))
+
+	buf := make([]byte, 5)
+
+	_, err := io.ReadFull(upr, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+
+	go pw.Write([]byte("world"))
+
+	_, err = io.ReadFull(client, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(buf))
+
+	// the end of the response closes both the client and the upload
+	pw.Close()
+
+	select {
+	case err := <-errc:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("service proxy did not finish")
+	}
+
+	data, err := ioutil.ReadAll(client)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+
+	data, err = ioutil.ReadAll(upr)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestServiceProxyClientDone(t *testing.T) {
+	client, rw := net.Pipe()
+	upr, upw := io.Pipe()
+	pr, pw := io.Pipe()
+
+	errc := make(chan error, 1)
+
+	go func() { errc <- serviceProxy(pr, rw, upw, 0) }()
+
+	go func() {
+		client.Write([]byte("request"))
+		client.Close()
+	}()
+
+	// the process sees the end of the upload while the proxy keeps waiting for its response
+	data, err := ioutil.ReadAll(upr)
+	assert.NoError(t, err)
+	assert.Equal(t, "request", string(data))
+
+	select {
+	case err := <-errc:
+		t.Fatalf("service proxy finished before the response: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	pw.Close()
+
+	select {
+	case <-errc:
+	case <-time.After(2 * time.Second):
+		t.Fatal("service proxy did not finish")
+	}
+}
@@ -0,0 +1,170 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func freePort(t *testing.T) int {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer ln.Close()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// waitListening blocks until addr accepts connections
+func waitListening(t *testing.T, addr string) {
+	for i := 0; i < 50; i++ {
+		if cn, err := net.Dial("tcp", addr); err == nil {
+			cn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	t.Fatalf("nothing listening on %s", addr)
+}
+
+func TestProxyShutdown(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		<-release
+		fmt.Fprint(w, "done")
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse(fmt.Sprintf("http://127.0.0.1:%d", freePort(t)))
+	target, _ := url.Parse(backend.URL)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	served := make(chan error, 1)
+
+	go func() { served <- p.Serve() }()
+
+	waitListening(t, listen.Host)
+
+	type result struct {
+		body string
+		err  error
+	}
+
+	inflight := make(chan result, 1)
+
+	go func() {
+		res, err := http.Get(listen.String())
+		if err != nil {
+			inflight <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+
+		data, err := ioutil.ReadAll(res.Body)
+		inflight <- result{body: string(data), err: err}
+	}()
+
+	<-received
+
+	shutdown := make(chan error, 1)
+
+	go func() { shutdown <- p.Shutdown(context.Background()) }()
+
+	// new connections are refused once the listener has closed
+	refused := false
+
+	for i := 0; i < 50; i++ {
+		cn, err := net.Dial("tcp", listen.Host)
+		if err != nil {
+			refused = true
+			break
+		}
+		cn.Close()
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert.True(t, refused)
+
+	select {
+	case <-shutdown:
+		t.Fatal("shutdown returned with a request in flight")
+	default:
+	}
+
+	close(release)
+
+	r := <-inflight
+	assert.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+
+	assert.NoError(t, <-shutdown)
+	assert.NoError(t, <-served)
+}
+
+func TestProxyShutdownTimeout(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse(fmt.Sprintf("tcp://127.0.0.1:%d", freePort(t)))
+	target, _ := url.Parse("tcp://" + backend.Addr().String())
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	go p.Serve()
+
+	var cn net.Conn
+
+	for i := 0; i < 50; i++ {
+		if cn, err = net.Dial("tcp", listen.Host); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defer cn.Close()
+
+	bc, err := backend.Accept()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defer bc.Close()
+
+	// an open tcp connection holds shutdown until the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, p.Shutdown(ctx))
+}
@@ -0,0 +1,127 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// errResponseTooLarge is returned when a backend response exceeds MaxResponseBytes
+var errResponseTooLarge = errors.New("response too large")
+
+// limitRequestBody rejects requests with a 413 once their body passes MaxRequestBytes
+func (p *Proxy) limitRequestBody(h http.Handler) http.Handler {
+	if p.MaxRequestBytes <= 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > p.MaxRequestBytes {
+			logger.Log("limit", Fields{"type": "request", "method": r.Method, "path": r.URL.Path, "size": r.ContentLength, "limit": p.MaxRequestBytes})
+			http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, p.MaxRequestBytes)
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// limitResponse fails responses whose body is larger than MaxResponseBytes
+func (p *Proxy) limitResponse(res *http.Response) error {
+	if p.MaxResponseBytes <= 0 || res.StatusCode == http.StatusSwitchingProtocols {
+		return nil
+	}
+
+	if res.ContentLength > p.MaxResponseBytes {
+		p.logResponseLimit(res, res.ContentLength)
+		return errResponseTooLarge
+	}
+
+	res.Body = &limitedBody{ReadCloser: res.Body, proxy: p, res: res, remaining: p.MaxResponseBytes}
+
+	return nil
+}
+
+func (p *Proxy) logResponseLimit(res *http.Response, size int64) {
+	fields := Fields{"type": "response", "size": size, "limit": p.MaxResponseBytes}
+
+	if res.Request != nil {
+		fields["method"] = res.Request.Method
+		fields["path"] = res.Request.URL.Path
+	}
+
+	logger.Log("limit", fields)
+}
+
+// limitedBody cuts off a streamed response body once it passes the limit
+type limitedBody struct {
+	io.ReadCloser
+
+	proxy     *Proxy
+	read      int64
+	remaining int64
+	res       *http.Response
+}
+
+func (b *limitedBody) Read(data []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, errResponseTooLarge
+	}
+
+	// read one byte past the limit to tell an exact fit from an overflow
+	if int64(len(data)) > b.remaining+1 {
+		data = data[0 : b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(data)
+
+	b.read += int64(n)
+	b.remaining -= int64(n)
+
+	if b.remaining < 0 {
+		b.proxy.logResponseLimit(b.res, b.read)
+		return n + int(b.remaining), errResponseTooLarge
+	}
+
+	return n, err
+}
+
+// proxyError answers requests the reverse proxy could not complete
+func (p *Proxy) proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	var mbe *http.MaxBytesError
+
+	if errors.As(err, &mbe) {
+		logger.Log("limit", Fields{"type": "request", "method": r.Method, "path": r.URL.Path, "limit": mbe.Limit})
+		p.renderError(w, r, http.StatusRequestEntityTooLarge, "request too large")
+		return
+	}
+
+	if err == errResponseTooLarge {
+		p.renderError(w, r, http.StatusBadGateway, "response too large")
+		return
+	}
+
+	if err == errCircuitOpen {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(coalesceDuration(p.Breaker.Cooldown, defaultBreakerCooldown).Seconds())))
+		p.renderError(w, r, http.StatusServiceUnavailable, "service unavailable")
+		return
+	}
+
+	var nbe noBackendsError
+
+	if errors.As(err, &nbe) {
+		logger.Log("proxy", Fields{"method": r.Method, "path": r.URL.Path, "error": err})
+		w.Header().Set("Retry-After", "1")
+		p.renderError(w, r, http.StatusServiceUnavailable, "no backends available")
+		return
+	}
+
+	logger.Log("proxy", Fields{"method": r.Method, "path": r.URL.Path, "error": fmt.Sprintf("proxy error: %s", err)})
+
+	p.renderError(w, r, http.StatusBadGateway, "could not reach backend")
+}
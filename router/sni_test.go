@@ -0,0 +1,139 @@
+package router
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRouter(t *testing.T) *Router {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		Subject:               pkix.Name{CommonName: "ca.test"},
+	}
+
+	data, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Router{ca: tls.Certificate{Certificate: [][]byte{data}, PrivateKey: key}, endpoints: map[string]Endpoint{}}
+}
+
+// tlsProxy serves a tls proxy for the endpoint host on a free port
+func tlsProxy(t *testing.T, r *Router, host, opts string) *Proxy {
+	e := &Endpoint{Host: host, Proxies: map[int]*Proxy{}, router: r}
+
+	listen, _ := url.Parse(fmt.Sprintf("https://127.0.0.1:%d", freePort(t)))
+	target, _ := url.Parse("http://127.0.0.1:1?" + opts)
+
+	p, err := e.NewProxy(host, listen, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go p.Serve()
+
+	waitListening(t, listen.Host)
+
+	return p
+}
+
+// serverNames returns the names on the certificate served for sni
+func serverNames(t *testing.T, p *Proxy, sni string) []string {
+	cn, err := tls.Dial("tcp", p.Listen.Host, &tls.Config{ServerName: sni, InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+
+	return cn.ConnectionState().PeerCertificates[0].DNSNames
+}
+
+func TestProxySNICertificates(t *testing.T) {
+	r := testRouter(t)
+	r.endpoints["api.convox"] = Endpoint{Host: "api.convox"}
+
+	p := tlsProxy(t, r, "web.app.convox", "")
+	defer p.Shutdown(context.Background())
+
+	assert.Equal(t, []string{"api.convox", "*.api.convox"}, serverNames(t, p, "api.convox"))
+	assert.Equal(t, []string{"api.convox", "*.api.convox"}, serverNames(t, p, "API.convox."))
+
+	// no sni or an invalid name falls back to the endpoint host
+	assert.Equal(t, []string{"web.app.convox", "*.web.app.convox"}, serverNames(t, p, ""))
+	assert.Equal(t, []string{"web.app.convox", "*.web.app.convox"}, serverNames(t, p, "bad_name.convox"))
+
+	// certificates are generated once per host
+	r.certLock.Lock()
+	assert.Equal(t, 2, r.certs.lru.Len())
+	r.certLock.Unlock()
+
+	a, _, err := r.GetCertificate("api.convox")
+	assert.NoError(t, err)
+
+	b, _, err := r.GetCertificate("api.convox")
+	assert.NoError(t, err)
+
+	assert.Equal(t, a.Certificate, b.Certificate)
+}
+
+func TestRouterGetCertificate(t *testing.T) {
+	r := testRouter(t)
+
+	cert, leaf, err := r.GetCertificate("Web.App.convox")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the parsed leaf is returned and kept on the cached certificate
+	if assert.NotNil(t, leaf) {
+		assert.Equal(t, []string{"web.app.convox", "*.web.app.convox"}, leaf.DNSNames)
+		assert.Equal(t, cert.Certificate[0], leaf.Raw)
+		assert.Equal(t, leaf, cert.Leaf)
+		assert.True(t, leaf.NotAfter.After(time.Now()))
+	}
+
+	// hosts are cached case insensitively
+	again, cached, err := r.GetCertificate("web.app.convox")
+	if assert.NoError(t, err) {
+		assert.Equal(t, cert.Certificate, again.Certificate)
+		assert.True(t, leaf == cached)
+	}
+}
+
+func TestValidHostname(t *testing.T) {
+	for host, valid := range map[string]bool{
+		"web.convox":     true,
+		"a-b.c0.convox":  true,
+		"":               false,
+		"web..convox":    false,
+		"-web.convox":    false,
+		"web-.convox":    false,
+		"we_b.convox":    false,
+		"*.web.convox":   false,
+		"web.convox:443": false,
+	} {
+		assert.Equal(t, valid, validHostname(host), host)
+	}
+}
@@ -0,0 +1,105 @@
+package router
+
+import (
+	"fmt"
+	mrand "math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WeightedTarget is a rack service target receiving a share of the requests
+type WeightedTarget struct {
+	Target *url.URL
+	Weight int
+}
+
+// splitHandler hands each request to one of handlers chosen in proportion to weights
+func splitHandler(handlers []http.Handler, weights []int) http.Handler {
+	total := 0
+
+	for _, w := range weights {
+		total += w
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := mrand.Intn(total)
+
+		for i, wt := range weights {
+			if n < wt {
+				handlers[i].ServeHTTP(w, r)
+				return
+			}
+			n -= wt
+		}
+	})
+}
+
+// proxySplit routes requests between the weighted targets, each with its own transport
+func (p *Proxy) proxySplit() (http.Handler, error) {
+	handlers := make([]http.Handler, len(p.Targets))
+	weights := make([]int, len(p.Targets))
+
+	for i, t := range p.Targets {
+		h, err := p.proxyRackService(t.Target)
+		if err != nil {
+			return nil, err
+		}
+
+		handlers[i] = h
+		weights[i] = t.Weight
+	}
+
+	return splitHandler(handlers, weights), nil
+}
+
+// validateSplit checks that every weighted target is a rack service with a positive weight
+func (p *Proxy) validateSplit() error {
+	if len(p.Targets) == 0 {
+		return nil
+	}
+
+	if p.Target.Hostname() != "rack" {
+		return fmt.Errorf("traffic splitting requires a rack target")
+	}
+
+	for _, t := range p.Targets {
+		if t.Weight < 1 {
+			return fmt.Errorf("invalid weight for %s: %d", t.Target, t.Weight)
+		}
+
+		_, kind, _, _, err := parseRackTarget(t.Target)
+		if err != nil {
+			return err
+		}
+
+		if kind != "service" {
+			return fmt.Errorf("can only split traffic between rack services: %s", t.Target)
+		}
+	}
+
+	return nil
+}
+
+// optionSplit parses repeated /app/service/name:port=weight values into targets beside the primary one
+func optionSplit(targets *[]WeightedTarget, target *url.URL, values []string) error {
+	for _, v := range values {
+		i := strings.LastIndex(v, "=")
+		if i < 1 {
+			return fmt.Errorf("expected target=weight")
+		}
+
+		w, err := strconv.Atoi(v[i+1:])
+		if err != nil {
+			return err
+		}
+
+		*targets = append(*targets, WeightedTarget{
+			Target: &url.URL{Scheme: target.Scheme, Host: target.Host, Path: v[0:i]},
+			Weight: w,
+		})
+	}
+
+	return nil
+}
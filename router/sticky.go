@@ -0,0 +1,94 @@
+package router
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultStickyCookie = "praxis_backend"
+
+// StickyOptions pins clients to the process that served their first request using a cookie
+type StickyOptions struct {
+	Enabled    bool
+	CookieName string
+	TTL        time.Duration
+}
+
+type stickyContextKey struct{}
+
+// stickySelection carries the wanted affinity key into the dialer and the chosen one back out
+type stickySelection struct {
+	want   string
+	chosen string
+}
+
+// stickyTransport routes requests carrying an affinity cookie back to the same process
+type stickyTransport struct {
+	http.RoundTripper
+	options StickyOptions
+}
+
+func (t stickyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	sel := &stickySelection{}
+
+	if c, err := req.Cookie(t.cookieName()); err == nil {
+		sel.want = c.Value
+	}
+
+	res, err := t.RoundTripper.RoundTrip(req.WithContext(context.WithValue(req.Context(), stickyContextKey{}, sel)))
+	if err != nil {
+		return nil, err
+	}
+
+	if sel.chosen != "" && sel.chosen != sel.want {
+		c := &http.Cookie{
+			Name:     t.cookieName(),
+			Value:    sel.chosen,
+			Path:     "/",
+			HttpOnly: true,
+		}
+
+		if t.options.TTL > 0 {
+			c.MaxAge = int(t.options.TTL.Seconds())
+		}
+
+		res.Header.Add("Set-Cookie", c.String())
+	}
+
+	return res, nil
+}
+
+func (t stickyTransport) cookieName() string {
+	return coalesceString(t.options.CookieName, defaultStickyCookie)
+}
+
+// stickyBackend returns the backend matching the affinity in ctx, if it is still running
+func stickyBackend(ctx context.Context, bs []Backend) *Backend {
+	sel, ok := ctx.Value(stickyContextKey{}).(*stickySelection)
+	if !ok || sel.want == "" {
+		return nil
+	}
+
+	for _, be := range bs {
+		if affinityKey(be.Id) == sel.want {
+			return &be
+		}
+	}
+
+	return nil
+}
+
+// recordSticky reports the backend a request was sent to so the affinity cookie can be set
+func recordSticky(ctx context.Context, be *Backend) {
+	if sel, ok := ctx.Value(stickyContextKey{}).(*stickySelection); ok {
+		sel.chosen = affinityKey(be.Id)
+	}
+}
+
+// affinityKey hides process ids from clients
+func affinityKey(pid string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(pid)))[0:16]
+}
@@ -0,0 +1,107 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/convox/praxis/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// stickyGet sends a request with an optional affinity cookie and returns the
+// process that served it and the affinity cookie set on the response
+func stickyGet(t *testing.T, h http.Handler, name, value string) (string, *http.Cookie) {
+	req := httptest.NewRequest("GET", "http://test.convox/", nil)
+
+	if value != "" {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if !assert.Equal(t, http.StatusOK, w.Code) {
+		t.FailNow()
+	}
+
+	// body is "app pid:port path"
+	pid := strings.Split(strings.Fields(w.Body.String())[1], ":")[0]
+
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return pid, c
+		}
+	}
+
+	return pid, nil
+}
+
+func TestProxySticky(t *testing.T) {
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}, {Id: "web-2"}, {Id: "web-3"}})
+	defer cleanup()
+
+	p, h := rackServiceHandler(t, "sticky=true&sticky_ttl=1h")
+
+	assert.True(t, p.Sticky.Enabled)
+
+	pid, c := stickyGet(t, h, defaultStickyCookie, "")
+
+	if !assert.NotNil(t, c) {
+		return
+	}
+
+	assert.Equal(t, affinityKey(pid), c.Value)
+	assert.Equal(t, 3600, c.MaxAge)
+	assert.NotContains(t, c.Value, "web")
+
+	// the cookie pins every following request to the same process
+	for i := 0; i < 5; i++ {
+		again, set := stickyGet(t, h, defaultStickyCookie, c.Value)
+		assert.Equal(t, pid, again)
+		assert.Nil(t, set)
+	}
+
+	// when the pinned process goes away the client moves to another one and is re-pinned
+	pss := types.Processes{}
+
+	for _, id := range []string{"web-1", "web-2", "web-3"} {
+		if id != pid {
+			pss = append(pss, types.Process{Id: id})
+		}
+	}
+
+	ra.setProcesses(pss)
+
+	moved, set := stickyGet(t, h, defaultStickyCookie, c.Value)
+	assert.NotEqual(t, pid, moved)
+
+	if assert.NotNil(t, set) {
+		assert.Equal(t, affinityKey(moved), set.Value)
+	}
+}
+
+func TestProxyStickyCookieName(t *testing.T) {
+	_, cleanup := newRackAPI(types.Processes{{Id: "web-1"}, {Id: "web-2"}})
+	defer cleanup()
+
+	_, h := rackServiceHandler(t, "sticky=true&sticky_cookie=affinity")
+
+	pid, c := stickyGet(t, h, "affinity", "")
+
+	if assert.NotNil(t, c) {
+		assert.Equal(t, affinityKey(pid), c.Value)
+		assert.Equal(t, 0, c.MaxAge)
+	}
+}
+
+func TestProxyStickyDisabled(t *testing.T) {
+	_, cleanup := newRackAPI(types.Processes{{Id: "web-1"}, {Id: "web-2"}})
+	defer cleanup()
+
+	_, h := rackServiceHandler(t, "")
+
+	_, c := stickyGet(t, h, defaultStickyCookie, "")
+	assert.Nil(t, c)
+}
@@ -0,0 +1,18 @@
+package router
+
+import (
+	"mime"
+	"net/http"
+)
+
+// streamingResponse is true for responses that are consumed as they arrive, like server-sent events,
+// which the reverse proxies flush after every write whatever the FlushInterval
+func streamingResponse(res *http.Response) bool {
+	return streamingType(res.Header.Get("Content-Type"))
+}
+
+func streamingType(contentType string) bool {
+	mt, _, _ := mime.ParseMediaType(contentType)
+
+	return mt == "text/event-stream"
+}
@@ -0,0 +1,115 @@
+package router
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTCPKeepAlive      = 30 * time.Second
+	defaultTCPConnectRetries = 3
+	defaultTCPConnectBackoff = 250 * time.Millisecond
+)
+
+// TCPOptions tunes the client and backend sockets of tcp proxies
+type TCPOptions struct {
+	// KeepAlive is the keepalive period, zero uses the default and negative disables keepalives
+	KeepAlive time.Duration
+
+	// NoDelay disables Nagle's algorithm, nil uses the default of true
+	NoDelay *bool
+
+	// ConnectRetries is how many times connecting to a rack resource is retried before any data was sent,
+	// zero uses the default and negative disables retries
+	ConnectRetries int
+
+	// ConnectBackoff is the delay before the first retry, doubled after each attempt
+	ConnectBackoff time.Duration
+}
+
+// acceptBackoff doubles the delay after a temporary accept error from 5ms up to 1s
+func acceptBackoff(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return 5 * time.Millisecond
+	}
+
+	if delay *= 2; delay > time.Second {
+		return time.Second
+	}
+
+	return delay
+}
+
+func (o TCPOptions) connectRetries() int {
+	switch {
+	case o.ConnectRetries < 0:
+		return 0
+	case o.ConnectRetries == 0:
+		return defaultTCPConnectRetries
+	default:
+		return o.ConnectRetries
+	}
+}
+
+// startedReader records whether anything was read so a connection is only retried before data flows
+type startedReader struct {
+	io.Reader
+	read int32
+}
+
+func (r *startedReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 {
+		atomic.StoreInt32(&r.read, 1)
+	}
+	return n, err
+}
+
+func (r *startedReader) started() bool {
+	return atomic.LoadInt32(&r.read) == 1
+}
+
+// tuneTCP applies the socket options to cn if it is backed by a tcp connection
+func (o TCPOptions) tuneTCP(cn net.Conn) {
+	tc, ok := unwrapConn(cn).(*net.TCPConn)
+	if !ok {
+		return
+	}
+
+	switch {
+	case o.KeepAlive < 0:
+		tc.SetKeepAlive(false)
+	default:
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(coalesceDuration(o.KeepAlive, defaultTCPKeepAlive))
+	}
+
+	tc.SetNoDelay(o.NoDelay == nil || *o.NoDelay)
+}
+
+// unwrapConn returns the connection underneath the router's own wrappers and tls
+func unwrapConn(cn net.Conn) net.Conn {
+	for {
+		switch t := cn.(type) {
+		case *bufferedConn:
+			cn = t.Conn
+		case meteredConn:
+			cn = t.Conn
+		case *registeredConn:
+			cn = t.Conn
+		case *nopDeadlineConn:
+			cn = t.Conn
+		case *deadlineConn:
+			cn = t.Conn
+		case *pooledConn:
+			cn = t.Conn
+		case *tls.Conn:
+			cn = t.NetConn()
+		default:
+			return cn
+		}
+	}
+}
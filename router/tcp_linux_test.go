@@ -0,0 +1,64 @@
+package router
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sockopt(t *testing.T, tc *net.TCPConn, level, opt int) int {
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v int
+
+	rc.Control(func(fd uintptr) {
+		v, err = syscall.GetsockoptInt(int(fd), level, opt)
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return v
+}
+
+func TestTuneTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+
+	tc := cn.(*net.TCPConn)
+
+	off := false
+
+	// tuning reaches through the router's connection wrappers
+	TCPOptions{KeepAlive: 45 * time.Second, NoDelay: &off}.tuneTCP(meteredConn{Conn: cn, metrics: &metrics{}})
+
+	assert.Equal(t, 1, sockopt(t, tc, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+	assert.Equal(t, 45, sockopt(t, tc, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE))
+	assert.Equal(t, 0, sockopt(t, tc, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+
+	TCPOptions{KeepAlive: -1}.tuneTCP(cn)
+
+	assert.Equal(t, 0, sockopt(t, tc, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+	assert.Equal(t, 1, sockopt(t, tc, syscall.IPPROTO_TCP, syscall.TCP_NODELAY))
+
+	TCPOptions{}.tuneTCP(cn)
+
+	assert.Equal(t, 1, sockopt(t, tc, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE))
+	assert.Equal(t, int(defaultTCPKeepAlive.Seconds()), sockopt(t, tc, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE))
+}
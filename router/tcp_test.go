@@ -0,0 +1,65 @@
+package router
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyTCPOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("tcp://0.0.0.0:5000")
+	target, _ := url.Parse("tcp://localhost:5432?tcp_keepalive=45s&tcp_nodelay=false")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 45*time.Second, p.TCP.KeepAlive)
+
+	if assert.NotNil(t, p.TCP.NoDelay) {
+		assert.False(t, *p.TCP.NoDelay)
+	}
+
+	listen, _ = url.Parse("tcp://0.0.0.0:5001")
+	target, _ = url.Parse("tcp://localhost:5432?tcp_keepalive=off")
+
+	p, err = e.NewProxy(e.Host, listen, target)
+	if assert.NoError(t, err) {
+		assert.True(t, p.TCP.KeepAlive < 0)
+		assert.Nil(t, p.TCP.NoDelay)
+	}
+}
+
+func TestUnwrapConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	cn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+
+	wrapped := &bufferedConn{Conn: meteredConn{Conn: &nopDeadlineConn{cn}, metrics: &metrics{}}, Reader: cn}
+
+	assert.Equal(t, cn, unwrapConn(wrapped))
+
+	// tls connections are tuned on the tcp connection underneath
+	assert.Equal(t, cn, unwrapConn(meteredConn{Conn: tls.Server(cn, &tls.Config{}), metrics: &metrics{}}))
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	assert.Equal(t, a, unwrapConn(a))
+}
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyTimeoutOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://localhost:5000?dial_timeout=2s&tls_handshake_timeout=3s&response_header_timeout=4s&idle_conn_timeout=5s")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, Timeouts{Dial: 2 * time.Second, TLSHandshake: 3 * time.Second, ResponseHeader: 4 * time.Second, IdleConn: 5 * time.Second}, p.Transport.Timeouts)
+	assert.Equal(t, "http://localhost:5000", p.Target.String())
+
+	tr := defaultTransport(p.Transport)
+	assert.Equal(t, 3*time.Second, tr.TLSHandshakeTimeout)
+	assert.Equal(t, 4*time.Second, tr.ResponseHeaderTimeout)
+	assert.Equal(t, 5*time.Second, tr.IdleConnTimeout)
+
+	tr = defaultTransport(TransportOptions{})
+	assert.Equal(t, defaultTimeouts.TLSHandshake, tr.TLSHandshakeTimeout)
+	assert.Equal(t, time.Duration(0), tr.ResponseHeaderTimeout)
+	assert.Equal(t, defaultTimeouts.IdleConn, tr.IdleConnTimeout)
+
+	for _, q := range []string{"dial_timeout=soon", "idle_conn_timeout=-1s"} {
+		listen, _ := url.Parse("http://0.0.0.0:81")
+		target, _ := url.Parse("http://localhost:5000?" + q)
+
+		_, err := e.NewProxy(e.Host, listen, target)
+		assert.Error(t, err, q)
+	}
+}
+
+func TestProxyResponseHeaderTimeout(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL + "?response_header_timeout=50ms")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	start := time.Now()
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://test.convox/", nil))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.True(t, time.Since(start) < 400*time.Millisecond)
+}
+
+func TestProxyTransportDefaults(t *testing.T) {
+	e := &Endpoint{
+		Host:      "test.convox",
+		Proxies:   map[int]*Proxy{},
+		Transport: TransportOptions{Timeouts: Timeouts{Dial: 2 * time.Second}, MaxIdleConns: 10, VerifyTLS: true},
+	}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse("http://localhost:5000")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, e.Transport, p.Transport)
+
+	// proxy options override the endpoint defaults one by one
+	listen, _ = url.Parse("http://0.0.0.0:81")
+	target, _ = url.Parse("http://localhost:5000?max_idle_conns=5&max_idle_conns_per_host=2&verify_tls=false")
+
+	p, err = e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, TransportOptions{Timeouts: Timeouts{Dial: 2 * time.Second}, MaxIdleConns: 5, MaxIdleConnsPerHost: 2}, p.Transport)
+
+	tr := defaultTransport(p.Transport)
+	assert.Equal(t, 5, tr.MaxIdleConns)
+	assert.Equal(t, 2, tr.MaxIdleConnsPerHost)
+	assert.True(t, tr.TLSClientConfig.InsecureSkipVerify)
+
+	tr = defaultTransport(TransportOptions{VerifyTLS: true})
+	assert.Equal(t, 100, tr.MaxIdleConns)
+	assert.False(t, tr.TLSClientConfig.InsecureSkipVerify)
+}
+
+func TestProxyVerifyTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+
+	for opts, status := range map[string]int{
+		"":                http.StatusOK,
+		"verify_tls=true": http.StatusBadGateway,
+	} {
+		e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+		listen, _ := url.Parse("http://0.0.0.0:80")
+		target, _ := url.Parse(backend.URL + "?" + opts)
+
+		p, err := e.NewProxy(e.Host, listen, target)
+		if !assert.NoError(t, err, opts) {
+			continue
+		}
+
+		h, err := p.proxyHTTP(p.Listen, p.Target)
+		if !assert.NoError(t, err, opts) {
+			continue
+		}
+
+		// the test server certificate is self signed
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "http://test.convox/", nil))
+
+		assert.Equal(t, status, w.Code, opts)
+	}
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+const defaultTLSMinVersion = tls.VersionTLS12
+
+// TLSOptions tunes the tls listener of https and tls proxies
+type TLSOptions struct {
+	// MinVersion is the lowest accepted protocol version, zero uses tls 1.2
+	MinVersion uint16
+
+	// CipherSuites restricts the negotiated suites, empty uses the go defaults
+	CipherSuites []uint16
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (p *Proxy) tlsConfig() *tls.Config {
+	cfg := &tls.Config{
+		CipherSuites:             p.TLS.CipherSuites,
+		GetCertificate:           p.getCertificate,
+		MinVersion:               p.TLS.MinVersion,
+		NextProtos:               []string{"h2"},
+		PreferServerCipherSuites: true,
+	}
+
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = defaultTLSMinVersion
+	}
+
+	return cfg
+}
+
+func optionTLSVersion(v *uint16, value string) error {
+	tv, ok := tlsVersions[value]
+	if !ok {
+		return fmt.Errorf("unknown tls version")
+	}
+
+	*v = tv
+
+	return nil
+}
+
+// optionCipherSuites parses a comma separated list of cipher suite names
+func optionCipherSuites(cs *[]uint16, value string) error {
+	suites := map[string]uint16{}
+
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		suites[s.Name] = s.ID
+	}
+
+	*cs = nil
+
+	for _, name := range strings.Split(value, ",") {
+		id, ok := suites[strings.TrimSpace(name)]
+		if !ok {
+			return fmt.Errorf("unknown cipher suite")
+		}
+
+		*cs = append(*cs, id)
+	}
+
+	return nil
+}
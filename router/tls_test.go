@@ -0,0 +1,72 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyTLSMinVersion(t *testing.T) {
+	p := tlsProxy(t, testRouter(t), "web.app.convox", "tls_min_version=1.3")
+	defer p.Shutdown(context.Background())
+
+	assert.Equal(t, uint16(tls.VersionTLS13), p.TLS.MinVersion)
+
+	_, err := tls.Dial("tcp", p.Listen.Host, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS12})
+	assert.Error(t, err)
+
+	cn, err := tls.Dial("tcp", p.Listen.Host, &tls.Config{InsecureSkipVerify: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint16(tls.VersionTLS13), cn.ConnectionState().Version)
+		cn.Close()
+	}
+}
+
+func TestProxyTLSDefaultMinVersion(t *testing.T) {
+	p := tlsProxy(t, testRouter(t), "web.app.convox", "")
+	defer p.Shutdown(context.Background())
+
+	_, err := tls.Dial("tcp", p.Listen.Host, &tls.Config{InsecureSkipVerify: true, MaxVersion: tls.VersionTLS11})
+	assert.Error(t, err)
+}
+
+func TestProxyTLSCiphers(t *testing.T) {
+	p := tlsProxy(t, testRouter(t), "web.app.convox", "tls_ciphers=TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256")
+	defer p.Shutdown(context.Background())
+
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, p.TLS.CipherSuites)
+
+	_, err := tls.Dial("tcp", p.Listen.Host, &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+	})
+	assert.Error(t, err)
+
+	cn, err := tls.Dial("tcp", p.Listen.Host, &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+		CipherSuites:       []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, cn.ConnectionState().CipherSuite)
+		cn.Close()
+	}
+}
+
+func TestProxyTLSInvalidOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("https://0.0.0.0:443")
+
+	target, _ := url.Parse("http://localhost:5000?tls_min_version=1.4")
+	_, err := e.NewProxy(e.Host, listen, target)
+	assert.EqualError(t, err, `invalid tls_min_version option "1.4": unknown tls version`)
+
+	target, _ = url.Parse("http://localhost:5000?tls_ciphers=TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,NOPE")
+	_, err = e.NewProxy(e.Host, listen, target)
+	assert.EqualError(t, err, `invalid tls_ciphers option "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,NOPE": unknown cipher suite`)
+}
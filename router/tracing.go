@@ -0,0 +1,200 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer starts a span for every proxied request. It is not an OpenTelemetry api and none is
+// vendored, an OpenTelemetry tracer and propagator have to be wrapped to implement it
+type Tracer interface {
+	// Start begins a span for r, continuing any trace in its headers, and returns the context carrying it
+	Start(r *http.Request, name string) (context.Context, Span)
+
+	// Inject writes the trace context of the span in ctx to the headers sent upstream
+	Inject(ctx context.Context, h http.Header)
+}
+
+// Span is the trace of one proxied request
+type Span interface {
+	SetAttributes(fields Fields)
+	End()
+}
+
+// traceRequests wraps every request in a span, recording its status and duration
+func (p *Proxy) traceRequests(h http.Handler) http.Handler {
+	if p.Tracer == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := p.Tracer.Start(r, fmt.Sprintf("%s %s", r.Method, p.endpoint.Host))
+		defer span.End()
+
+		start := time.Now()
+		tw := &tracedWriter{ResponseWriter: w, status: http.StatusOK}
+
+		h.ServeHTTP(tw, r.WithContext(ctx))
+
+		span.SetAttributes(Fields{
+			"http.method":      r.Method,
+			"http.host":        r.Host,
+			"http.target":      r.URL.RequestURI(),
+			"http.status_code": tw.status,
+			"duration":         time.Since(start),
+		})
+	})
+}
+
+// injectTrace passes the trace of the request in ctx on to the backend
+func (p *Proxy) injectTrace(ctx context.Context, h http.Header) {
+	if p.Tracer != nil {
+		p.Tracer.Inject(ctx, h)
+	}
+}
+
+// tracedWriter records the response status for the span
+type tracedWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (w *tracedWriter) WriteHeader(status int) {
+	if !w.wrote {
+		w.status = status
+		w.wrote = true
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *tracedWriter) Write(data []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *tracedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack records websocket upgrades as switching protocols
+func (w *tracedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+
+	if !w.wrote {
+		w.status = http.StatusSwitchingProtocols
+		w.wrote = true
+	}
+
+	return hj.Hijack()
+}
+
+func (w *tracedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+var traceparentPattern = regexp.MustCompile(`^00-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// SpanRecord is a finished span of the W3CTracer
+type SpanRecord struct {
+	Name       string
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Start      time.Time
+	Duration   time.Duration
+	Attributes Fields
+}
+
+// W3CTracer propagates W3C trace context, traceparent and tracestate, without a tracing sdk,
+// passing finished spans to Report
+type W3CTracer struct {
+	Report func(SpanRecord)
+}
+
+type traceContextKey struct{}
+
+type w3cSpan struct {
+	flags  string
+	lock   sync.Mutex
+	record SpanRecord
+	report func(SpanRecord)
+	state  string
+}
+
+func (t W3CTracer) Start(r *http.Request, name string) (context.Context, Span) {
+	s := &w3cSpan{
+		flags:  "01",
+		record: SpanRecord{Name: name, SpanID: randomHex(8), Start: time.Now(), Attributes: Fields{}},
+		report: t.Report,
+	}
+
+	if m := traceparentPattern.FindStringSubmatch(r.Header.Get("Traceparent")); m != nil && m[1] != zeroHex(32) {
+		s.record.TraceID = m[1]
+		s.record.ParentID = m[2]
+		s.flags = m[3]
+		s.state = strings.Join(r.Header["Tracestate"], ",")
+	} else {
+		s.record.TraceID = randomHex(16)
+	}
+
+	return context.WithValue(r.Context(), traceContextKey{}, s), s
+}
+
+func (t W3CTracer) Inject(ctx context.Context, h http.Header) {
+	if s, ok := ctx.Value(traceContextKey{}).(*w3cSpan); ok {
+		h.Set("Traceparent", fmt.Sprintf("00-%s-%s-%s", s.record.TraceID, s.record.SpanID, s.flags))
+
+		// vendor state only belongs to the trace it came with, a new trace starts without any
+		if s.state != "" {
+			h.Set("Tracestate", s.state)
+		} else {
+			h.Del("Tracestate")
+		}
+	}
+}
+
+func (s *w3cSpan) SetAttributes(fields Fields) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for k, v := range fields {
+		s.record.Attributes[k] = v
+	}
+}
+
+func (s *w3cSpan) End() {
+	s.lock.Lock()
+	s.record.Duration = time.Since(s.record.Start)
+	record := s.record
+	s.lock.Unlock()
+
+	if s.report != nil {
+		s.report(record)
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func zeroHex(n int) string {
+	return fmt.Sprintf("%0*d", n, 0)
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyTracing(t *testing.T) {
+	var upstream, state string
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream = r.Header.Get("Traceparent")
+		state = strings.Join(r.Header["Tracestate"], ",")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer backend.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("http://0.0.0.0:80")
+	target, _ := url.Parse(backend.URL)
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	spans := []SpanRecord{}
+
+	p.Tracer = W3CTracer{Report: func(s SpanRecord) { spans = append(spans, s) }}
+
+	h, err := p.proxyHTTP(p.Listen, p.Target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	r := httptest.NewRequest("POST", "/work", nil)
+	r.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Add("Tracestate", "congo=t61rcWkgMzE")
+	r.Header.Add("Tracestate", "rojo=00f067aa0ba902b7")
+
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if assert.Len(t, spans, 1) {
+		s := spans[0]
+
+		assert.Equal(t, "POST test.convox", s.Name)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", s.TraceID)
+		assert.Equal(t, "00f067aa0ba902b7", s.ParentID)
+		assert.Equal(t, http.StatusAccepted, s.Attributes["http.status_code"])
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+s.SpanID+"-01", upstream)
+		assert.Equal(t, "congo=t61rcWkgMzE,rojo=00f067aa0ba902b7", state)
+	}
+
+	// requests without a valid trace start a new one, dropping any tracestate
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Traceparent", "invalid")
+	r.Header.Set("Tracestate", "congo=t61rcWkgMzE")
+
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	if assert.Len(t, spans, 2) {
+		s := spans[1]
+
+		assert.Len(t, s.TraceID, 32)
+		assert.Empty(t, s.ParentID)
+		assert.True(t, strings.HasPrefix(upstream, "00-"+s.TraceID+"-"+s.SpanID))
+		assert.Empty(t, state)
+	}
+}
@@ -2,25 +2,118 @@ package router
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"time"
 )
 
-func defaultTransport() *http.Transport {
+// Timeouts bounds the phases of an upstream request, zero values use the defaults
+type Timeouts struct {
+	Dial           time.Duration `json:"dial,omitempty"`
+	TLSHandshake   time.Duration `json:"tls_handshake,omitempty"`
+	ResponseHeader time.Duration `json:"response_header,omitempty"`
+	IdleConn       time.Duration `json:"idle_conn,omitempty"`
+}
+
+// TransportOptions tunes the connections a proxy makes to its target, an endpoint's options are
+// the defaults for its proxies
+type TransportOptions struct {
+	Timeouts Timeouts `json:"timeouts"`
+
+	// MaxIdleConns caps the pooled idle connections, zero uses 100
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+
+	// MaxIdleConnsPerHost caps the pooled idle connections per backend, zero uses the net/http default
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+
+	// VerifyTLS checks the certificates of https targets
+	VerifyTLS bool `json:"verify_tls"`
+}
+
+var defaultTimeouts = Timeouts{
+	Dial:         30 * time.Second,
+	TLSHandshake: 10 * time.Second,
+	IdleConn:     90 * time.Second,
+}
+
+// upstreamScheme is the scheme of requests to http backends, unix sockets speak http unless overridden
+func (p *Proxy) upstreamScheme() string {
+	switch {
+	case p.UpstreamScheme != "":
+		return p.UpstreamScheme
+	case p.Target.Scheme == "unix":
+		return "http"
+	default:
+		return p.Target.Scheme
+	}
+}
+
+func (p *Proxy) validateUpstreamScheme() error {
+	switch {
+	case p.UpstreamScheme == "":
+		return nil
+	case p.UpstreamScheme != "http" && p.UpstreamScheme != "https":
+		return fmt.Errorf("unknown upstream scheme: %s", p.UpstreamScheme)
+	case p.Listen.Scheme != "http" && p.Listen.Scheme != "https":
+		return fmt.Errorf("upstream scheme not supported for %s listener", p.Listen.Scheme)
+	}
+
+	return nil
+}
+
+// transportOptions are the transport options of the proxy with any deprecated Timeouts applied
+func (p *Proxy) transportOptions() TransportOptions {
+	o := p.Transport
+
+	o.Timeouts = Timeouts{
+		Dial:           coalesceDuration(p.Timeouts.Dial, o.Timeouts.Dial),
+		TLSHandshake:   coalesceDuration(p.Timeouts.TLSHandshake, o.Timeouts.TLSHandshake),
+		ResponseHeader: coalesceDuration(p.Timeouts.ResponseHeader, o.Timeouts.ResponseHeader),
+		IdleConn:       coalesceDuration(p.Timeouts.IdleConn, o.Timeouts.IdleConn),
+	}
+
+	return o
+}
+
+func defaultTransport(o TransportOptions) *http.Transport {
+	t := o.Timeouts
+
 	return &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
-			Timeout:   30 * time.Second,
+			Timeout:   coalesceDuration(t.Dial, defaultTimeouts.Dial),
 			KeepAlive: 30 * time.Second,
 			DualStack: true,
 		}).DialContext,
-		MaxIdleConns:    100,
-		IdleConnTimeout: 90 * time.Second,
+		MaxIdleConns:        coalesceInt(o.MaxIdleConns, 100),
+		MaxIdleConnsPerHost: o.MaxIdleConnsPerHost,
+		IdleConnTimeout:     coalesceDuration(t.IdleConn, defaultTimeouts.IdleConn),
 		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
+			InsecureSkipVerify: !o.VerifyTLS,
 		},
-		TLSHandshakeTimeout:   10 * time.Second,
+		TLSHandshakeTimeout:   coalesceDuration(t.TLSHandshake, defaultTimeouts.TLSHandshake),
+		ResponseHeaderTimeout: coalesceDuration(t.ResponseHeader, defaultTimeouts.ResponseHeader),
 		ExpectContinueTimeout: 1 * time.Second,
 	}
 }
+
+func coalesceDuration(ds ...time.Duration) time.Duration {
+	for _, d := range ds {
+		if d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+func coalesceInt(is ...int) int {
+	for _, i := range is {
+		if i > 0 {
+			return i
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,148 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultUDPIdleTimeout = 60 * time.Second
+
+// udpSession forwards datagrams from one client and routes the replies back to it
+type udpSession struct {
+	last    int64
+	backend net.Conn
+	client  net.Addr
+}
+
+func (p *Proxy) serveUDP() error {
+	if p.Target.Hostname() == "rack" {
+		return fmt.Errorf("udp proxying is not supported for rack targets")
+	}
+
+	pc, err := net.ListenPacket("udp", p.listenAddress())
+	if err != nil {
+		return err
+	}
+
+	defer pc.Close()
+
+	p.register(pc.LocalAddr())
+
+	p.lock.Lock()
+	if p.shutdown {
+		p.lock.Unlock()
+		return nil
+	}
+	p.packet = pc
+	p.listening()
+	p.lock.Unlock()
+
+	p.emit(EventStart, nil, nil)
+
+	var lock sync.Mutex
+	sessions := map[string]*udpSession{}
+
+	defer func() {
+		lock.Lock()
+		defer lock.Unlock()
+
+		for _, s := range sessions {
+			s.backend.Close()
+		}
+	}()
+
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if p.isShutdown() {
+				return nil
+			}
+			return err
+		}
+
+		atomic.AddInt64(&p.metrics.bytesIn, int64(n))
+
+		lock.Lock()
+		s, ok := sessions[addr.String()]
+		if !ok {
+			s, err = p.udpSession(pc, addr, func(s *udpSession) {
+				lock.Lock()
+				defer lock.Unlock()
+
+				if sessions[s.client.String()] == s {
+					delete(sessions, s.client.String())
+				}
+			})
+			if err != nil {
+				lock.Unlock()
+				p.metrics.error(err)
+				logger.Log("proxy", Fields{"type": "udp", "remote": addr.String(), "error": err})
+				continue
+			}
+			sessions[addr.String()] = s
+		}
+		lock.Unlock()
+
+		atomic.StoreInt64(&s.last, time.Now().UnixNano())
+
+		if _, err := s.backend.Write(buf[0:n]); err != nil {
+			p.metrics.error(err)
+		}
+	}
+}
+
+// udpSession dials the target for a new client and relays replies until the session is idle
+func (p *Proxy) udpSession(pc net.PacketConn, client net.Addr, done func(*udpSession)) (*udpSession, error) {
+	backend, err := net.Dial("udp", p.Target.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &udpSession{backend: backend, client: client, last: time.Now().UnixNano()}
+
+	p.metrics.connOpen()
+
+	p.emit(EventAccept, client, nil)
+
+	p.conns.Add(1)
+
+	go func() {
+		defer p.conns.Done()
+		defer p.metrics.connClose()
+		defer p.emit(EventClose, client, nil)
+		defer done(s)
+		defer backend.Close()
+
+		idle := coalesceDuration(p.UDPIdleTimeout, defaultUDPIdleTimeout)
+		buf := make([]byte, 64*1024)
+
+		for {
+			backend.SetReadDeadline(time.Now().Add(idle))
+
+			n, err := backend.Read(buf)
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && time.Since(time.Unix(0, atomic.LoadInt64(&s.last))) < idle {
+				continue
+			}
+			if err != nil {
+				return
+			}
+
+			atomic.StoreInt64(&s.last, time.Now().UnixNano())
+
+			n, err = pc.WriteTo(buf[0:n], client)
+			if err != nil {
+				p.metrics.error(err)
+				return
+			}
+
+			atomic.AddInt64(&p.metrics.bytesOut, int64(n))
+		}
+	}()
+
+	return s, nil
+}
@@ -0,0 +1,128 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// udpEcho replies to every datagram with the address it came from and its payload
+func udpEcho(t *testing.T) net.PacketConn {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		buf := make([]byte, 1024)
+
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			pc.WriteTo([]byte(fmt.Sprintf("%s %s", addr, buf[0:n])), addr)
+		}
+	}()
+
+	return pc
+}
+
+func udpExchange(t *testing.T, cn net.Conn, msg string) (string, string) {
+	cn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := cn.Write([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+
+	n, err := cn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.SplitN(string(buf[0:n]), " ", 2)
+
+	return parts[0], parts[1]
+}
+
+func TestProxyUDPSessions(t *testing.T) {
+	backend := udpEcho(t)
+	defer backend.Close()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := pc.LocalAddr().String()
+	pc.Close()
+
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("udp://" + addr)
+	target, _ := url.Parse(fmt.Sprintf("udp://%s?udp_idle_timeout=200ms", backend.LocalAddr()))
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 200*time.Millisecond, p.UDPIdleTimeout)
+
+	go p.Serve()
+	defer p.Shutdown(context.Background())
+
+	time.Sleep(50 * time.Millisecond)
+
+	c1, err := net.Dial("udp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c1.Close()
+
+	c2, err := net.Dial("udp", addr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c2.Close()
+
+	// each client gets its own backend session and only its own replies
+	s1, reply := udpExchange(t, c1, "one")
+	assert.Equal(t, "one", reply)
+
+	s2, reply := udpExchange(t, c2, "two")
+	assert.Equal(t, "two", reply)
+
+	assert.NotEqual(t, s1, s2)
+
+	again, reply := udpExchange(t, c1, "three")
+	assert.Equal(t, "three", reply)
+	assert.Equal(t, s1, again)
+
+	assert.Equal(t, int64(2), p.Metrics().ActiveConnections)
+
+	// idle sessions expire and the next datagram opens a new one
+	expired := false
+
+	for i := 0; i < 50; i++ {
+		if p.Metrics().ActiveConnections == 0 {
+			expired = true
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert.True(t, expired)
+
+	fresh, reply := udpExchange(t, c1, "four")
+	assert.Equal(t, "four", reply)
+	assert.NotEqual(t, s1, fresh)
+}
@@ -0,0 +1,50 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// targetAddress is the network and address to dial for a non rack target
+func targetAddress(u *url.URL) (network, address string) {
+	if u.Scheme == "unix" {
+		return "unix", u.Path
+	}
+
+	return "tcp", u.Host
+}
+
+// validateSocket checks that a unix target points at an existing socket
+func validateSocket(u *url.URL) error {
+	if u.Path == "" {
+		return fmt.Errorf("target has no socket path: %s", u)
+	}
+
+	fi, err := os.Stat(u.Path)
+	if err != nil {
+		return fmt.Errorf("socket does not exist: %s", u.Path)
+	}
+
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("not a socket: %s", u.Path)
+	}
+
+	return nil
+}
+
+// unixTransport sends every request to the socket at path whatever the request host
+func (p *Proxy) unixTransport(path string) *http.Transport {
+	tr := defaultTransport(p.transportOptions())
+
+	d := &net.Dialer{Timeout: coalesceDuration(p.transportOptions().Timeouts.Dial, defaultTimeouts.Dial)}
+
+	tr.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return d.DialContext(ctx, "unix", path)
+	}
+
+	return tr
+}
@@ -0,0 +1,208 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/convox/praxis/types"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// wsEcho is a websocket backend echoing every message it receives
+func wsEcho(compression bool) *httptest.Server {
+	u := websocket.Upgrader{EnableCompression: compression}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		for {
+			mt, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := c.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func TestProxyWebsocketOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("https://0.0.0.0:443")
+	target, _ := url.Parse("https://rack/app/service/web:3000?ws_read_buffer_size=4096&ws_write_buffer_size=8192&ws_compression=true&ws_handshake_timeout=3s")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, WebsocketOptions{ReadBufferSize: 4096, WriteBufferSize: 8192, EnableCompression: true, HandshakeTimeout: 3 * time.Second}, p.Websocket)
+
+	u := p.upgrader()
+	assert.Equal(t, 4096, u.ReadBufferSize)
+	assert.Equal(t, 8192, u.WriteBufferSize)
+	assert.True(t, u.EnableCompression)
+	assert.Equal(t, 3*time.Second, u.HandshakeTimeout)
+
+	p.Websocket = WebsocketOptions{}
+
+	u = p.upgrader()
+	assert.Equal(t, defaultWebsocketBufferSize, u.ReadBufferSize)
+	assert.Equal(t, defaultWebsocketBufferSize, u.WriteBufferSize)
+	assert.False(t, u.EnableCompression)
+}
+
+func TestProxyWebsocket(t *testing.T) {
+	for _, compression := range []bool{false, true} {
+		backend := wsEcho(compression)
+
+		ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}})
+		ra.backend = backend.Listener.Addr().String()
+
+		opts := ""
+
+		if compression {
+			opts = "ws_compression=true"
+		}
+
+		_, h := rackServiceHandler(t, opts)
+
+		frontend := httptest.NewServer(h)
+
+		d := websocket.Dialer{EnableCompression: compression}
+
+		c, res, err := d.Dial(strings.Replace(frontend.URL, "http", "ws", 1), nil)
+		if assert.NoError(t, err) {
+			assert.Equal(t, compression, strings.Contains(res.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate"))
+
+			for _, msg := range []string{"hello", strings.Repeat("x", 10000)} {
+				assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte(msg)))
+
+				mt, data, err := c.ReadMessage()
+				assert.NoError(t, err)
+				assert.Equal(t, websocket.TextMessage, mt)
+				assert.Equal(t, msg, string(data))
+			}
+
+			c.Close()
+		}
+
+		frontend.Close()
+		cleanup()
+		backend.Close()
+	}
+}
+
+// wsRack dials a websocket through a rack service proxy with opts to an echo backend
+func wsRack(t *testing.T, opts string) (*websocket.Conn, func()) {
+	backend := wsEcho(false)
+
+	ra, cleanup := newRackAPI(types.Processes{{Id: "web-1"}})
+	ra.backend = backend.Listener.Addr().String()
+
+	_, h := rackServiceHandler(t, opts)
+
+	frontend := httptest.NewServer(h)
+
+	c, _, err := websocket.DefaultDialer.Dial(strings.Replace(frontend.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, func() {
+		c.Close()
+		frontend.Close()
+		cleanup()
+		backend.Close()
+	}
+}
+
+func TestProxyWebsocketKeepaliveOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("https://0.0.0.0:443")
+	target, _ := url.Parse("https://rack/app/service/web:3000?ws_ping_interval=10s")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 10*time.Second, p.Websocket.PingInterval)
+	assert.Equal(t, 20*time.Second, p.Websocket.readTimeout())
+
+	p.Websocket.ReadTimeout = 5 * time.Second
+	assert.Equal(t, 5*time.Second, p.Websocket.readTimeout())
+
+	assert.Equal(t, time.Duration(0), WebsocketOptions{}.readTimeout())
+}
+
+func TestProxyWebsocketPing(t *testing.T) {
+	c, cleanup := wsRack(t, "ws_ping_interval=50ms")
+	defer cleanup()
+
+	var pings int32
+
+	c.SetPingHandler(func(data string) error {
+		atomic.AddInt32(&pings, 1)
+		return c.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	messages := make(chan string, 1)
+
+	go func() {
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- string(data)
+		}
+	}()
+
+	// answering pings keeps the connection open past the read timeout
+	time.Sleep(300 * time.Millisecond)
+
+	assert.True(t, atomic.LoadInt32(&pings) >= 2)
+
+	assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("hello")))
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "hello", msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for echo")
+	}
+}
+
+func TestProxyWebsocketReadTimeout(t *testing.T) {
+	c, cleanup := wsRack(t, "ws_read_timeout=100ms")
+	defer cleanup()
+
+	start := time.Now()
+
+	// a silent peer is disconnected once the read timeout passes
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	_, _, err := c.ReadMessage()
+	assert.Error(t, err)
+
+	if ne, ok := err.(interface{ Timeout() bool }); ok {
+		assert.False(t, ne.Timeout())
+	}
+
+	assert.True(t, time.Since(start) < time.Second)
+}
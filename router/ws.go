@@ -0,0 +1,424 @@
+package router
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebsocketOptions configures both legs of a proxied websocket, zero values use the defaults
+type WebsocketOptions struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// EnableCompression negotiates permessage-deflate with clients and, for clients that offer it, with
+	// the backend. Each leg compresses on its own so messages are re-encoded as they are relayed
+	EnableCompression bool
+
+	HandshakeTimeout time.Duration
+
+	// PingInterval sends pings to both peers this often, zero disables pings
+	PingInterval time.Duration
+
+	// ReadTimeout closes the connection when a peer sends nothing, not even a pong, for this long.
+	// Zero disables the timeout unless pings are enabled, then it defaults to twice the ping interval
+	ReadTimeout time.Duration
+
+	// MaxConnectionDuration closes connections open for longer than this, zero is unlimited
+	MaxConnectionDuration time.Duration
+
+	// IdleTimeout closes connections when no data has been copied in either direction for this long, zero is unlimited
+	IdleTimeout time.Duration
+
+	// IgnoreDeadlines leaves the connection to the backend without read and write deadlines,
+	// for protocols that stream without bounds. Handshake, ping and read timeouts then do not apply to it
+	IgnoreDeadlines bool
+
+	// MaxMessageSize closes connections that send a larger message with a 1009 close code,
+	// zero uses the default of 32MB and negative disables the limit
+	MaxMessageSize int64
+}
+
+func (o WebsocketOptions) maxMessageSize() int64 {
+	switch {
+	case o.MaxMessageSize < 0:
+		return 0
+	case o.MaxMessageSize == 0:
+		return defaultWebsocketMaxMessageSize
+	default:
+		return o.MaxMessageSize
+	}
+}
+
+func (o WebsocketOptions) readTimeout() time.Duration {
+	if o.ReadTimeout == 0 {
+		return 2 * o.PingInterval
+	}
+
+	return o.ReadTimeout
+}
+
+const (
+	defaultWebsocketBufferSize     = 1024
+	defaultWebsocketMaxMessageSize = 32 * 1024 * 1024
+)
+
+func (p *Proxy) upgrader() *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:    coalesceInt(p.Websocket.ReadBufferSize, defaultWebsocketBufferSize),
+		WriteBufferSize:   coalesceInt(p.Websocket.WriteBufferSize, defaultWebsocketBufferSize),
+		EnableCompression: p.Websocket.EnableCompression,
+		HandshakeTimeout:  p.Websocket.HandshakeTimeout,
+		Error:             upgradeError,
+	}
+}
+
+// upgradeError answers a handshake the upgrader rejected before hijacking the connection,
+// failures after the hijack close the connection instead
+func upgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	w.Header().Set("Sec-Websocket-Version", "13")
+	http.Error(w, http.StatusText(status), status)
+}
+
+// checkHandshake rejects requests that could never be upgraded before a backend is dialed for them
+func checkHandshake(r *http.Request) (int, error) {
+	switch {
+	case r.Method != "GET":
+		return http.StatusMethodNotAllowed, fmt.Errorf("not a websocket handshake: request method is not GET")
+	case !websocket.IsWebSocketUpgrade(r):
+		return http.StatusBadRequest, fmt.Errorf("not a websocket handshake: missing upgrade headers")
+	case r.Header.Get("Sec-Websocket-Version") != "13":
+		return http.StatusBadRequest, fmt.Errorf("unsupported websocket version: %q", r.Header.Get("Sec-Websocket-Version"))
+	case r.Header.Get("Sec-Websocket-Key") == "":
+		return http.StatusBadRequest, fmt.Errorf("not a websocket handshake: missing Sec-Websocket-Key")
+	}
+
+	return 0, nil
+}
+
+// offersCompression is true when the client offered permessage-deflate in its handshake
+func offersCompression(r *http.Request) bool {
+	for _, v := range r.Header["Sec-Websocket-Extensions"] {
+		for _, ext := range strings.Split(v, ",") {
+			if name := strings.TrimSpace(strings.Split(ext, ";")[0]); strings.EqualFold(name, "permessage-deflate") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (p *Proxy) ws(app, service string, port int) http.HandlerFunc {
+	return p.proxyWebsocket(Fields{"app": app, "service": service, "port": port}, func(ctx context.Context) (net.Conn, error) {
+		return p.dialService(ctx, app, service, port)
+	})
+}
+
+// proxyWebsocket dials the backend first so the subprotocol it selected can be echoed to the client,
+// fields describe the backend in log events
+func (p *Proxy) proxyWebsocket(fields Fields, dial func(context.Context) (net.Conn, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p.conns.Add(1)
+		defer p.conns.Done()
+
+		p.metrics.request()
+
+		if status, err := checkHandshake(r); err != nil {
+			p.metrics.error(err)
+			p.wsLog(fields, "handshake", Fields{"status": status, "error": err})
+			upgradeError(w, r, status, err)
+			return
+		}
+
+		dialer := &websocket.Dialer{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+			},
+			ReadBufferSize:    p.Websocket.ReadBufferSize,
+			WriteBufferSize:   p.Websocket.WriteBufferSize,
+			EnableCompression: p.Websocket.EnableCompression && offersCompression(r),
+			HandshakeTimeout:  p.Websocket.HandshakeTimeout,
+			Subprotocols:      websocket.Subprotocols(r),
+		}
+
+		ctx := r.Context()
+
+		if p.Sticky.Enabled {
+			sel := &stickySelection{}
+
+			if c, err := r.Cookie(coalesceString(p.Sticky.CookieName, defaultStickyCookie)); err == nil {
+				sel.want = c.Value
+			}
+
+			ctx = context.WithValue(ctx, stickyContextKey{}, sel)
+		}
+
+		dialer.NetDial = func(network, address string) (net.Conn, error) {
+			cn, err := dial(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			if p.Websocket.IgnoreDeadlines {
+				return &nopDeadlineConn{cn}, nil
+			}
+
+			return &deadlineConn{Conn: cn}, nil
+		}
+
+		u := *r.URL
+		u.Host = p.endpoint.Host
+		u.Scheme = "wss"
+
+		if p.upstreamScheme() == "http" {
+			u.Scheme = "ws"
+		}
+
+		p.rewritePath(&u)
+
+		ensureRequestID(r.Header)
+
+		headers := http.Header{}
+
+		for k, v := range r.Header {
+			// Websocket headers to skip as they are set by the dialer and duplicates aren't allowed,
+			// requested subprotocols are passed on through the dialer
+			if k == "Upgrade" || k == "Connection" || k == "Sec-Websocket-Key" ||
+				k == "Sec-Websocket-Version" || k == "Sec-Websocket-Extensions" || k == "Sec-Websocket-Protocol" {
+				continue
+			}
+			for _, s := range v {
+				headers.Add(k, s)
+			}
+		}
+
+		p.forwardHeaders(headers, r)
+		p.injectTrace(r.Context(), headers)
+
+		// the dialer sends a Host header in place of the url host
+		if p.PreserveHost {
+			headers.Set("Host", r.Host)
+		}
+
+		backend, _, err := dialer.Dial(u.String(), headers)
+		if err != nil {
+			p.metrics.error(err)
+			p.wsLog(fields, "dial", Fields{"error": err})
+
+			var nbe noBackendsError
+
+			if errors.As(err, &nbe) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "no backends available", http.StatusServiceUnavailable)
+				return
+			}
+
+			http.Error(w, "could not connect to backend", http.StatusBadGateway)
+			return
+		}
+
+		rh := http.Header{}
+
+		if sp := backend.Subprotocol(); sp != "" {
+			rh.Set("Sec-Websocket-Protocol", sp)
+		}
+
+		frontend, err := p.upgrader().Upgrade(w, r, rh)
+		if err != nil {
+			backend.Close()
+			p.metrics.error(err)
+			p.wsLog(fields, "upgrade", Fields{"error": err})
+			return
+		}
+
+		p.metrics.connOpen()
+		defer p.metrics.connClose()
+
+		p.metrics.success()
+
+		defer frontend.Close()
+		defer backend.Close()
+
+		var once sync.Once
+		var expired string
+
+		expire := func(reason string) func() {
+			return func() {
+				once.Do(func() {
+					expired = reason
+					frontend.Close()
+					backend.Close()
+				})
+			}
+		}
+
+		if d := p.Websocket.MaxConnectionDuration; d > 0 {
+			t := time.AfterFunc(d, expire("max duration"))
+			defer t.Stop()
+		}
+
+		touch := func() {}
+
+		if d := p.Websocket.IdleTimeout; d > 0 {
+			t := time.AfterFunc(d, expire("idle"))
+			defer t.Stop()
+
+			touch = func() { t.Reset(d) }
+		}
+
+		errc := make(chan error, 2)
+
+		timeout := p.Websocket.readTimeout()
+
+		limit := p.Websocket.maxMessageSize()
+
+		// each leg negotiates compression on its own, pings need frame boundaries and
+		// message sizes are only known per message, so in those cases frames are re-encoded per message
+		if p.Websocket.EnableCompression || timeout > 0 || limit > 0 {
+			frontend.SetReadLimit(limit)
+			backend.SetReadLimit(limit)
+
+			done := make(chan struct{})
+			defer close(done)
+
+			p.wsKeepalive(frontend, done)
+			p.wsKeepalive(backend, done)
+
+			go func() { errc <- copyMessages(frontend, backend, timeout, touch) }()
+			go func() { errc <- copyMessages(backend, frontend, timeout, touch) }()
+		} else {
+			cp := func(dst io.Writer, src io.Reader) {
+				_, err := io.Copy(dst, activityReader{src, touch})
+				errc <- err
+			}
+
+			go cp(frontend.UnderlyingConn(), backend.UnderlyingConn())
+			go cp(backend.UnderlyingConn(), frontend.UnderlyingConn())
+		}
+
+		err = <-errc
+
+		once.Do(func() {})
+
+		switch {
+		case expired != "":
+			p.wsLog(fields, "timeout", Fields{"reason": expired})
+		case err != nil:
+			p.metrics.error(err)
+			p.wsLog(fields, "copy", Fields{"error": err})
+		}
+	}
+}
+
+// wsLog logs a websocket proxy event for the backend described by fields
+func (p *Proxy) wsLog(fields Fields, phase string, extra Fields) {
+	f := Fields{"type": "ws", "phase": phase}
+
+	for k, v := range fields {
+		f[k] = v
+	}
+
+	for k, v := range extra {
+		f[k] = v
+	}
+
+	logger.Log("proxy", f)
+}
+
+// wsKeepalive extends the read deadline of c on every pong and pings it until done is closed
+func (p *Proxy) wsKeepalive(c *websocket.Conn, done chan struct{}) {
+	timeout := p.Websocket.readTimeout()
+
+	if timeout > 0 {
+		c.SetPongHandler(func(string) error {
+			return c.SetReadDeadline(time.Now().Add(timeout))
+		})
+	}
+
+	if p.Websocket.PingInterval <= 0 {
+		return
+	}
+
+	go func() {
+		t := time.NewTicker(p.Websocket.PingInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(p.Websocket.PingInterval)); err != nil {
+					c.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// activityReader calls touch after every successful read
+type activityReader struct {
+	io.Reader
+	touch func()
+}
+
+func (r activityReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	if n > 0 {
+		r.touch()
+	}
+	return n, err
+}
+
+// copyMessages relays websocket messages from src to dst until src closes, calling touch for each message,
+// failing when src sends nothing for longer than a non-zero timeout
+func copyMessages(dst, src *websocket.Conn, timeout time.Duration, touch func()) error {
+	for {
+		if timeout > 0 {
+			src.SetReadDeadline(time.Now().Add(timeout))
+		}
+
+		mt, r, err := src.NextReader()
+		if err != nil {
+			if ce, ok := err.(*websocket.CloseError); ok {
+				dst.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(ce.Code, ce.Text), time.Now().Add(time.Second))
+				return nil
+			}
+			if err == websocket.ErrReadLimit {
+				dst.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(time.Second))
+			}
+			return err
+		}
+
+		w, err := dst.NextWriter(mt)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(w, r); err != nil {
+			// the limit is hit while streaming a message too large for a single frame
+			if err == websocket.ErrReadLimit {
+				dst.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseMessageTooBig, ""), time.Now().Add(time.Second))
+			}
+			return err
+		}
+
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		touch()
+	}
+}
@@ -0,0 +1,329 @@
+package router
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyWebsocketSubprotocol(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := websocket.Upgrader{Subprotocols: []string{"v2", "v1"}}
+
+		c, err := u.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		for {
+			mt, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if err := c.WriteMessage(mt, append([]byte(c.Subprotocol()+":"), data...)); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	listen, err := url.Parse("http://0.0.0.0:80")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p := &Proxy{Listen: listen, Target: &url.URL{Scheme: "https", Host: "rack"}, endpoint: &Endpoint{Host: "web.test"}, metrics: &metrics{}}
+
+	frontend := httptest.NewServer(p.proxyWebsocket(Fields{}, func(ctx context.Context) (net.Conn, error) {
+		return net.Dial("tcp", backend.Listener.Addr().String())
+	}))
+	defer frontend.Close()
+
+	d := websocket.Dialer{Subprotocols: []string{"v1", "v2"}}
+
+	c, res, err := d.Dial(strings.Replace(frontend.URL, "http://", "ws://", 1), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	assert.Equal(t, "v2", res.Header.Get("Sec-Websocket-Protocol"))
+	assert.Equal(t, "v2", c.Subprotocol())
+
+	assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("hello")))
+
+	_, data, err := c.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "v2:hello", string(data))
+
+	// clients that do not ask for a subprotocol do not get one
+	c2, res, err := websocket.DefaultDialer.Dial(strings.Replace(frontend.URL, "http://", "ws://", 1), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c2.Close()
+
+	assert.Equal(t, "", res.Header.Get("Sec-Websocket-Protocol"))
+	assert.Equal(t, "", c2.Subprotocol())
+}
+
+func TestProxyWebsocketTimeouts(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		for {
+			mt, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if err := c.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	listen, err := url.Parse("http://0.0.0.0:80")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// without compression, pings or a message size limit the connection is copied as raw bytes
+	opts := WebsocketOptions{IdleTimeout: 200 * time.Millisecond, MaxConnectionDuration: 600 * time.Millisecond, MaxMessageSize: -1}
+
+	p := &Proxy{Listen: listen, Target: &url.URL{Scheme: "https", Host: "rack"}, endpoint: &Endpoint{Host: "web.test"}, metrics: &metrics{}, Websocket: opts}
+
+	frontend := httptest.NewServer(p.proxyWebsocket(Fields{}, func(ctx context.Context) (net.Conn, error) {
+		return net.Dial("tcp", backend.Listener.Addr().String())
+	}))
+	defer frontend.Close()
+
+	// an idle connection is closed after the idle timeout
+	idle, _, err := websocket.DefaultDialer.Dial(strings.Replace(frontend.URL, "http://", "ws://", 1), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer idle.Close()
+
+	start := time.Now()
+
+	idle.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, _, err = idle.ReadMessage()
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 500*time.Millisecond, "idle connection closed after %s", time.Since(start))
+
+	// an active connection outlives the idle timeout but is closed at the max duration
+	active, _, err := websocket.DefaultDialer.Dial(strings.Replace(frontend.URL, "http://", "ws://", 1), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer active.Close()
+
+	start = time.Now()
+
+	active.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	for {
+		if err := active.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+			break
+		}
+
+		if _, _, err := active.ReadMessage(); err != nil {
+			break
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	elapsed := time.Since(start)
+
+	assert.True(t, elapsed > 400*time.Millisecond, "active connection closed after %s", elapsed)
+	assert.True(t, elapsed < 2*time.Second, "active connection closed after %s", elapsed)
+}
+
+func TestProxyWebsocketTimeoutOptions(t *testing.T) {
+	e := &Endpoint{Host: "test.convox", Proxies: map[int]*Proxy{}}
+
+	listen, _ := url.Parse("https://0.0.0.0:443")
+	target, _ := url.Parse("https://rack/app/service/web:3000?ws_max_duration=1h&ws_idle_timeout=5m")
+
+	p, err := e.NewProxy(e.Host, listen, target)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, time.Hour, p.Websocket.MaxConnectionDuration)
+	assert.Equal(t, 5*time.Minute, p.Websocket.IdleTimeout)
+}
+
+func TestProxyWebsocketMaxMessageSize(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		for {
+			mt, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if err := c.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	listen, err := url.Parse("http://0.0.0.0:80")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p := &Proxy{Listen: listen, Target: &url.URL{Scheme: "https", Host: "rack"}, endpoint: &Endpoint{Host: "web.test"}, metrics: &metrics{}, Websocket: WebsocketOptions{MaxMessageSize: 16}}
+
+	frontend := httptest.NewServer(p.proxyWebsocket(Fields{}, func(ctx context.Context) (net.Conn, error) {
+		return net.Dial("tcp", backend.Listener.Addr().String())
+	}))
+	defer frontend.Close()
+
+	c, _, err := websocket.DefaultDialer.Dial(strings.Replace(frontend.URL, "http://", "ws://", 1), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer c.Close()
+
+	assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte("small")))
+
+	_, data, err := c.ReadMessage()
+	assert.NoError(t, err)
+	assert.Equal(t, "small", string(data))
+
+	assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 32))))
+
+	_, _, err = c.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "unexpected error: %v", err)
+}
+
+func TestProxyWebsocketCompression(t *testing.T) {
+	offers := make(chan string, 2)
+
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offers <- r.Header.Get("Sec-Websocket-Extensions")
+
+		c, err := (&websocket.Upgrader{EnableCompression: true}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		for {
+			mt, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if err := c.WriteMessage(mt, data); err != nil {
+				return
+			}
+		}
+	}))
+	defer backend.Close()
+
+	listen, err := url.Parse("http://0.0.0.0:80")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p := &Proxy{Listen: listen, Target: &url.URL{Scheme: "https", Host: "rack"}, endpoint: &Endpoint{Host: "web.test"}, metrics: &metrics{}, Websocket: WebsocketOptions{EnableCompression: true}}
+
+	frontend := httptest.NewServer(p.proxyWebsocket(Fields{}, func(ctx context.Context) (net.Conn, error) {
+		return net.Dial("tcp", backend.Listener.Addr().String())
+	}))
+	defer frontend.Close()
+
+	for _, compress := range []bool{true, false} {
+		d := websocket.Dialer{EnableCompression: compress}
+
+		c, res, err := d.Dial(strings.Replace(frontend.URL, "http://", "ws://", 1), nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		offer := <-offers
+
+		if compress {
+			assert.Contains(t, offer, "permessage-deflate")
+			assert.Contains(t, res.Header.Get("Sec-Websocket-Extensions"), "permessage-deflate")
+		} else {
+			assert.Empty(t, offer)
+			assert.Empty(t, res.Header.Get("Sec-Websocket-Extensions"))
+		}
+
+		message := strings.Repeat("compressible ", 1000)
+
+		assert.NoError(t, c.WriteMessage(websocket.TextMessage, []byte(message)))
+
+		_, data, err := c.ReadMessage()
+		assert.NoError(t, err)
+		assert.Equal(t, message, string(data))
+
+		c.Close()
+	}
+}
+
+func TestProxyWebsocketPathPrefixes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		c.WriteMessage(websocket.TextMessage, []byte(r.URL.Path))
+	}))
+	defer backend.Close()
+
+	listen, err := url.Parse("http://0.0.0.0:80")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p := &Proxy{Listen: listen, Target: &url.URL{Scheme: "http", Host: "rack"}, StripPrefix: "/service", AddPrefix: "/v1", endpoint: &Endpoint{Host: "web.test"}, metrics: &metrics{}}
+
+	frontend := httptest.NewServer(p.proxyWebsocket(Fields{}, func(ctx context.Context) (net.Conn, error) {
+		return net.Dial("tcp", backend.Listener.Addr().String())
+	}))
+	defer frontend.Close()
+
+	for path, want := range map[string]string{"/service": "/v1/", "/service/socket": "/v1/socket", "/socket": "/v1/socket"} {
+		c, _, err := websocket.DefaultDialer.Dial(strings.Replace(frontend.URL, "http://", "ws://", 1)+path, nil)
+		if !assert.NoError(t, err, path) {
+			continue
+		}
+
+		_, data, err := c.ReadMessage()
+		assert.NoError(t, err, path)
+		assert.Equal(t, want, string(data), path)
+
+		c.Close()
+	}
+}
@@ -43,6 +43,7 @@ func (c *Client) ProcessGet(app, pid string) (ps *types.Process, err error) {
 func (c *Client) ProcessList(app string, opts types.ProcessListOptions) (ps types.Processes, err error) {
 	ro := RequestOptions{
 		Query: Query{
+			"release": opts.Release,
 			"service": opts.Service,
 		},
 	}
@@ -83,6 +83,7 @@ func ProcessGet(w http.ResponseWriter, r *http.Request, c *api.Context) error {
 
 func ProcessList(w http.ResponseWriter, r *http.Request, c *api.Context) error {
 	app := c.Var("app")
+	release := c.Query("release")
 	service := c.Query("service")
 
 	_, err := Provider.AppGet(app)
@@ -91,6 +92,7 @@ func ProcessList(w http.ResponseWriter, r *http.Request, c *api.Context) error {
 	}
 
 	opts := types.ProcessListOptions{
+		Release: release,
 		Service: service,
 	}
 
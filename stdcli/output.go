@@ -0,0 +1,40 @@
+package stdcli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	cli "gopkg.in/urfave/cli.v1"
+)
+
+// OutputFlag selects the output format of list commands
+var OutputFlag = cli.StringFlag{
+	Name:  "output, o",
+	Value: "table",
+	Usage: "output format: table or json",
+}
+
+// Output writes v as json when requested by the output flag and otherwise calls table
+func Output(c *cli.Context, v interface{}, table func()) error {
+	return OutputFormat(c.String("output"), v, table)
+}
+
+// OutputFormat writes v as indented json for the json format and calls table for the table format
+// or an empty one, other formats are an error
+func OutputFormat(format string, v interface{}, table func()) error {
+	switch format {
+	case "", "table":
+		table()
+	case "json":
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		Write(append(data, '\n'))
+	default:
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+
+	return nil
+}
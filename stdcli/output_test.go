@@ -0,0 +1,33 @@
+package stdcli_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/convox/praxis/stdcli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	old := stdcli.DefaultWriter.Stdout
+	stdcli.DefaultWriter.Stdout = buf
+	defer func() {
+		stdcli.DefaultWriter.Stdout = old
+	}()
+
+	table := func() { stdcli.Writef("table\n") }
+
+	err := stdcli.OutputFormat("json", []string{"foo", "bar"}, table)
+	assert.NoError(t, err)
+	assert.Equal(t, "[\n  \"foo\",\n  \"bar\"\n]\n", buf.String())
+
+	buf.Reset()
+
+	err = stdcli.OutputFormat("table", []string{"foo", "bar"}, table)
+	assert.NoError(t, err)
+	assert.Equal(t, "table\n", buf.String())
+
+	err = stdcli.OutputFormat("yaml", []string{"foo", "bar"}, table)
+	assert.EqualError(t, err, "unknown output format: yaml")
+}
@@ -132,8 +132,38 @@ func ReadSetting(setting string) string {
 	return output
 }
 
+// NoHeadersFlag leaves the header row out of table output
+var NoHeadersFlag = cli.BoolFlag{
+	Name:   "no-headers",
+	EnvVar: "CONVOX_NO_HEADERS",
+	Usage:  "omit table headers",
+}
+
 func RegisterCommand(cmd cli.Command) {
-	Commands = append(Commands, cmd)
+	Commands = append(Commands, withTableOptions(cmd))
+}
+
+// withTableOptions applies the table flags of cmd and its subcommands before they run
+func withTableOptions(cmd cli.Command) cli.Command {
+	before := cmd.Before
+
+	cmd.Before = func(c *cli.Context) error {
+		if c.Bool("no-headers") || c.GlobalBool("no-headers") {
+			DefaultWriter.NoHeaders = true
+		}
+
+		if before != nil {
+			return before(c)
+		}
+
+		return nil
+	}
+
+	for i := range cmd.Subcommands {
+		cmd.Subcommands[i] = withTableOptions(cmd.Subcommands[i])
+	}
+
+	return cmd
 }
 
 func VersionPrinter(printer func(*cli.Context)) {
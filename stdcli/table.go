@@ -21,7 +21,12 @@ func (t *Table) AddRow(values ...string) {
 }
 
 func (t *Table) Print() {
-	if !t.SkipHeaders {
+	if DefaultWriter.Plain {
+		t.printPlain()
+		return
+	}
+
+	if !t.SkipHeaders && !DefaultWriter.NoHeaders {
 		t.printHeaders(t.Headers)
 	}
 
@@ -68,6 +73,17 @@ func (t *Table) printValues(values []string) {
 	Write([]byte(line))
 }
 
+// printPlain writes tab separated rows for scripts and logs
+func (t *Table) printPlain() {
+	if !t.SkipHeaders && !DefaultWriter.NoHeaders {
+		Write([]byte(strings.Join(t.Headers, "\t") + "\n"))
+	}
+
+	for _, row := range t.Rows {
+		Write([]byte(strings.Join(row, "\t") + "\n"))
+	}
+}
+
 func interfaceSlice(ss []string) []interface{} {
 	is := make([]interface{}, len(ss))
 
@@ -13,6 +13,7 @@ func TestTableOutput(t *testing.T) {
 	buf := &bytes.Buffer{}
 	old := stdcli.DefaultWriter
 	stdcli.DefaultWriter.Color = false
+	stdcli.DefaultWriter.Plain = false
 	stdcli.DefaultWriter.Stdout = buf
 	defer func() {
 		stdcli.DefaultWriter = old
@@ -32,3 +33,28 @@ func TestTableOutput(t *testing.T) {
 	assert.Equal(t, "bar foo baz  foo", lines[2])
 	assert.Equal(t, "", lines[3])
 }
+
+func TestTablePlainOutput(t *testing.T) {
+	buf := &bytes.Buffer{}
+	old := *stdcli.DefaultWriter
+	stdcli.DefaultWriter.Plain = true
+	stdcli.DefaultWriter.Stdout = buf
+	defer func() {
+		*stdcli.DefaultWriter = old
+	}()
+
+	tb := stdcli.NewTable("FOO", "BAR")
+
+	tb.AddRow("foo bar", "baz")
+	tb.AddRow("qux", "")
+	tb.Print()
+
+	assert.Equal(t, "FOO\tBAR\nfoo bar\tbaz\nqux\t\n", buf.String())
+
+	buf.Reset()
+	stdcli.DefaultWriter.NoHeaders = true
+
+	tb.Print()
+
+	assert.Equal(t, "foo bar\tbaz\nqux\t\n", buf.String())
+}
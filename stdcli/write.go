@@ -19,11 +19,18 @@ type Writer struct {
 	Stdout io.Writer
 	Stderr io.Writer
 	Tags   map[string]Renderer
+
+	// NoHeaders leaves the header row out of tables
+	NoHeaders bool
+
+	// Plain writes tables as tab separated rows without padding, the default when stdout is not a terminal
+	Plain bool
 }
 
 func init() {
 	DefaultWriter = &Writer{
 		Color:  IsTerminal(os.Stdout),
+		Plain:  !IsTerminal(os.Stdout),
 		Stdout: os.Stdout,
 		Stderr: os.Stderr,
 		Tags: map[string]Renderer{
@@ -28,6 +28,7 @@ type ProcessExecOptions struct {
 }
 
 type ProcessListOptions struct {
+	Release string
 	Service string
 }
 